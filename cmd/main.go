@@ -1,38 +1,352 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"os"
-	"xml-programming/internal/analysis"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/format"
+	"xml-programming/internal/lsp"
 	"xml-programming/internal/parser"
-	"xml-programming/internal/vm"
+	"xml-programming/xmlp"
 )
 
+// exit codes, so scripts can tell why a program didn't run
+const (
+	exitOK       = 0
+	exitRuntime  = 1
+	exitUsage    = 2
+	exitParse    = 3
+	exitAnalysis = 4
+)
+
+const usage = `usage: xmlp <command> [flags] [arguments]
+
+commands:
+  run [-tree-walker] [-disassemble] <file> [args...]   run a program, passing args to it
+  check [-format json|text] <files...>                 parse and analyse programs without running them
+  fmt [-w] [-l] [-d] <files...>                        print programs in the canonical layout, or with
+                                                       -w rewrite them, -l list those that differ, -d show diffs
+  ast [-format json|xml|text] <file>                   print the syntax tree of a program
+  transpile <file>                                     print a program as JavaScript for Node.js
+  repl                                                 evaluate inputs interactively
+  lsp                                                  serve the Language Server Protocol over stdin and stdout
+
+A file of - reads the program from stdin. "xmlp <file> [args...]" is short for "xmlp run <file> [args...]".
+Exit codes: 1 runtime error, 2 usage or I/O error, 3 parse error, 4 analysis error.
+`
+
 func main() {
-	flag.Parse()
-	filename := flag.Arg(0)
-	file, err := os.Open(filename)
+	os.Exit(command(os.Args[1:]))
+}
+
+func command(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return run(args[1:])
+	case "check":
+		return check(args[1:])
+	case "fmt":
+		return formatFiles(args[1:])
+	case "ast":
+		return printAST(args[1:])
+	case "transpile":
+		return transpileFile(args[1:])
+	case "repl":
+		repl()
+		return exitOK
+	case "lsp":
+		return serveLSP(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		return run(args)
+	}
+}
+
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	return flags
+}
+
+// readSource reads a program from a file, or from stdin for -
+func readSource(path string) (string, []byte, error) {
+	if path == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		return "<stdin>", content, err
+	}
+	content, err := os.ReadFile(path)
+	return path, content, err
+}
+
+// diagnosticsExitCode returns the exit code for the worst diagnostic, or exitOK if there are only warnings
+func diagnosticsExitCode(diagnostics []xmlp.Diagnostic) int {
+	code := exitOK
+	for _, diagnostic := range diagnostics {
+		switch {
+		case diagnostic.Severity != xmlp.Error:
+		case diagnostic.Code == xmlp.ReadError:
+			return exitUsage
+		case diagnostic.Code == xmlp.ParseError:
+			code = exitParse
+		case code != exitParse:
+			code = exitAnalysis
+		}
+	}
+	return code
+}
+
+func run(args []string) int {
+	flags := newFlags("run")
+	treeWalker := flags.Bool("tree-walker", false, "interpret the AST instead of compiling to bytecode")
+	disassemble := flags.Bool("disassemble", false, "print the compiled bytecode before running")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	filename, content, err := readSource(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	interpreter := xmlp.New(xmlp.WithTreeWalker(*treeWalker), xmlp.WithArgs(flags.Args()[1:]))
+	program, diagnostics := interpreter.CompileFile(filename, content)
+	printDiagnostics(diagnostics)
+	if program == nil {
+		return diagnosticsExitCode(diagnostics)
+	}
+
+	if *disassemble {
+		fmt.Fprint(os.Stderr, program.Disassemble())
+	}
+
+	err = program.Run(context.Background())
+	if err != nil {
+		printError(err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+func check(args []string) int {
+	flags := newFlags("check")
+	outputFormat := flags.String("format", "json", "json for a list of diagnostics, or text for one per line")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 || (*outputFormat != "json" && *outputFormat != "text") {
+		flags.Usage()
+		return exitUsage
+	}
+
+	interpreter := xmlp.New(xmlp.WithArgs(nil))
+	diagnostics := []xmlp.Diagnostic{}
+	for _, path := range flags.Args() {
+		filename, content, err := readSource(path)
+		if err != nil {
+			// the other files are still checked
+			diagnostics = append(diagnostics, xmlp.Diagnostic{
+				Severity: xmlp.Error,
+				Code:     xmlp.ReadError,
+				Message:  err.Error(),
+				Span:     ast.Span{File: filename},
+			})
+			continue
+		}
+		_, fileDiagnostics := interpreter.CompileFile(filename, content)
+		diagnostics = append(diagnostics, fileDiagnostics...)
+	}
+
+	if *outputFormat == "text" {
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+		}
+	} else {
+		output, err := json.MarshalIndent(diagnostics, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		fmt.Println(string(output))
+	}
+	return diagnosticsExitCode(diagnostics)
+}
+
+func formatFiles(args []string) int {
+	flags := newFlags("fmt")
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	list := flags.Bool("l", false, "list the files whose formatting differs")
+	diff := flags.Bool("d", false, "print a diff of the changes instead of the result")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, path := range flags.Args() {
+		if *write && path == "-" {
+			fmt.Fprintln(os.Stderr, "can not use -w with stdin")
+			return exitUsage
+		}
+		filename, content, err := readSource(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		formatted, err := format.Source(filename, content)
+		if err != nil {
+			// the other files are still formatted
+			fmt.Fprintln(os.Stderr, err)
+			code = exitParse
+			continue
+		}
+
+		changed := !bytes.Equal(content, formatted)
+		if *list && changed {
+			fmt.Println(filename)
+		}
+		if *diff && changed {
+			os.Stdout.Write(format.Diff(filename+".orig", filename, content, formatted))
+		}
+		if *write && changed {
+			info, err := os.Stat(path)
+			if err == nil {
+				err = os.WriteFile(path, formatted, info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsage
+			}
+		}
+		if !*list && !*diff && !*write {
+			os.Stdout.Write(formatted)
+		}
+	}
+	return code
+}
+
+func printAST(args []string) int {
+	flags := newFlags("ast")
+	outputFormat := flags.String("format", "json", "json, xml or text")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	filename, content, err := readSource(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	program, err := parser.Parse(filename, content)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return exitParse
+	}
+
+	tree := ast.NewTree(program)
+	switch *outputFormat {
+	case "json":
+		var output []byte
+		output, err = json.MarshalIndent(tree, "", "  ")
+		if err == nil {
+			fmt.Println(string(output))
+		}
+	case "xml":
+		var output []byte
+		output, err = xml.MarshalIndent(tree, "", "  ")
+		if err == nil {
+			fmt.Println(string(output))
+		}
+	case "text":
+		err = ast.Fprint(os.Stdout, program)
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func transpileFile(args []string) int {
+	flags := newFlags("transpile")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
 	}
-	content, err := io.ReadAll(file)
+
+	filename, content, err := readSource(flags.Arg(0))
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
 	}
 
-	program, err := parser.Parse(content)
+	program, diagnostics := xmlp.New(xmlp.WithArgs(nil)).CompileFile(filename, content)
+	printDiagnostics(diagnostics)
+	if program == nil {
+		return diagnosticsExitCode(diagnostics)
+	}
+	output, err := program.Transpile()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return exitAnalysis
 	}
+	os.Stdout.Write(output)
+	return exitOK
+}
 
-	//json, _ := json.MarshalIndent(program, "", "  ")
-	//fmt.Println(string(json))
+func serveLSP(args []string) int {
+	flags := newFlags("lsp")
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
 
-	err = analysis.StaticAnalysis(program)
+	err := lsp.Serve(os.Stdin, os.Stdout, xmlp.New(xmlp.WithArgs(nil)))
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return exitRuntime
 	}
+	return exitOK
+}
 
-	vm.Run(program)
-}
\ No newline at end of file
+func printDiagnostics(diagnostics []xmlp.Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(os.Stderr, diagnostic)
+	}
+}
+
+// printError prints runtime errors with their stack trace; it does nothing for nil
+func printError(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+	var runtimeError *xmlp.RuntimeError
+	if errors.As(err, &runtimeError) {
+		fmt.Fprint(os.Stderr, runtimeError.StackTrace())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"xml-programming/xmlp"
+)
+
+const replHelp = `Enter statements or a single expression, which is printed. Input ends when all elements are closed.
+  :type <expr>   show the type of an expression without evaluating it
+  :ast           show the syntax tree of the last input
+  :reset         forget all declarations
+  :load <file>   run a program file, keeping what it declares
+  :help          show this help
+`
+
+// repl reads inputs from stdin and evaluates them in one session until the input ends.
+func repl() {
+	// <input/> reads from the same reader, so it doesn't lose lines the REPL has buffered
+	reader := bufio.NewReader(os.Stdin)
+	session := xmlp.New(xmlp.WithInput(reader)).NewSession()
+
+	var input strings.Builder
+	for {
+		if input.Len() == 0 {
+			fmt.Print("> ")
+		} else {
+			fmt.Print(". ")
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			fmt.Println()
+			return
+		}
+		input.WriteString(line)
+
+		text := strings.TrimSpace(input.String())
+		command, argument := "", text
+		if strings.HasPrefix(text, ":") {
+			command, argument, _ = strings.Cut(text, " ")
+			argument = strings.TrimSpace(argument)
+		}
+		if text == "" || xmlp.Incomplete([]byte(argument)) {
+			continue
+		}
+		input.Reset()
+
+		// an interrupt stops the running input, not the REPL
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		replCommand(ctx, session, command, argument)
+		stop()
+	}
+}
+
+func replCommand(ctx context.Context, session *xmlp.Session, command string, argument string) {
+	switch command {
+	case "":
+		diagnostics, err := session.Eval(ctx, "", []byte(argument))
+		printDiagnostics(diagnostics)
+		printError(err)
+	case ":type":
+		_type, diagnostics := session.TypeOf("", []byte(argument))
+		printDiagnostics(diagnostics)
+		if _type != xmlp.Invalid {
+			fmt.Println(_type)
+		}
+	case ":ast":
+		fmt.Print(session.LastAST())
+	case ":reset":
+		session.Reset()
+	case ":load":
+		content, err := os.ReadFile(argument)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		diagnostics, err := session.Load(ctx, argument, content)
+		printDiagnostics(diagnostics)
+		printError(err)
+	case ":help":
+		fmt.Print(replHelp)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s, see :help\n", command)
+	}
+}
@@ -0,0 +1,297 @@
+package analysis
+
+import (
+	"xml-programming/internal/ast"
+)
+
+// block is a basic block of the control flow graph: statements that are executed one after another.
+// Compound statements like <switch> are part of the block that evaluates their condition.
+type block struct {
+	statements []ast.Statement
+	successors []*block
+	reachable  bool
+}
+
+type graph struct {
+	entry *block
+	// end is reached by falling off the end of the body; <return> leaves without reaching it
+	end *block
+	// in the order they were created, which follows the source
+	blocks []*block
+}
+
+type loopTarget struct {
+	label  string
+	header *block
+	// blocks that end in a <break/> of the loop
+	breaks []*block
+}
+
+type graphBuilder struct {
+	a       *analyser
+	graph   *graph
+	current *block
+	// innermost last
+	loops []*loopTarget
+}
+
+// buildGraph builds the control flow graph of a function or program body.
+// Nested functions aren't part of it, they get a graph of their own.
+func (a *analyser) buildGraph(statements []ast.Statement) *graph {
+	b := &graphBuilder{
+		a:     a,
+		graph: &graph{},
+	}
+	b.graph.entry = b.newBlock()
+	b.current = b.graph.entry
+	b.buildStatements(statements)
+
+	b.graph.end = &block{}
+	b.link(b.current, b.graph.end)
+
+	b.graph.entry.mark()
+	return b.graph
+}
+
+func (b *graphBuilder) newBlock() *block {
+	block := &block{}
+	b.graph.blocks = append(b.graph.blocks, block)
+	return block
+}
+
+// link adds an edge, if the block it starts from can fall through at all
+func (b *graphBuilder) link(from *block, to *block) {
+	if from != nil {
+		from.successors = append(from.successors, to)
+	}
+}
+
+func (b *graphBuilder) add(statement ast.Statement) {
+	if b.current == nil {
+		// statements after a <return> start a block nothing leads to
+		b.current = b.newBlock()
+	}
+	b.current.statements = append(b.current.statements, statement)
+}
+
+// branch builds statements in a new block, that is entered from the given one, and returns the block they end in
+func (b *graphBuilder) branch(from *block, statements []ast.Statement) *block {
+	start := b.newBlock()
+	b.link(from, start)
+	b.current = start
+	b.buildStatements(statements)
+	return b.current
+}
+
+// join continues in a new block that all the given ones lead to
+func (b *graphBuilder) join(ends ...*block) {
+	b.current = b.newBlock()
+	for _, end := range ends {
+		b.link(end, b.current)
+	}
+}
+
+func constantCondition(expression ast.Expression) (bool, bool) {
+	literal, ok := expression.(ast.LiteralExpression)
+	if !ok || literal.Type != ast.Bool {
+		return false, false
+	}
+	return literal.Bool, true
+}
+
+func (b *graphBuilder) buildStatements(statements []ast.Statement) {
+	for _, statement := range statements {
+		b.buildStatement(statement)
+	}
+}
+
+func (b *graphBuilder) buildStatement(statement ast.Statement) {
+	switch v := statement.(type) {
+	case ast.FunctionReturnStatement:
+		b.add(v)
+		b.current = nil
+	case ast.ThrowStatement:
+		b.add(v)
+		b.current = nil
+	case ast.TryStatement:
+		b.add(v)
+		start := b.current
+
+		// a catch might be entered before any statement of the body ran
+		ends := []*block{b.branch(start, v.Body)}
+		for _, catch := range v.Catches {
+			ends = append(ends, b.branch(start, catch.Body))
+		}
+		if !v.HasFinally {
+			b.join(ends...)
+			break
+		}
+
+		// the finally also runs when the body is left by <return> or an exception,
+		// but the try only completes if the body or a catch did
+		completes := false
+		for _, end := range ends {
+			completes = completes || end != nil
+		}
+		finally := b.newBlock()
+		b.link(start, finally)
+		for _, end := range ends {
+			b.link(end, finally)
+		}
+		b.current = finally
+		b.buildStatements(v.Finally)
+		if completes {
+			b.join(b.current)
+		} else {
+			b.join()
+		}
+	case ast.BreakStatement:
+		b.add(v)
+		// without a loop to leave, there already is an error
+		target := b.loop(v.Label)
+		if target != nil {
+			target.breaks = append(target.breaks, b.current)
+			b.current = nil
+		}
+	case ast.ContinueStatement:
+		b.add(v)
+		target := b.loop(v.Label)
+		if target != nil {
+			b.link(b.current, target.header)
+			b.current = nil
+		}
+	case ast.ConditionalStatement:
+		b.add(v)
+		test := b.current
+
+		var ends []*block
+		for _, _if := range v.Ifs {
+			value, constant := constantCondition(_if.Expr)
+			if constant {
+				b.a.report(Warning, ConstantCondition, _if.Expr.GetSpan(), "condition is always %v", value)
+			}
+
+			from := test
+			if constant && !value {
+				from = nil
+			}
+			ends = append(ends, b.branch(from, _if.Then))
+
+			if constant && value {
+				// the remaining branches are never taken
+				test = nil
+			}
+		}
+		ends = append(ends, b.branch(test, v.Else))
+		b.join(ends...)
+	case ast.MatchStatement:
+		b.add(v)
+		test := b.current
+
+		var ends []*block
+		for _, _case := range v.Cases {
+			ends = append(ends, b.branch(test, _case.Body))
+		}
+		if v.HasDefault || !b.a.exhaustive[v.Span] {
+			ends = append(ends, b.branch(test, v.Default))
+		}
+		b.join(ends...)
+	case ast.LoopStatement:
+		value, constant := constantCondition(v.LoopCondition)
+		b.buildLoop(v, v.Label, v.Body, !constant || value, !constant || !value)
+	case ast.ForStatement:
+		b.buildLoop(v, v.Label, v.Body, true, true)
+	case ast.ForEachStatement:
+		b.buildLoop(v, v.Label, v.Body, true, true)
+	default:
+		b.add(v)
+	}
+}
+
+// loop returns the loop a <break/> or <continue/> with the label targets, or nil if there is none
+func (b *graphBuilder) loop(label string) *loopTarget {
+	for i := len(b.loops) - 1; i >= 0; i-- {
+		if label == "" || b.loops[i].label == label {
+			return b.loops[i]
+		}
+	}
+	return nil
+}
+
+// buildLoop adds a loop whose header evaluates the condition, and that might enter the body and exit
+func (b *graphBuilder) buildLoop(statement ast.Statement, label string, body []ast.Statement, enters bool, exits bool) {
+	previous := b.current
+	header := b.newBlock()
+	header.statements = append(header.statements, statement)
+	b.link(previous, header)
+
+	target := &loopTarget{
+		label:  label,
+		header: header,
+	}
+	b.loops = append(b.loops, target)
+
+	from := header
+	if !enters {
+		from = nil
+	}
+	end := b.branch(from, body)
+	b.link(end, header)
+
+	b.loops = b.loops[:len(b.loops)-1]
+
+	ends := target.breaks
+	if exits {
+		ends = append(ends, header)
+	}
+	b.join(ends...)
+}
+
+func (b *block) mark() {
+	if b.reachable {
+		return
+	}
+	b.reachable = true
+	for _, successor := range b.successors {
+		successor.mark()
+	}
+}
+
+// unreachable returns the first statement of every unreachable part of the graph
+func (g *graph) unreachable() []ast.Statement {
+	var statements []ast.Statement
+	covered := map[*block]bool{}
+
+	var cover func(b *block)
+	cover = func(b *block) {
+		if covered[b] || b.reachable {
+			return
+		}
+		covered[b] = true
+		for _, successor := range b.successors {
+			cover(successor)
+		}
+	}
+
+	for _, b := range g.blocks {
+		if b.reachable || covered[b] || len(b.statements) == 0 {
+			continue
+		}
+		statements = append(statements, b.statements[0])
+		cover(b)
+	}
+	return statements
+}
+
+// analyseControlFlow reports code that is never executed, and functions that might not return a value
+func (a *analyser) analyseControlFlow(statements []ast.Statement, function *ast.FunctionStatement) {
+	graph := a.buildGraph(statements)
+
+	for _, statement := range graph.unreachable() {
+		a.report(Warning, UnreachableCode, statement.GetSpan(), "statement is never executed")
+	}
+
+	if function != nil && function.Returns != ast.Void && graph.end.reachable {
+		a.errorf(MissingReturn, function.Span, "function %s might end without returning a value of type %v", function.Name, function.Returns)
+	}
+}
@@ -0,0 +1,166 @@
+package analysis_test
+
+import (
+	"strings"
+	"testing"
+	"xml-programming/internal/analysis"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/builtins"
+	"xml-programming/internal/parser"
+)
+
+// expected is a diagnostic on the nth (from 0) occurrence of text in the source
+type expected struct {
+	code analysis.Code
+	text string
+	nth  int
+}
+
+type analysisTest struct {
+	name string
+	src  string
+	want []expected
+}
+
+// span returns where the nth occurrence of text is in src
+func span(t *testing.T, src string, text string, nth int) ast.Span {
+	t.Helper()
+	offset := -1
+	for i := 0; i <= nth; i++ {
+		next := strings.Index(src[offset+1:], text)
+		if next < 0 {
+			t.Fatalf("%q isn't in the source %d times", text, nth+1)
+		}
+		offset += next + 1
+	}
+	position := func(offset int) ast.Position {
+		before := src[:offset]
+		return ast.Position{
+			Line:   strings.Count(before, "\n") + 1,
+			Column: offset - strings.LastIndex(before, "\n"),
+		}
+	}
+	return ast.Span{Start: position(offset), End: position(offset + len(text))}
+}
+
+func checkDiagnostics(t *testing.T, src string, diagnostics analysis.Diagnostics, want []expected) {
+	t.Helper()
+	if len(diagnostics) != len(want) {
+		t.Errorf("expected %d diagnostics, got %d: %v", len(want), len(diagnostics), diagnostics)
+		return
+	}
+	for i, w := range want {
+		s := span(t, src, w.text, w.nth)
+		got := diagnostics[i]
+		if got.Code != w.code || got.Span.Start != s.Start || got.Span.End != s.End {
+			t.Errorf("expected %v at %v-%v, got %v ending at %v", w.code, s.Start, s.End, got, got.Span.End)
+		}
+	}
+}
+
+func runAnalysisTests(t *testing.T, tests []analysisTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := parser.Parse("test.xml", []byte(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			diagnostics, _ := analysis.StaticAnalysis(program, builtins.Scope())
+			checkDiagnostics(t, test.src, diagnostics, test.want)
+		})
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	runAnalysisTests(t, []analysisTest{
+		{
+			name: "switch without else",
+			src: `<program>
+<func name="sign"><args><arg name="x" type="int"/><returns type="int"/></args><body>
+<switch><if><cond><gt><var name="x"/><int>0</int></gt></cond><then><return><int>1</int></return></then></if></switch>
+</body></func>
+<func name="abs"><args><arg name="x" type="int"/><returns type="int"/></args><body>
+<switch><if><cond><gt><var name="x"/><int>0</int></gt></cond><then><return><var name="x"/></return></then></if><else><then><return><sub><int>0</int><var name="x"/></sub></return></then></else></switch>
+</body></func>
+</program>`,
+			want: []expected{
+				{analysis.MissingReturn, `<func name="sign"><args><arg name="x" type="int"/><returns type="int"/></args><body>
+<switch><if><cond><gt><var name="x"/><int>0</int></gt></cond><then><return><int>1</int></return></then></if></switch>
+</body></func>`, 0},
+			},
+		},
+		{
+			name: "return in try and finally",
+			src: `<program>
+<func name="body"><args><returns type="int"/></args><body>
+<try><body><return><int>1</int></return></body><finally><body><output><string>finally</string></output></body></finally></try>
+<output><string>after</string></output>
+</body></func>
+<func name="caught"><args><returns type="int"/></args><body>
+<try><body><return><int>1</int></return></body><catch name="e" type="error"><body><output><var name="e"/></output></body></catch></try>
+</body></func>
+<func name="finally"><args><returns type="int"/></args><body>
+<try><body><output><string>body</string></output></body><finally><body><return><int>2</int></return></body></finally></try>
+</body></func>
+</program>`,
+			want: []expected{
+				{analysis.UnreachableCode, `<output><string>after</string></output>`, 0},
+				{analysis.MissingReturn, `<func name="caught"><args><returns type="int"/></args><body>
+<try><body><return><int>1</int></return></body><catch name="e" type="error"><body><output><var name="e"/></output></body></catch></try>
+</body></func>`, 0},
+			},
+		},
+		{
+			name: "loop left by break",
+			src: `<program>
+<func name="forever"><args><returns type="int"/></args><body>
+<loop><cond><bool>true</bool></cond><body><output><string>x</string></output></body></loop>
+</body></func>
+<func name="broken"><args><returns type="int"/></args><body>
+<loop><cond><bool>true</bool></cond><body><break/></body></loop>
+</body></func>
+<loop><cond><bool>true</bool></cond><body><output><string>x</string></output></body></loop>
+<output><string>never</string></output>
+</program>`,
+			want: []expected{
+				{analysis.MissingReturn, `<func name="broken"><args><returns type="int"/></args><body>
+<loop><cond><bool>true</bool></cond><body><break/></body></loop>
+</body></func>`, 0},
+				{analysis.UnreachableCode, `<output><string>never</string></output>`, 0},
+			},
+		},
+		{
+			name: "after return and throw",
+			src: `<program>
+<func name="f"><args><returns type="int"/></args><body>
+<return><int>1</int></return>
+<output><string>a</string></output>
+<output><string>b</string></output>
+</body></func>
+<throw><string>x</string></throw>
+<output><string>c</string></output>
+</program>`,
+			// only the first statement of unreachable code is reported
+			want: []expected{
+				{analysis.UnreachableCode, `<output><string>a</string></output>`, 0},
+				{analysis.UnreachableCode, `<output><string>c</string></output>`, 0},
+			},
+		},
+		{
+			name: "constant conditions",
+			src: `<program>
+<switch><if><cond><bool>true</bool></cond><then><output><string>a</string></output></then></if><else><then><output><string>b</string></output></then></else></switch>
+<switch><if><cond><bool>false</bool></cond><then><output><string>c</string></output></then></if></switch>
+<loop><cond><bool>false</bool></cond><body><output><string>d</string></output></body></loop>
+</program>`,
+			want: []expected{
+				{analysis.ConstantCondition, `<bool>true</bool>`, 0},
+				{analysis.UnreachableCode, `<output><string>b</string></output>`, 0},
+				{analysis.ConstantCondition, `<bool>false</bool>`, 0},
+				{analysis.UnreachableCode, `<output><string>c</string></output>`, 0},
+				// constant loop conditions are how infinite loops are written, so only the body is reported
+				{analysis.UnreachableCode, `<output><string>d</string></output>`, 0},
+			},
+		},
+	})
+}
@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"xml-programming/internal/ast"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Code string
+
+const (
+	ParseError            Code = "parse-error"
+	ReadError             Code = "read-error"
+	CompileError          Code = "compile-error"
+	UnknownName           Code = "unknown-name"
+	DuplicateName         Code = "duplicate-name"
+	TypeMismatch          Code = "type-mismatch"
+	ArgumentCount         Code = "argument-count"
+	InvalidOperands       Code = "invalid-operands"
+	ReturnOutsideFunction Code = "return-outside-function"
+	OutsideLoop           Code = "outside-loop"
+	NonExhaustiveMatch    Code = "non-exhaustive-match"
+	UnusedDefault         Code = "unused-default"
+	UnassignedRead        Code = "unassigned-read"
+	UninitialisedField    Code = "uninitialised-field"
+	UseBeforeDeclare      Code = "use-before-declare"
+	MissingReturn         Code = "missing-return"
+	UnreachableCode       Code = "unreachable-code"
+	ConstantCondition     Code = "constant-condition"
+	NotImplemented        Code = "not-implemented"
+)
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     Code     `json:"code"`
+	Message  string   `json:"message"`
+	Span     ast.Span `json:"span"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %v[%v]: %s", d.Span, d.Severity, d.Code, d.Message)
+}
+
+func FromError(code Code, err error) Diagnostic {
+	diagnostic := Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  err.Error(),
+	}
+
+	var positioned *ast.Error
+	if errors.As(err, &positioned) {
+		diagnostic.Span = positioned.Span
+		diagnostic.Message = positioned.Err.Error()
+		if diagnostic.Span.End.Line == 0 {
+			// only the point where the error was found is known
+			diagnostic.Span.End = diagnostic.Span.Start
+		}
+	}
+
+	return diagnostic
+}
+
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Diagnostics) Sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		a, b := d[i].Span, d[j].Span
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Start.Line != b.Start.Line {
+			return a.Start.Line < b.Start.Line
+		}
+		return a.Start.Column < b.Start.Column
+	})
+}
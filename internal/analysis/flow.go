@@ -0,0 +1,137 @@
+package analysis
+
+import (
+	"xml-programming/internal/scope"
+)
+
+type variableKey struct {
+	scope *scope.Scope
+	name  string
+}
+
+// flow is what is known about declared variables at a point in the program.
+// Only variables from <declare> are tracked, arguments and loop variables are always assigned.
+type flow struct {
+	declared    map[variableKey]bool
+	assigned    map[variableKey]bool
+	unreachable bool
+}
+
+func newFlow() flow {
+	return flow{
+		declared: map[variableKey]bool{},
+		assigned: map[variableKey]bool{},
+	}
+}
+
+func (f flow) copy() flow {
+	c := newFlow()
+	for key := range f.declared {
+		c.declared[key] = true
+	}
+	for key := range f.assigned {
+		c.assigned[key] = true
+	}
+	c.unreachable = f.unreachable
+	return c
+}
+
+// join merges the flows of two paths; only what holds on both is still known afterwards
+func (f flow) join(other flow) flow {
+	if f.unreachable {
+		return other
+	}
+	if other.unreachable {
+		return f
+	}
+
+	joined := newFlow()
+	for key := range f.declared {
+		if other.declared[key] {
+			joined.declared[key] = true
+		}
+	}
+	for key := range f.assigned {
+		if other.assigned[key] {
+			joined.assigned[key] = true
+		}
+	}
+	return joined
+}
+
+// include adds what is known after code that always runs after f's
+func (f flow) include(after flow) flow {
+	if f.unreachable || after.unreachable {
+		return flow{unreachable: true}
+	}
+
+	included := f.copy()
+	for key := range after.declared {
+		included.declared[key] = true
+	}
+	for key := range after.assigned {
+		included.assigned[key] = true
+	}
+	return included
+}
+
+func (f flow) declare(key variableKey, assigned bool) {
+	f.declared[key] = true
+	if assigned {
+		f.assigned[key] = true
+	} else {
+		delete(f.assigned, key)
+	}
+}
+
+func (f flow) assign(key variableKey) {
+	f.declared[key] = true
+	f.assigned[key] = true
+}
+
+// State is what the analysis knows about the variables of programs analysed one after the other, see StaticAnalysisInScope.
+type State struct {
+	flow          flow
+	tracked       map[variableKey]bool
+	functionReads map[variableKey][]variableKey
+}
+
+func NewState() *State {
+	return &State{
+		flow:          newFlow(),
+		tracked:       map[variableKey]bool{},
+		functionReads: map[variableKey][]variableKey{},
+	}
+}
+
+// Failed returns the state after a program that failed at runtime, which started in the state before:
+// only what holds both before and after the program is still known, since it isn't known how far it ran.
+func (s *State) Failed(before *State) *State {
+	return &State{
+		flow:          before.flow.join(s.flow),
+		tracked:       s.tracked,
+		functionReads: s.functionReads,
+	}
+}
+
+// captures collects the variables from outside of a function that it reads before assigning them
+type captures struct {
+	// the variables tracked where the function is declared
+	outer map[variableKey]bool
+	reads []variableKey
+}
+
+func newCaptures() *captures {
+	return &captures{
+		outer: map[variableKey]bool{},
+	}
+}
+
+func (c *captures) add(key variableKey) {
+	for _, read := range c.reads {
+		if read == key {
+			return
+		}
+	}
+	c.reads = append(c.reads, key)
+}
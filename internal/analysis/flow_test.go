@@ -0,0 +1,167 @@
+package analysis_test
+
+import (
+	"testing"
+	"xml-programming/internal/analysis"
+	"xml-programming/internal/builtins"
+	"xml-programming/internal/parser"
+	"xml-programming/internal/scope"
+)
+
+func TestDefiniteAssignment(t *testing.T) {
+	runAnalysisTests(t, []analysisTest{
+		{
+			name: "branches",
+			src: `<program>
+<declare name="x" type="int"/>
+<declare name="c" type="bool"/>
+<assign name="c"><bool>true</bool></assign>
+<switch><if><cond><var name="c"/></cond><then><assign name="x"><int>1</int></assign></then></if></switch>
+<output><var name="x"/></output>
+<switch><if><cond><var name="c"/></cond><then><assign name="x"><int>1</int></assign></then></if><else><then><assign name="x"><int>2</int></assign></then></else></switch>
+<output><var name="x"/></output>
+</program>`,
+			want: []expected{
+				{analysis.UnassignedRead, `<var name="x"/>`, 0},
+			},
+		},
+		{
+			name: "loops",
+			src: `<program>
+<declare name="x" type="int"/>
+<declare name="y" type="int"/>
+<for name="i" from="0" to="3"><body>
+<switch><if><cond><gt><var name="i"/><int>0</int></gt></cond><then><output><var name="y"/></output></then></if></switch>
+<assign name="x"><int>1</int></assign>
+<assign name="y"><int>1</int></assign>
+</body></for>
+<output><var name="x"/></output>
+</program>`,
+			// y is assigned in the iterations before, but not in the first one; the loop might not run at all
+			want: []expected{
+				{analysis.UnassignedRead, `<var name="y"/>`, 0},
+				{analysis.UnassignedRead, `<var name="x"/>`, 0},
+			},
+		},
+		{
+			name: "lambda",
+			src: `<program>
+<declare name="x" type="int"/>
+<declare name="f" type="func(): int"/>
+<assign name="f"><lambda><args><returns type="int"/></args><body><return><var name="x"/></return></body></lambda></assign>
+<assign name="x"><int>1</int></assign>
+<output><call><var name="f"/></call></output>
+</program>`,
+			want: []expected{
+				{analysis.UnassignedRead, `<lambda><args><returns type="int"/></args><body><return><var name="x"/></return></body></lambda>`, 0},
+			},
+		},
+		{
+			name: "function as value",
+			src: `<program>
+<declare name="x" type="int"/>
+<func name="g"><args><returns type="int"/></args><body><return><var name="x"/></return></body></func>
+<declare name="h" type="func(): int"/>
+<assign name="h"><var name="g"/></assign>
+<assign name="x"><int>1</int></assign>
+<output><call name="g"/></output>
+</program>`,
+			// calling g is fine once x is assigned, but it might be called through h before
+			want: []expected{
+				{analysis.UnassignedRead, `<var name="g"/>`, 0},
+			},
+		},
+	})
+}
+
+func TestFailedState(t *testing.T) {
+	inputs := []string{
+		`<declare name="x" type="int"/>`,
+		`<assign name="x"><int>1</int></assign><throw><string>failed</string></throw>`,
+		`<output><var name="x"/></output>`,
+	}
+
+	for _, failed := range []bool{false, true} {
+		p := parser.NewParser()
+		types := scope.FromParent(builtins.Scope())
+		state := analysis.NewState()
+		var diagnostics analysis.Diagnostics
+		for i, input := range inputs {
+			program, err := p.ParseFragment("test.xml", []byte(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := state
+			diagnostics, state = analysis.StaticAnalysisInScope(program, types, state)
+			if i == 1 && failed {
+				state = state.Failed(before)
+			}
+		}
+
+		src := inputs[2]
+		if failed {
+			// the throw might have happened before the assignment
+			checkDiagnostics(t, src, diagnostics, []expected{{analysis.UnassignedRead, `<var name="x"/>`, 0}})
+		} else {
+			checkDiagnostics(t, src, diagnostics, nil)
+		}
+	}
+}
+
+func TestLoopsAndLabels(t *testing.T) {
+	runAnalysisTests(t, []analysisTest{
+		{
+			name: "outside of loops",
+			src: `<program>
+<break/>
+<for name="i" from="0" to="3"><body>
+<func name="f"><body><continue/></body></func>
+</body></for>
+</program>`,
+			// functions can't leave the loops they are declared in
+			want: []expected{
+				{analysis.OutsideLoop, `<break/>`, 0},
+				{analysis.OutsideLoop, `<continue/>`, 0},
+			},
+		},
+		{
+			name: "labels",
+			src: `<program>
+<for name="i" from="0" to="3" label="outer"><body>
+<for name="j" from="0" to="3"><body>
+<switch><if><cond><equal><var name="j"/><int>1</int></equal></cond><then><continue label="outer"/></then></if></switch>
+<break label="inner"/>
+</body></for>
+</body></for>
+</program>`,
+			want: []expected{
+				{analysis.UnknownName, `<break label="inner"/>`, 0},
+			},
+		},
+	})
+}
+
+func TestAllDiagnostics(t *testing.T) {
+	runAnalysisTests(t, []analysisTest{
+		{
+			name: "no cascades",
+			src: `<program>
+<declare name="x" type="int"/>
+<assign name="x"><add><var name="missing"/><int>1</int></add></assign>
+<assign name="x"><string>s</string></assign>
+<output><call name="nope"/></output>
+<declare name="y" type="array&lt;int>"/>
+<assign name="y"><array type="int"><var name="gone"/></array></assign>
+<output><add><var name="y"/><int>1</int></add></output>
+</program>`,
+			// the expressions with unknown names aren't reported again as type mismatches where they are used
+			want: []expected{
+				{analysis.UnknownName, `<var name="missing"/>`, 0},
+				{analysis.TypeMismatch, `<assign name="x"><string>s</string></assign>`, 0},
+				{analysis.UnknownName, `<call name="nope"/>`, 0},
+				{analysis.UnknownName, `<var name="gone"/>`, 0},
+				{analysis.InvalidOperands, `<add><var name="y"/><int>1</int></add>`, 0},
+			},
+		},
+	})
+}
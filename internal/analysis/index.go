@@ -0,0 +1,216 @@
+package analysis
+
+import (
+	"sort"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/scope"
+)
+
+type SymbolKind int
+
+const (
+	VariableSymbol SymbolKind = iota
+	FunctionSymbol
+)
+
+// Symbol is a variable or function declared by the program, or a function it can call without declaring it.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	// Void for functions that can't be used as values, see scope.Function.HasType
+	Type ast.Type
+	// the element declaring the symbol and the attribute holding its name; both are empty for globals
+	Span ast.Span
+	Attr string
+	// the element of the function, loop or catch the symbol is declared in, or empty for the top level
+	Scope ast.Span
+}
+
+// IsGlobal reports whether the symbol isn't declared by the program, like the functions of the standard library.
+func (s *Symbol) IsGlobal() bool {
+	return s.Span.Start.Line == 0
+}
+
+// InScope reports whether the symbol can be used at position, if no other symbol of the same name shadows it there.
+func (s *Symbol) InScope(position ast.Position) bool {
+	inScope := s.Scope.Start.Line == 0 || contains(s.Scope, position)
+	return inScope && (s.IsGlobal() || s.Span.Start.Before(position))
+}
+
+// Reference is an element using a symbol by the name in the attribute Attr.
+type Reference struct {
+	Span   ast.Span
+	Attr   string
+	Symbol *Symbol
+}
+
+// Typed is an expression and its type as inferred by the analysis.
+type Typed struct {
+	Span ast.Span
+	Type ast.Type
+}
+
+// Index is what the analysis found out about the names and expressions of a program, for editors.
+type Index struct {
+	Symbols    []*Symbol
+	References []Reference
+	Types      []Typed
+}
+
+// indexer builds an Index while the analyser runs; it does nothing if it's nil
+type indexer struct {
+	index   *Index
+	symbols map[variableKey]*Symbol
+	// the elements of the scopes below the top level
+	scopes map[*scope.Scope]ast.Span
+}
+
+func newIndexer(globals *scope.Scope) *indexer {
+	i := &indexer{
+		index:   &Index{},
+		symbols: map[variableKey]*Symbol{},
+		scopes:  map[*scope.Scope]ast.Span{},
+	}
+	for s := globals; s != nil; s = s.Parent() {
+		for _, function := range s.Functions() {
+			i.declare(s, function.Name, FunctionSymbol, functionType(&function), ast.Span{}, "")
+		}
+	}
+	return i
+}
+
+func functionType(function *scope.Function) ast.Type {
+	if !function.HasType() {
+		return ast.Void
+	}
+	return function.Type()
+}
+
+// enter records that localScope belongs to the element at span
+func (i *indexer) enter(localScope *scope.Scope, span ast.Span) {
+	if i == nil {
+		return
+	}
+	i.scopes[localScope] = span
+}
+
+// declare records a symbol that was added to localScope
+func (i *indexer) declare(localScope *scope.Scope, name string, kind SymbolKind, _type ast.Type, span ast.Span, attr string) {
+	if i == nil {
+		return
+	}
+	symbol := &Symbol{
+		Name:  name,
+		Kind:  kind,
+		Type:  _type,
+		Span:  span,
+		Attr:  attr,
+		Scope: i.scopes[localScope],
+	}
+	i.index.Symbols = append(i.index.Symbols, symbol)
+	i.symbols[variableKey{localScope, name}] = symbol
+}
+
+// reference records a use of the name that was found in declaredIn
+func (i *indexer) reference(declaredIn *scope.Scope, name string, span ast.Span, attr string) {
+	if i == nil {
+		return
+	}
+	symbol := i.symbols[variableKey{declaredIn, name}]
+	if symbol == nil {
+		return
+	}
+	i.index.References = append(i.index.References, Reference{
+		Span:   span,
+		Attr:   attr,
+		Symbol: symbol,
+	})
+}
+
+// references returns how many references were recorded so far, see renameFrom
+func (i *indexer) references() int {
+	if i == nil {
+		return 0
+	}
+	return len(i.index.References)
+}
+
+// renameFrom changes the attribute of the references since the count references returned
+func (i *indexer) renameFrom(count int, attr string) {
+	if i == nil {
+		return
+	}
+	for j := count; j < len(i.index.References); j++ {
+		i.index.References[j].Attr = attr
+	}
+}
+
+func (i *indexer) typed(span ast.Span, _type ast.Type) {
+	if i == nil {
+		return
+	}
+	i.index.Types = append(i.index.Types, Typed{
+		Span: span,
+		Type: _type,
+	})
+}
+
+func contains(span ast.Span, position ast.Position) bool {
+	return !position.Before(span.Start) && position.Before(span.End)
+}
+
+// TypeAt returns the type of the innermost expression at position, and false if there is none.
+func (i *Index) TypeAt(position ast.Position) (ast.Type, bool) {
+	var found *Typed
+	for j, typed := range i.Types {
+		if contains(typed.Span, position) && (found == nil || !typed.Span.Start.Before(found.Span.Start)) {
+			found = &i.Types[j]
+		}
+	}
+	if found == nil {
+		return ast.Invalid, false
+	}
+	return found.Type, true
+}
+
+// Visible returns the symbols that can be used at position, innermost first and without those that are shadowed.
+// Names are resolved where they are used, so only symbols declared before position are visible.
+func (i *Index) Visible(position ast.Position) []*Symbol {
+	var visible []*Symbol
+	for _, symbol := range i.Symbols {
+		if symbol.InScope(position) {
+			visible = append(visible, symbol)
+		}
+	}
+
+	// globals are outermost; they are already ordered from the host functions to the standard library
+	sort.SliceStable(visible, func(a, b int) bool {
+		if visible[a].IsGlobal() || visible[b].IsGlobal() {
+			return !visible[a].IsGlobal() && visible[b].IsGlobal()
+		}
+		return visible[b].Scope.Start.Before(visible[a].Scope.Start)
+	})
+	seen := map[string]bool{}
+	unshadowed := visible[:0]
+	for _, symbol := range visible {
+		if !seen[symbol.Name] {
+			seen[symbol.Name] = true
+			unshadowed = append(unshadowed, symbol)
+		}
+	}
+	return unshadowed
+}
+
+// ReferencesTo returns the references of symbol, in the order they appear in the program.
+func (i *Index) ReferencesTo(symbol *Symbol) []Reference {
+	var references []Reference
+	for _, reference := range i.References {
+		if reference.Symbol == symbol {
+			references = append(references, reference)
+		}
+	}
+	sort.SliceStable(references, func(a, b int) bool {
+		return references[a].Span.Start.Before(references[b].Span.Start)
+	})
+	return references
+}
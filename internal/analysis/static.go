@@ -2,167 +2,765 @@ package analysis
 
 import (
 	"fmt"
+	"strings"
 	"xml-programming/internal/ast"
 	"xml-programming/internal/scope"
 	"xml-programming/internal/values"
 )
 
-func analyseArithmetic(types []ast.Type) (ast.Type, error) {
-	isFloat := false
+type analyser struct {
+	diagnostics Diagnostics
+	records     map[ast.Type]*ast.RecordDeclaration
+
+	flow flow
+	// variables that were declared with <declare>
+	tracked map[variableKey]bool
+	// matches that cover all variants of their enum
+	exhaustive map[ast.Span]bool
+	// the enums matches are on, for the compiler
+	matched MatchedEnums
+	// labels of the loops around the current statement, innermost last
+	loops []string
+	// nil outside of functions
+	captures *captures
+	// the variable the current assignment assigns to, which functions created by it can read, like to recurse
+	assigning variableKey
+	// the variables from outside of each function that it reads, by the scope the function is declared in and its name
+	functionReads map[variableKey][]variableKey
+
+	// nil unless an Index was asked for
+	index *indexer
+}
+
+// arrays and maps start out empty, and optionals as none, everything else has to be assigned before it's read
+func needsAssignment(_type ast.Type) bool {
+	return !_type.IsOptional() && !_type.IsArray() && !_type.IsMap()
+}
+
+func (a *analyser) report(severity Severity, code Code, span ast.Span, format string, args ...interface{}) {
+	a.diagnostics = append(a.diagnostics, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	})
+}
+
+func (a *analyser) errorf(code Code, span ast.Span, format string, args ...interface{}) {
+	a.report(Error, code, span, format, args...)
+}
+
+func hasInvalid(types []ast.Type) bool {
+	for _, _type := range types {
+		if _type == ast.Invalid {
+			return true
+		}
+	}
+	return false
+}
+
+// analysePromotion returns the type ast.Promote converts all operands to
+func (a *analyser) analysePromotion(types []ast.Type, what string, span ast.Span) ast.Type {
+	result := types[0]
 	for _, _type := range types {
 		if !_type.IsNumber() {
-			return ast.Void, fmt.Errorf("can't do arithmetic on non-number types")
+			a.errorf(InvalidOperands, span, "can't %s non-number type %v", what, _type)
+			return ast.Invalid
 		}
-		if _type == ast.Float {
-			isFloat = true
+		promoted, ok := ast.Promote(result, _type)
+		if !ok {
+			a.errorf(InvalidOperands, span, "can't %s %v and %v without a <cast>", what, result, _type)
+			return ast.Invalid
 		}
+		result = promoted
 	}
-	if isFloat {
-		return ast.Float, nil
-	} else {
-		return ast.Int, nil
-	}
+	return result
+}
+
+func (a *analyser) analyseArithmetic(types []ast.Type, span ast.Span) ast.Type {
+	return a.analysePromotion(types, "do arithmetic on", span)
 }
 
-func analyseComparison(types []ast.Type, operator ast.Operator) (ast.Type, error) {
+// analyseComparison only promotes numbers, everything else has to be converted with <cast> first
+func (a *analyser) analyseComparison(types []ast.Type, operator ast.Operator, span ast.Span) ast.Type {
 	if operator != ast.Equal {
-		for _, _type := range types {
-			if !_type.IsNumber() {
-				return ast.Void, fmt.Errorf("can not compare non-number types")
-			}
+		if a.analysePromotion(types, "compare", span) == ast.Invalid {
+			return ast.Invalid
+		}
+		return ast.Bool
+	}
+	for _, _type := range types {
+		if !_type.IsComparable() && !_type.IsNumber() {
+			a.errorf(InvalidOperands, span, "can not compare %v", _type)
+			return ast.Invalid
 		}
 	}
-	return ast.Bool, nil
+	if _, ok := ast.Promote(types[0], types[1]); !ok {
+		a.errorf(InvalidOperands, span, "can not compare %v with %v", types[0], types[1])
+		return ast.Invalid
+	}
+	return ast.Bool
 }
 
-func analyseLogic(types []ast.Type) (ast.Type, error) {
+func (a *analyser) analyseLogic(types []ast.Type, span ast.Span) ast.Type {
 	for _, _type := range types {
 		if _type != ast.Bool {
-			return ast.Void, fmt.Errorf("can only do logic on bools")
+			a.errorf(InvalidOperands, span, "can only do logic on bools, not %v", _type)
+			return ast.Invalid
 		}
 	}
-	return ast.Bool, nil
+	return ast.Bool
 }
 
-func analyseExpression(expression ast.Expression, localScope *scope.Scope) (ast.Type, error) {
-	switch v := expression.(type) {
-	case ast.LiteralExpression:
-		return v.Type, nil
-	case ast.VariableExpression:
-		variable := localScope.GetVariable(v.Name)
-		if variable == nil {
-			return ast.Void, fmt.Errorf("name %s not found in local scope", v.Name)
+func (a *analyser) expectArity(types []ast.Type, arity int, name string, span ast.Span) bool {
+	if len(types) != arity {
+		if arity == 1 {
+			a.errorf(ArgumentCount, span, "%s is unary", name)
+		} else {
+			a.errorf(ArgumentCount, span, "%s is binary", name)
 		}
-		return variable.Type, nil
-	case ast.FunctionCall:
-		function := localScope.GetFunction(v.Name)
-		if function == nil {
-			return ast.Void, fmt.Errorf("name %s not found in local scope", v.Name)
-		}
-		if len(function.Args) != len(v.Args) {
-			return ast.Void, fmt.Errorf("mismatched number of arguments for name %s", v.Name)
-		}
-		for i, _ := range function.Args {
-			expectedType := function.Args[i]
-			actualType, err := analyseExpression(v.Args[i], localScope)
-			if err != nil {
-				return ast.Void, err
+		return false
+	}
+	return true
+}
+
+func (a *analyser) analyseOperator(expression ast.OperatorExpression, types []ast.Type) ast.Type {
+	span := expression.Span
+
+	if len(types) < 1 {
+		a.errorf(ArgumentCount, span, "operator with no arguments is not valid")
+		return ast.Invalid
+	}
+	if hasInvalid(types) {
+		return ast.Invalid
+	}
+
+	switch expression.Operator {
+	case ast.Add:
+		return a.analyseArithmetic(types, span)
+	case ast.Sub:
+		if !a.expectArity(types, 2, "sub", span) {
+			return ast.Invalid
+		}
+		return a.analyseArithmetic(types, span)
+	case ast.Mul:
+		return a.analyseArithmetic(types, span)
+	case ast.Div:
+		if !a.expectArity(types, 2, "div", span) {
+			return ast.Invalid
+		}
+		return a.analyseArithmetic(types, span)
+	case ast.Mod:
+		if !a.expectArity(types, 2, "mod", span) {
+			return ast.Invalid
+		}
+		_type := a.analyseArithmetic(types, span)
+		if _type != ast.Invalid && !_type.IsInteger() {
+			a.errorf(InvalidOperands, span, "mod only supports integers, not %v", _type)
+			return ast.Invalid
+		}
+		return _type
+	case ast.Concat:
+		for _, _type := range types {
+			if !ast.CanCast(_type, ast.String) {
+				a.errorf(InvalidOperands, span, "can not concat %v", _type)
+				return ast.Invalid
 			}
-			if expectedType.Type != actualType {
-				return ast.Void, fmt.Errorf("mismatched types for argument %d of name %s", i + 1, v.Name)
+		}
+		return ast.String
+	case ast.Equal:
+		if !a.expectArity(types, 2, "equal", span) {
+			return ast.Invalid
+		}
+		return a.analyseComparison(types, expression.Operator, span)
+	case ast.LessThan:
+		if !a.expectArity(types, 2, "less than", span) {
+			return ast.Invalid
+		}
+		return a.analyseComparison(types, expression.Operator, span)
+	case ast.GreaterThan:
+		if !a.expectArity(types, 2, "greater than", span) {
+			return ast.Invalid
+		}
+		return a.analyseComparison(types, expression.Operator, span)
+	case ast.Not:
+		if !a.expectArity(types, 1, "not", span) {
+			return ast.Invalid
+		}
+		return a.analyseLogic(types, span)
+	case ast.And:
+		return a.analyseLogic(types, span)
+	case ast.Or:
+		return a.analyseLogic(types, span)
+	default:
+		a.errorf(NotImplemented, span, "operator not yet implemented")
+		return ast.Invalid
+	}
+}
+
+func (a *analyser) analyseArrayLiteral(literal ast.ArrayLiteral, localScope *scope.Scope) ast.Type {
+	types := a.analyseExpressionList(literal.Elems, localScope)
+
+	elemType := literal.ElemType
+	if elemType == ast.Void {
+		if len(types) == 0 {
+			a.errorf(TypeMismatch, literal.Span, "can not infer the element type of an empty array, use the type attribute")
+			return ast.Invalid
+		}
+		elemType = types[0]
+		if elemType == ast.Invalid {
+			return ast.Invalid
+		}
+		if elemType == ast.Void {
+			a.errorf(TypeMismatch, literal.Elems[0].GetSpan(), "array elements can not be void")
+			return ast.Invalid
+		}
+	}
+
+	for i, _type := range types {
+		if _type != ast.Invalid && !_type.AssignableTo(elemType) {
+			a.errorf(TypeMismatch, literal.Elems[i].GetSpan(), "mismatched types for array element %d: expected %v, got %v", i+1, elemType, _type)
+		}
+	}
+	return ast.ArrayOf(elemType)
+}
+
+// analyseOptional returns the type of an expression that has to be an optional, or Invalid
+func (a *analyser) analyseOptional(expression ast.Expression, localScope *scope.Scope) ast.Type {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && !_type.IsOptional() {
+		a.errorf(TypeMismatch, expression.GetSpan(), "expected an optional, got %v", _type)
+		return ast.Invalid
+	}
+	return _type
+}
+
+// analyseArray returns the type of an expression that has to be an array, or Invalid
+func (a *analyser) analyseArray(expression ast.Expression, localScope *scope.Scope) ast.Type {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && !_type.IsArray() {
+		a.errorf(TypeMismatch, expression.GetSpan(), "expected an array, got %v", _type)
+		return ast.Invalid
+	}
+	return _type
+}
+
+func (a *analyser) analyseMapLiteral(literal ast.MapLiteral, localScope *scope.Scope) ast.Type {
+	keyType := literal.KeyType
+	valueType := literal.ValueType
+
+	for i, entry := range literal.Entries {
+		entryKeyType := a.analyseExpression(entry.Key, localScope)
+		entryValueType := a.analyseExpression(entry.Value, localScope)
+
+		if i == 0 && keyType == ast.Void {
+			keyType = entryKeyType
+			if keyType != ast.Invalid && !keyType.IsComparable() {
+				a.errorf(TypeMismatch, entry.Key.GetSpan(), "map keys have to be strings, bools, fixed width numbers or enums, not %v", keyType)
+				keyType = ast.Invalid
 			}
+		} else if entryKeyType != ast.Invalid && keyType != ast.Invalid && entryKeyType != keyType {
+			a.errorf(TypeMismatch, entry.Key.GetSpan(), "mismatched types for key of map entry %d: expected %v, got %v", i+1, keyType, entryKeyType)
 		}
 
-		return function.Return, nil
-	case ast.OperatorExpression:
-		types, err := analyseExpressionist(v.Exprs, localScope)
-		if err != nil {
-			return ast.Void, err
+		if i == 0 && valueType == ast.Void {
+			valueType = entryValueType
+			if valueType == ast.Void {
+				a.errorf(TypeMismatch, entry.Value.GetSpan(), "map values can not be void")
+				valueType = ast.Invalid
+			}
+		} else if entryValueType != ast.Invalid && valueType != ast.Invalid && !entryValueType.AssignableTo(valueType) {
+			a.errorf(TypeMismatch, entry.Value.GetSpan(), "mismatched types for value of map entry %d: expected %v, got %v", i+1, valueType, entryValueType)
 		}
+	}
+
+	if keyType == ast.Void || valueType == ast.Void {
+		a.errorf(TypeMismatch, literal.Span, "can not infer the types of an empty map, use the key and value attributes")
+		return ast.Invalid
+	}
+	if keyType == ast.Invalid || valueType == ast.Invalid {
+		return ast.Invalid
+	}
+	return ast.MapOf(keyType, valueType)
+}
+
+// analyseMap returns the type of an expression that has to be a map, or Invalid
+func (a *analyser) analyseMap(expression ast.Expression, localScope *scope.Scope) ast.Type {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && !_type.IsMap() {
+		a.errorf(TypeMismatch, expression.GetSpan(), "expected a map, got %v", _type)
+		return ast.Invalid
+	}
+	return _type
+}
+
+func (a *analyser) analyseKey(expression ast.Expression, mapType ast.Type, localScope *scope.Scope) {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && mapType != ast.Invalid && _type != mapType.Key() {
+		a.errorf(TypeMismatch, expression.GetSpan(), "type mismatch on key of %v: got %v", mapType, _type)
+	}
+}
+
+func (a *analyser) analyseRecords(records []ast.RecordDeclaration) {
+	for i, record := range records {
+		a.records[record.Type] = &records[i]
 
-		if len(types) < 1 {
-			return ast.Void, fmt.Errorf("operator with no arguments is not valid")
+		names := map[string]bool{}
+		for _, field := range record.Fields {
+			if names[field.Name] {
+				a.errorf(DuplicateName, field.Span, "duplicate field %s in record %s", field.Name, record.Name)
+			}
+			names[field.Name] = true
 		}
+	}
+}
 
-		switch v.Operator {
-		case ast.Add:
-			return analyseArithmetic(types)
-		case ast.Sub:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("sub is binary")
+func (a *analyser) analyseEnums(enums []ast.EnumDeclaration) {
+	for _, enum := range enums {
+		names := map[string]bool{}
+		for _, variant := range enum.Variants {
+			if names[variant.Name] {
+				a.errorf(DuplicateName, variant.Span, "duplicate variant %s in enum %s", variant.Name, enum.Name)
 			}
-			return analyseArithmetic(types)
-		case ast.Mul:
-			return analyseArithmetic(types)
-		case ast.Div:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("div is binary")
+			names[variant.Name] = true
+		}
+	}
+}
+
+func (a *analyser) analyseMatch(statement ast.MatchStatement, localScope *scope.Scope, currentFunction *scope.Function) {
+	_type := a.analyseExpression(statement.Expr, localScope)
+	if _type != ast.Invalid && _type.Kind() != ast.EnumKind {
+		a.errorf(TypeMismatch, statement.Expr.GetSpan(), "can only match enums, not %v", _type)
+		_type = ast.Invalid
+	}
+
+	var variants []string
+	if _type != ast.Invalid {
+		variants = _type.Variants()
+	}
+
+	exit := flow{unreachable: true}
+	matched := map[string]bool{}
+	for _, _case := range statement.Cases {
+		if _type != ast.Invalid {
+			known := false
+			for _, variant := range variants {
+				known = known || variant == _case.Variant
 			}
-			return analyseArithmetic(types)
-		case ast.Mod:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("mod is binary")
+			if !known {
+				a.errorf(UnknownName, _case.Span, "enum %v has no variant %s", _type, _case.Variant)
+			} else if matched[_case.Variant] {
+				a.errorf(DuplicateName, _case.Span, "variant %s is matched more than once", _case.Variant)
 			}
-			if types[0] == ast.Float || types[1] == ast.Float {
-				return ast.Void, fmt.Errorf("mod only supports int")
+			matched[_case.Variant] = true
+		}
+		exit = exit.join(a.analyseBranch(_case.Body, localScope, currentFunction))
+	}
+
+	exhaustive := false
+	if _type != ast.Invalid {
+		var missing []string
+		for _, variant := range variants {
+			if !matched[variant] {
+				missing = append(missing, variant)
 			}
-			return ast.Int, nil
-		case ast.Concat:
-			return ast.String, nil
-		case ast.Equal:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("equal is binary")
+		}
+		if len(missing) > 0 && !statement.HasDefault {
+			a.errorf(NonExhaustiveMatch, statement.Span, "match on %v is missing variants %s", _type, strings.Join(missing, ", "))
+		}
+		exhaustive = len(missing) == 0
+		a.exhaustive[statement.Span] = exhaustive
+		a.matched[statement.Span] = _type
+		if exhaustive && statement.HasDefault {
+			a.report(Warning, UnusedDefault, statement.Span, "default is never used, all variants of %v are matched", _type)
+		}
+	}
+
+	if statement.HasDefault || !exhaustive {
+		exit = exit.join(a.analyseBranch(statement.Default, localScope, currentFunction))
+	}
+	a.flow = exit
+}
+
+func (a *analyser) lookupField(recordType ast.Type, name string, span ast.Span) *ast.RecordField {
+	record := a.records[recordType]
+	if record != nil {
+		for i, field := range record.Fields {
+			if field.Name == name {
+				return &record.Fields[i]
 			}
-			return analyseComparison(types, v.Operator)
-		case ast.LessThan:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("less than is binary")
+		}
+	}
+	a.errorf(UnknownName, span, "record %v has no field %s", recordType, name)
+	return nil
+}
+
+func (a *analyser) analyseNew(expression ast.NewExpression, localScope *scope.Scope) ast.Type {
+	initialised := map[string]bool{}
+	for _, init := range expression.Fields {
+		_type := a.analyseExpression(init.Expr, localScope)
+		field := a.lookupField(expression.Type, init.Name, init.Span)
+		if field == nil {
+			continue
+		}
+		if initialised[init.Name] {
+			a.errorf(DuplicateName, init.Span, "field %s is initialised more than once", init.Name)
+		}
+		initialised[init.Name] = true
+		if _type != ast.Invalid && !_type.AssignableTo(field.Type) {
+			a.errorf(TypeMismatch, init.Expr.GetSpan(), "type mismatch on field %s of %v: expected %v, got %v", init.Name, expression.Type, field.Type, _type)
+		}
+	}
+	// like variables, only optionals, arrays and maps have a value before they are assigned
+	if record := a.records[expression.Type]; record != nil {
+		for _, field := range record.Fields {
+			if !initialised[field.Name] && needsAssignment(field.Type) {
+				a.errorf(UninitialisedField, expression.Span, "field %s of %v is not initialised", field.Name, expression.Type)
 			}
-			return analyseComparison(types, v.Operator)
-		case ast.GreaterThan:
-			if len(types) != 2 {
-				return ast.Void, fmt.Errorf("greater than is binary")
+		}
+	}
+	return expression.Type
+}
+
+// analyseField returns the type of the named field of a record expression, or Invalid
+func (a *analyser) analyseField(expression ast.Expression, name string, span ast.Span, localScope *scope.Scope) ast.Type {
+	_type := a.analyseExpression(expression, localScope)
+	if _type == ast.Invalid {
+		return ast.Invalid
+	}
+	if _type.Kind() != ast.RecordKind {
+		a.errorf(TypeMismatch, expression.GetSpan(), "expected a record, got %v", _type)
+		return ast.Invalid
+	}
+	field := a.lookupField(_type, name, span)
+	if field == nil {
+		return ast.Invalid
+	}
+	return field.Type
+}
+
+func (a *analyser) analyseIndex(expression ast.Expression, localScope *scope.Scope) {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && _type != ast.Int {
+		a.errorf(TypeMismatch, expression.GetSpan(), "index has to be int, not %v", _type)
+	}
+}
+
+// analyseElement checks that a value can be stored in an array or map of the given type
+func (a *analyser) analyseElement(expression ast.Expression, arrayType ast.Type, localScope *scope.Scope) {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Invalid && arrayType != ast.Invalid && !_type.AssignableTo(arrayType.Elem()) {
+		a.errorf(TypeMismatch, expression.GetSpan(), "type mismatch on element of %v: got %v", arrayType, _type)
+	}
+}
+
+func (a *analyser) analyseCall(call ast.FunctionCall, localScope *scope.Scope) ast.Type {
+	if call.Callee != nil {
+		calleeType := a.analyseExpression(call.Callee, localScope)
+		types := a.analyseExpressionList(call.Args, localScope)
+		return a.analyseValueCall(call, calleeType.String(), calleeType, types)
+	}
+
+	types := a.analyseExpressionList(call.Args, localScope)
+
+	function := localScope.GetFunction(call.Name)
+	if function == nil {
+		// variables holding functions can be called by their name as well
+		if localScope.GetVariable(call.Name) != nil {
+			calleeType := a.analyseExpression(ast.VariableExpression{Node: call.Node, Name: call.Name}, localScope)
+			return a.analyseValueCall(call, "name "+call.Name, calleeType, types)
+		}
+		a.errorf(UnknownName, call.Span, "name %s not found in local scope", call.Name)
+		return ast.Invalid
+	}
+	functionKey := variableKey{localScope.FunctionScope(call.Name), call.Name}
+	a.index.reference(functionKey.scope, call.Name, call.Span, "name")
+	a.readBy(a.functionReads[functionKey], call.Name, call.Span)
+	if function.Signature != nil {
+		for _, _type := range types {
+			if _type == ast.Invalid {
+				return ast.Invalid
 			}
-			return analyseComparison(types, v.Operator)
-		case ast.Not:
-			if len(types) != 1 {
-				return ast.Void, fmt.Errorf("not is unary")
+		}
+		result, err := function.Signature(types)
+		if err != nil {
+			a.errorf(TypeMismatch, call.Span, "mismatched arguments for name %s: %v", call.Name, err)
+			return ast.Invalid
+		}
+		return result
+	}
+	if !function.AcceptsArgumentCount(len(call.Args)) {
+		if function.Variadic {
+			a.errorf(ArgumentCount, call.Span, "mismatched number of arguments for name %s: expected at least %d, got %d", call.Name, len(function.Args)-1, len(call.Args))
+		} else {
+			a.errorf(ArgumentCount, call.Span, "mismatched number of arguments for name %s: expected %d, got %d", call.Name, len(function.Args), len(call.Args))
+		}
+		return function.Return
+	}
+	for i, actualType := range types {
+		if actualType == ast.Invalid {
+			continue
+		}
+		expectedType := function.ArgType(i)
+		if !actualType.AssignableTo(expectedType) {
+			a.errorf(TypeMismatch, call.Args[i].GetSpan(), "mismatched types for argument %d of name %s: expected %v, got %v", i+1, call.Name, expectedType, actualType)
+		}
+	}
+
+	return function.Return
+}
+
+// analyseValueCall checks a call of a function value; what describes the callee in messages
+func (a *analyser) analyseValueCall(call ast.FunctionCall, what string, calleeType ast.Type, types []ast.Type) ast.Type {
+	if calleeType == ast.Invalid {
+		return ast.Invalid
+	}
+	if !calleeType.IsFunction() {
+		a.errorf(TypeMismatch, call.Span, "can not call a value of type %v", calleeType)
+		return ast.Invalid
+	}
+
+	params := calleeType.Params()
+	if len(types) != len(params) {
+		a.errorf(ArgumentCount, call.Span, "mismatched number of arguments for %s: expected %d, got %d", what, len(params), len(types))
+		return calleeType.Elem()
+	}
+	for i, actualType := range types {
+		if actualType != ast.Invalid && !actualType.AssignableTo(params[i]) {
+			a.errorf(TypeMismatch, call.Args[i].GetSpan(), "mismatched types for argument %d of %s: expected %v, got %v", i+1, what, params[i], actualType)
+		}
+	}
+	return calleeType.Elem()
+}
+
+func (a *analyser) analyseExpression(expression ast.Expression, localScope *scope.Scope) ast.Type {
+	_type := a.expressionType(expression, localScope)
+	a.index.typed(expression.GetSpan(), _type)
+	return _type
+}
+
+func (a *analyser) expressionType(expression ast.Expression, localScope *scope.Scope) ast.Type {
+	switch v := expression.(type) {
+	case ast.LiteralExpression:
+		return v.Type
+	case ast.VariableExpression:
+		variable := localScope.GetVariable(v.Name)
+		if variable == nil {
+			// functions can be used as values by their name
+			function := localScope.GetFunction(v.Name)
+			if function != nil {
+				functionKey := variableKey{localScope.FunctionScope(v.Name), v.Name}
+				a.index.reference(functionKey.scope, v.Name, v.Span, "name")
+				// the value might be called right away
+				a.readBy(a.functionReads[functionKey], v.Name, v.Span)
+				if !function.HasType() {
+					a.errorf(TypeMismatch, v.Span, "function %s can not be used as a value, it has no function type", v.Name)
+					return ast.Invalid
+				}
+				return function.Type()
 			}
-			return analyseLogic(types)
-		case ast.And:
-			return analyseLogic(types)
-		case ast.Or:
-			return analyseLogic(types)
-		default:
-			return ast.Void, fmt.Errorf("not yet implemented")
+			a.errorf(UnknownName, v.Span, "name %s not found in local scope", v.Name)
+			return ast.Invalid
+		}
+		key := variableKey{localScope.VariableScope(v.Name), v.Name}
+		a.index.reference(key.scope, v.Name, v.Span, "name")
+		a.read(key, v.Name+" is read", v.Span)
+		return variable.Type
+	case ast.FunctionCall:
+		return a.analyseCall(v, localScope)
+	case ast.CastExpression:
+		_type := a.analyseExpression(v.Expr, localScope)
+		if _type != ast.Invalid && !ast.CanCast(_type, v.Type) {
+			a.errorf(InvalidOperands, v.Span, "can not cast %v to %v", _type, v.Type)
+		}
+		return v.Type
+	case ast.LambdaExpression:
+		function := scope.Function{
+			Name:   "<lambda>",
+			Args:   v.Args,
+			Return: v.Returns,
+		}
+		reads := a.analyseFunctionBody(ast.FunctionStatement{
+			Node:    v.Node,
+			Name:    function.Name,
+			Returns: v.Returns,
+			Args:    v.Args,
+			Body:    v.Body,
+		}, &function, localScope)
+		a.readBy(reads, "the lambda", v.Span)
+		return function.Type()
+	case ast.OperatorExpression:
+		types := a.analyseExpressionList(v.Exprs, localScope)
+		return a.analyseOperator(v, types)
+	case ast.InputExpression:
+		return ast.String
+	case ast.NoneLiteral:
+		return ast.None
+	case ast.SomeExpression:
+		_type := a.analyseExpression(v.Expr, localScope)
+		if _type == ast.Void {
+			a.errorf(TypeMismatch, v.Expr.GetSpan(), "optionals can not contain void")
+			return ast.Invalid
+		}
+		if _type == ast.Invalid {
+			return ast.Invalid
+		}
+		return ast.OptionalOf(_type)
+	case ast.IsNoneExpression:
+		a.analyseOptional(v.Expr, localScope)
+		return ast.Bool
+	case ast.UnwrapOrExpression:
+		_type := a.analyseOptional(v.Expr, localScope)
+		defaultType := a.analyseExpression(v.Default, localScope)
+		if _type == ast.Invalid {
+			return ast.Invalid
+		}
+		if _type == ast.None {
+			return defaultType
+		}
+		if defaultType != ast.Invalid && !defaultType.AssignableTo(_type.Elem()) {
+			a.errorf(TypeMismatch, v.Default.GetSpan(), "default of %v has to be %v, got %v", _type, _type.Elem(), defaultType)
+		}
+		return _type.Elem()
+	case ast.ArrayLiteral:
+		return a.analyseArrayLiteral(v, localScope)
+	case ast.IndexExpression:
+		arrayType := a.analyseArray(v.Expr, localScope)
+		a.analyseIndex(v.Index, localScope)
+		if arrayType == ast.Invalid {
+			return ast.Invalid
+		}
+		return arrayType.Elem()
+	case ast.LengthExpression:
+		_type := a.analyseExpression(v.Expr, localScope)
+		if _type != ast.Invalid && _type != ast.String && !_type.IsArray() && !_type.IsMap() {
+			a.errorf(TypeMismatch, v.Expr.GetSpan(), "can only take the length of strings, arrays and maps, not %v", _type)
 		}
+		return ast.Int
+	case ast.MapLiteral:
+		return a.analyseMapLiteral(v, localScope)
+	case ast.VariantLiteral:
+		return v.Type
+	case ast.NewExpression:
+		return a.analyseNew(v, localScope)
+	case ast.GetFieldExpression:
+		return a.analyseField(v.Expr, v.Name, v.Span, localScope)
+	case ast.GetExpression:
+		mapType := a.analyseMap(v.Map, localScope)
+		a.analyseKey(v.Key, mapType, localScope)
+		if mapType == ast.Invalid {
+			return ast.Invalid
+		}
+		return mapType.Elem()
+	case ast.HasExpression:
+		mapType := a.analyseMap(v.Map, localScope)
+		a.analyseKey(v.Key, mapType, localScope)
+		return ast.Bool
+	case ast.KeysExpression:
+		mapType := a.analyseMap(v.Map, localScope)
+		if mapType == ast.Invalid {
+			return ast.Invalid
+		}
+		return ast.ArrayOf(mapType.Key())
 	default:
-		return ast.Void, fmt.Errorf("not yet implemented")
+		a.errorf(NotImplemented, expression.GetSpan(), "expression not yet implemented")
+		return ast.Invalid
 	}
 }
 
-func analyseExpressionist(expressions []ast.Expression, localScope *scope.Scope) ([]ast.Type, error) {
+func (a *analyser) analyseExpressionList(expressions []ast.Expression, localScope *scope.Scope) []ast.Type {
 	var types []ast.Type
 	for _, expr := range expressions {
-		_type, err := analyseExpression(expr, localScope)
-		if err != nil {
-			return nil, err
+		types = append(types, a.analyseExpression(expr, localScope))
+	}
+	return types
+}
+
+func (a *analyser) analyseCondition(expression ast.Expression, localScope *scope.Scope) {
+	_type := a.analyseExpression(expression, localScope)
+	if _type != ast.Bool && _type != ast.Invalid {
+		a.errorf(TypeMismatch, expression.GetSpan(), "condition type has to be bool, not %v", _type)
+	}
+}
+
+// read checks that a tracked variable is definitely assigned where it is read; what describes the read in messages
+func (a *analyser) read(key variableKey, what string, span ast.Span) {
+	if !a.tracked[key] || a.flow.unreachable || a.flow.assigned[key] {
+		return
+	}
+	if a.captures != nil && a.captures.outer[key] {
+		// whether it is assigned depends on where the function is called
+		a.captures.add(key)
+	} else if !a.flow.declared[key] {
+		a.errorf(UseBeforeDeclare, span, "%s, but it might not be declared", what)
+	} else {
+		a.errorf(UnassignedRead, span, "%s before it is definitely assigned", what)
+	}
+	// only report the first read
+	a.flow.assign(key)
+}
+
+// readBy checks the variables from outside of a function that it reads, where it is called or used as a value
+func (a *analyser) readBy(reads []variableKey, function string, span ast.Span) {
+	for _, key := range reads {
+		if key == a.assigning {
+			continue
 		}
-		types = append(types, _type)
+		a.read(key, fmt.Sprintf("%s is read by %s", key.name, function), span)
 	}
-	return types, nil
 }
 
-func analyseStatement(statement ast.Statement, localScope *scope.Scope, currentFunction *scope.Function) error {
+// analyseFunctionBody returns the variables from outside of the function that it reads before assigning them
+func (a *analyser) analyseFunctionBody(statement ast.FunctionStatement, function *scope.Function, localScope *scope.Scope) []variableKey {
+	functionScope := scope.FromParent(localScope)
+	a.index.enter(functionScope, statement.Span)
+	for _, arg := range statement.Args {
+		if functionScope.CurrentScopeHas(arg.Name) {
+			a.errorf(DuplicateName, arg.Span, "duplicate argument %s", arg.Name)
+			continue
+		}
+		functionScope.AddVariable(scope.Variable{
+			Name: arg.Name,
+			Value: values.Value{
+				Type: arg.Type,
+			},
+		})
+		a.index.declare(functionScope, arg.Name, VariableSymbol, arg.Type, arg.Span, "name")
+	}
+
+	// the function might only be called once the variables it uses are assigned, which is checked where it is called
+	outer := a.flow
+	outerCaptures := a.captures
+	assigning := a.assigning
+	a.assigning = variableKey{}
+	a.flow = newFlow()
+	a.captures = newCaptures()
+	for key := range a.tracked {
+		a.flow.declare(key, false)
+		a.captures.outer[key] = true
+	}
+	// loops outside of the function can't be left from inside it
+	loops := a.loops
+	a.loops = nil
+	a.analyseStatements(statement.Body, functionScope, function)
+	reads := a.captures.reads
+	a.flow = outer
+	a.captures = outerCaptures
+	a.assigning = assigning
+	a.loops = loops
+
+	a.analyseControlFlow(statement.Body, &statement)
+	return reads
+}
+
+func (a *analyser) analyseStatement(statement ast.Statement, localScope *scope.Scope, currentFunction *scope.Function) {
 	switch v := statement.(type) {
 	case ast.OutputStatement:
-		_, err := analyseExpressionist(v.Exprs, localScope)
-		if err != nil {
-			return err
-		}
+		a.analyseExpressionList(v.Exprs, localScope)
 	case ast.VariableDeclarationStatement:
 		if localScope.CurrentScopeHas(v.Name) {
-			return fmt.Errorf("name %s already exists in local scope", v.Name)
+			a.errorf(DuplicateName, v.Span, "name %s already exists in local scope", v.Name)
+			return
 		}
 		localScope.AddVariable(scope.Variable{
 			Name: v.Name,
@@ -170,124 +768,367 @@ func analyseStatement(statement ast.Statement, localScope *scope.Scope, currentF
 				Type: v.Type,
 			},
 		})
+		a.index.declare(localScope, v.Name, VariableSymbol, v.Type, v.Span, "name")
+		key := variableKey{localScope, v.Name}
+		a.tracked[key] = true
+		a.flow.declare(key, !needsAssignment(v.Type))
 	case ast.VariableAssignmentStatement:
+		assigning := a.assigning
+		a.assigning = variableKey{localScope.VariableScope(v.Name), v.Name}
+		_type := a.analyseExpression(v.Expr, localScope)
+		a.assigning = assigning
 		variable := localScope.GetVariable(v.Name)
 		if variable == nil {
-			return fmt.Errorf("name %s not found in local scope", v.Name)
+			a.errorf(UnknownName, v.Span, "name %s not found in local scope", v.Name)
+			return
 		}
-		_type, err := analyseExpression(v.Expr, localScope)
-		if err != nil {
-			return err
+		key := variableKey{localScope.VariableScope(v.Name), v.Name}
+		a.index.reference(key.scope, v.Name, v.Span, "name")
+		if a.tracked[key] && !a.flow.unreachable && !a.flow.declared[key] {
+			a.errorf(UseBeforeDeclare, v.Span, "%s is assigned, but it might not be declared", v.Name)
 		}
-		if _type != variable.Type {
-			return fmt.Errorf("type mismatch on assignment")
+		a.flow.assign(key)
+		if _type != ast.Invalid && !_type.AssignableTo(variable.Type) {
+			a.errorf(TypeMismatch, v.Span, "type mismatch on assignment: %s is %v, got %v", v.Name, variable.Type, _type)
 		}
 	case ast.FunctionStatement:
-		if localScope.CurrentScopeHas(v.Name) {
-			return fmt.Errorf("name %s already exists in local scope", v.Name)
-		}
 		function := scope.Function{
 			Name:   v.Name,
 			Args:   v.Args,
 			Return: v.Returns,
 		}
-		localScope.AddFunction(function)
-
-		functionScope := scope.FromParent(localScope)
-		for _, arg := range v.Args {
-			functionScope.AddVariable(scope.Variable{
-				Name: arg.Name,
-				Value: values.Value{
-					Type: arg.Type,
-				},
-			})
+		duplicate := localScope.CurrentScopeHas(v.Name)
+		if duplicate {
+			a.errorf(DuplicateName, v.Span, "name %s already exists in local scope", v.Name)
+		} else {
+			localScope.AddFunction(function)
+			a.index.declare(localScope, v.Name, FunctionSymbol, function.Type(), v.Span, "name")
 		}
 
-		err := analyseStatements(v.Body, functionScope, &function)
-		if err != nil {
-			return err
+		reads := a.analyseFunctionBody(v, &function, localScope)
+		if !duplicate {
+			a.functionReads[variableKey{localScope, v.Name}] = reads
 		}
 	case ast.FunctionReturnStatement:
+		_type := a.analyseExpression(v.Expr, localScope)
 		if currentFunction == nil {
-			return fmt.Errorf("can not return outside of function")
-		}
-
-		_type, err := analyseExpression(v.Expr, localScope)
-		if err != nil {
-			return err
+			a.errorf(ReturnOutsideFunction, v.Span, "can not return outside of function")
+			return
 		}
-		if _type != currentFunction.Return {
-			return fmt.Errorf("return type mismatch in name %v", currentFunction.Name)
+		if _type != ast.Invalid && !_type.AssignableTo(currentFunction.Return) {
+			a.errorf(TypeMismatch, v.Span, "return type mismatch in name %v: expected %v, got %v", currentFunction.Name, currentFunction.Return, _type)
 		}
+		a.flow.unreachable = true
+	case ast.FunctionCall:
+		a.analyseExpression(v, localScope)
 	case ast.ConditionalStatement:
+		exit := flow{unreachable: true}
 		for _, _if := range v.Ifs {
-			_type, err := analyseExpression(_if.Expr, localScope)
-			if err != nil {
-				return err
-			}
-			if _type != ast.Bool {
-				return fmt.Errorf("condition type has to be bool")
-			}
+			a.analyseCondition(_if.Expr, localScope)
+			exit = exit.join(a.analyseBranch(_if.Then, localScope, currentFunction))
+		}
+		exit = exit.join(a.analyseBranch(v.Else, localScope, currentFunction))
+		a.flow = exit
+	case ast.LoopStatement:
+		a.analyseCondition(v.LoopCondition, localScope)
+		a.analyseLoopBody(v.Label, v.Span, v.Body, localScope, currentFunction)
+	case ast.ForStatement:
+		_type := a.analyseBounds(v, localScope)
 
-			err = analyseStatements(_if.Then, localScope, currentFunction)
-			if err != nil {
-				return err
+		// like at runtime, the loop variable only exists in the body
+		forScope := scope.FromParent(localScope)
+		a.index.enter(forScope, v.Span)
+		forScope.AddVariable(scope.Variable{
+			Name: v.Name,
+			Value: values.Value{
+				Type: _type,
+			},
+		})
+		a.index.declare(forScope, v.Name, VariableSymbol, _type, v.Span, "name")
+		a.analyseLoopBody(v.Label, v.Span, v.Body, forScope, currentFunction)
+	case ast.ForEachStatement:
+		references := a.index.references()
+		_type := a.analyseExpression(v.In, localScope)
+		if variable, ok := v.In.(ast.VariableExpression); ok && variable.Span == v.Span {
+			// the variable was given by the in attribute
+			a.index.renameFrom(references, "in")
+		}
+		elemType := ast.Invalid
+		valueType := ast.Invalid
+		switch {
+		case _type == ast.Invalid:
+		case _type.IsArray():
+			elemType = _type.Elem()
+			if v.ValueName != "" {
+				a.errorf(TypeMismatch, v.Span, "only maps have values to bind, %v doesn't", _type)
 			}
+		case _type.IsMap():
+			elemType = _type.Key()
+			valueType = _type.Elem()
+		default:
+			a.errorf(TypeMismatch, v.In.GetSpan(), "can only iterate over arrays and maps, not %v", _type)
 		}
-		if v.Else != nil {
-			err := analyseStatements(v.Else, localScope, currentFunction)
-			if err != nil {
-				return err
+
+		forScope := scope.FromParent(localScope)
+		a.index.enter(forScope, v.Span)
+		forScope.AddVariable(scope.Variable{
+			Name: v.Name,
+			Value: values.Value{
+				Type: elemType,
+			},
+		})
+		a.index.declare(forScope, v.Name, VariableSymbol, elemType, v.Span, "name")
+		if v.ValueName != "" {
+			if forScope.CurrentScopeHas(v.ValueName) {
+				a.errorf(DuplicateName, v.Span, "name %s already exists in local scope", v.ValueName)
+			} else {
+				forScope.AddVariable(scope.Variable{
+					Name: v.ValueName,
+					Value: values.Value{
+						Type: valueType,
+					},
+				})
+				a.index.declare(forScope, v.ValueName, VariableSymbol, valueType, v.Span, "value")
 			}
 		}
-	case ast.LoopStatement:
-		_type, err := analyseExpression(v.LoopCondition, localScope)
-		if err != nil {
-			return err
+		a.analyseLoopBody(v.Label, v.Span, v.Body, forScope, currentFunction)
+	case ast.ThrowStatement:
+		_type := a.analyseExpression(v.Expr, localScope)
+		if _type == ast.Void {
+			a.errorf(TypeMismatch, v.Expr.GetSpan(), "can not throw void")
 		}
-		if _type != ast.Bool {
-			return fmt.Errorf("condition type has to be bool")
+		a.flow.unreachable = true
+	case ast.TryStatement:
+		a.analyseTry(v, localScope, currentFunction)
+	case ast.BreakStatement:
+		a.analyseLoopControl("break", v.Label, v.Span)
+	case ast.ContinueStatement:
+		a.analyseLoopControl("continue", v.Label, v.Span)
+	case ast.AppendStatement:
+		arrayType := a.analyseArray(v.Array, localScope)
+		for _, value := range v.Values {
+			a.analyseElement(value, arrayType, localScope)
+		}
+	case ast.SetIndexStatement:
+		arrayType := a.analyseArray(v.Array, localScope)
+		a.analyseIndex(v.Index, localScope)
+		a.analyseElement(v.Value, arrayType, localScope)
+	case ast.MatchStatement:
+		a.analyseMatch(v, localScope, currentFunction)
+	case ast.SetFieldStatement:
+		fieldType := a.analyseField(v.Record, v.Name, v.Span, localScope)
+		_type := a.analyseExpression(v.Value, localScope)
+		if _type != ast.Invalid && fieldType != ast.Invalid && !_type.AssignableTo(fieldType) {
+			a.errorf(TypeMismatch, v.Value.GetSpan(), "type mismatch on field %s: expected %v, got %v", v.Name, fieldType, _type)
 		}
+	case ast.PutStatement:
+		mapType := a.analyseMap(v.Map, localScope)
+		a.analyseKey(v.Key, mapType, localScope)
+		a.analyseElement(v.Value, mapType, localScope)
+	case ast.DeleteStatement:
+		mapType := a.analyseMap(v.Map, localScope)
+		a.analyseKey(v.Key, mapType, localScope)
+	default:
+		a.errorf(NotImplemented, statement.GetSpan(), "statement not yet implemented")
+	}
+}
 
-		err = analyseStatements(v.Body, localScope, currentFunction)
-		if err != nil {
-			return err
+func (a *analyser) analyseStatements(statements []ast.Statement, scope *scope.Scope, currentFunction *scope.Function) {
+	for _, statement := range statements {
+		a.analyseStatement(statement, scope, currentFunction)
+	}
+}
+
+func (a *analyser) analyseTry(statement ast.TryStatement, localScope *scope.Scope, currentFunction *scope.Function) {
+	// a catch or the finally might run before anything in the body did
+	exit := a.analyseBranch(statement.Body, localScope, currentFunction)
+
+	caught := map[ast.Type]bool{}
+	for _, catch := range statement.Catches {
+		if caught[catch.Type] {
+			a.errorf(DuplicateName, catch.Span, "%v is already caught by an earlier catch", catch.Type)
 		}
-	case ast.ForStatement:
-		if localScope.CurrentScopeHas(v.Name) {
-			return fmt.Errorf("name %s already in local scope", v.Name)
+		caught[catch.Type] = true
+
+		catchScope := scope.FromParent(localScope)
+		a.index.enter(catchScope, catch.Span)
+		if catch.Name != "" {
+			catchScope.AddVariable(scope.Variable{
+				Name: catch.Name,
+				Value: values.Value{
+					Type: catch.Type,
+				},
+			})
+			a.index.declare(catchScope, catch.Name, VariableSymbol, catch.Type, catch.Span, "name")
 		}
-		localScope.AddVariable(scope.Variable{
-			Name: v.Name,
-			Value: values.Value{
-				Type: ast.Int,
-			},
-		})
+		exit = exit.join(a.analyseBranch(catch.Body, catchScope, currentFunction))
+	}
 
-		err := analyseStatements(v.Body, localScope, currentFunction)
-		if err != nil {
-			return err
+	if statement.HasFinally {
+		exit = exit.include(a.analyseBranch(statement.Finally, localScope, currentFunction))
+	}
+	a.flow = exit
+}
+
+// analyseBounds returns the type of the loop variable, which the bounds are promoted to
+func (a *analyser) analyseBounds(statement ast.ForStatement, localScope *scope.Scope) ast.Type {
+	bounds := []ast.Expression{statement.From, statement.To}
+	if statement.Step != nil {
+		bounds = append(bounds, statement.Step)
+	}
+
+	_type := ast.Int
+	for _, bound := range bounds {
+		boundType := a.analyseExpression(bound, localScope)
+		if boundType == ast.Invalid {
+			_type = ast.Invalid
+		} else if !boundType.IsNumber() {
+			a.errorf(TypeMismatch, bound.GetSpan(), "bounds of <for> have to be numbers, not %v", boundType)
+			_type = ast.Invalid
+		} else if _type != ast.Invalid {
+			promoted, ok := ast.Promote(_type, boundType)
+			if !ok {
+				a.errorf(TypeMismatch, bound.GetSpan(), "bounds of <for> can't mix %v and %v without a <cast>", _type, boundType)
+			}
+			_type = promoted
 		}
+	}
+
+	if literal, ok := statement.Step.(ast.LiteralExpression); ok && isZero(literal) {
+		a.errorf(InvalidOperands, literal.Span, "step of <for> can not be zero")
+	}
+
+	return _type
+}
+
+func isZero(literal ast.LiteralExpression) bool {
+	switch literal.Type {
+	case ast.Int:
+		return literal.Int == 0
+	case ast.Float:
+		return literal.Float == 0
+	case ast.Int64:
+		return literal.Int64 == 0
+	case ast.Float64:
+		return literal.Float64 == 0
+	case ast.BigInt:
+		return literal.BigInt.Sign() == 0
 	default:
-		return fmt.Errorf("not yet implemented")
+		return false
 	}
+}
 
-	return nil
+func (a *analyser) analyseLoopBody(label string, span ast.Span, statements []ast.Statement, scope *scope.Scope, currentFunction *scope.Function) {
+	if label != "" {
+		for _, outer := range a.loops {
+			if outer == label {
+				a.errorf(DuplicateName, span, "label %s is already used by an outer loop", label)
+			}
+		}
+	}
+
+	a.loops = append(a.loops, label)
+	a.analyseBranch(statements, scope, currentFunction)
+	a.loops = a.loops[:len(a.loops)-1]
 }
 
-func analyseStatements(statements []ast.Statement, scope *scope.Scope, currentFunction *scope.Function) error {
-	for _, statement := range statements {
-		err := analyseStatement(statement, scope, currentFunction)
-		if err != nil {
-			return err
+func (a *analyser) analyseLoopControl(keyword string, label string, span ast.Span) {
+	if len(a.loops) == 0 {
+		a.errorf(OutsideLoop, span, "can not %s outside of loop", keyword)
+		return
+	}
+	if label != "" {
+		found := false
+		for _, outer := range a.loops {
+			found = found || outer == label
+		}
+		if !found {
+			a.errorf(UnknownName, span, "no loop with label %s to %s", label, keyword)
+			return
 		}
 	}
+	a.flow.unreachable = true
+}
 
-	return nil
+// analyseBranch analyses statements that might not be executed and returns the flow at their end.
+// The current flow is left as it was before the branch.
+// Loop bodies are analysed once: the flow after the loop is the one before it, since the body might not run at all,
+// and running it again doesn't declare or assign anything the first iteration didn't.
+func (a *analyser) analyseBranch(statements []ast.Statement, scope *scope.Scope, currentFunction *scope.Function) flow {
+	entry := a.flow
+	a.flow = entry.copy()
+	a.analyseStatements(statements, scope, currentFunction)
+	exit := a.flow
+	a.flow = entry
+	return exit
+}
+
+func newAnalyser(program *ast.Program) *analyser {
+	a := &analyser{
+		records: map[ast.Type]*ast.RecordDeclaration{
+			ast.ErrorType: &ast.ErrorRecord,
+		},
+		flow:          newFlow(),
+		tracked:       map[variableKey]bool{},
+		exhaustive:    map[ast.Span]bool{},
+		matched:       MatchedEnums{},
+		functionReads: map[variableKey][]variableKey{},
+	}
+	a.analyseRecords(program.Records)
+	a.analyseEnums(program.Enums)
+	return a
+}
+
+// MatchedEnums maps the spans of <match> statements to the enum they match on.
+type MatchedEnums map[ast.Span]ast.Type
+
+// StaticAnalysis also returns the enums the matches of the program are on, which bytecode.Compile needs.
+func StaticAnalysis(program *ast.Program, globals *scope.Scope) (Diagnostics, MatchedEnums) {
+	a := newAnalyser(program)
+	return a.analyseProgram(program, scope.FromParent(globals)), a.matched
+}
+
+// StaticAnalysisWithIndex is like StaticAnalysis, but also returns what the analysis found out about the names and
+// expressions of the program, like where variables are declared and the types of expressions.
+func StaticAnalysisWithIndex(program *ast.Program, globals *scope.Scope) (Diagnostics, *Index) {
+	a := newAnalyser(program)
+	a.index = newIndexer(globals)
+	return a.analyseProgram(program, scope.FromParent(globals)), a.index.index
 }
 
-func StaticAnalysis(program *ast.Program) error {
-	scope := scope.New()
+// StaticAnalysisInScope declares the variables and functions of the program directly in localScope,
+// so that another program can be analysed after it, like the inputs of a REPL. It starts out with what state knows
+// about the variables of the programs before, and returns what is known after the program.
+func StaticAnalysisInScope(program *ast.Program, localScope *scope.Scope, state *State) (Diagnostics, *State) {
+	a := newAnalyser(program)
+	a.flow = state.flow.copy()
+	for key := range state.tracked {
+		a.tracked[key] = true
+	}
+	for key, reads := range state.functionReads {
+		a.functionReads[key] = reads
+	}
+	diagnostics := a.analyseProgram(program, localScope)
+	return diagnostics, &State{
+		flow:          a.flow,
+		tracked:       a.tracked,
+		functionReads: a.functionReads,
+	}
+}
+
+func (a *analyser) analyseProgram(program *ast.Program, localScope *scope.Scope) Diagnostics {
+	a.analyseStatements(program.Statements, localScope, nil)
+	a.analyseControlFlow(program.Statements, nil)
+	a.diagnostics.Sort()
 
-	return analyseStatements(program.Statements, scope, nil)
-}
\ No newline at end of file
+	return a.diagnostics
+}
+
+// ExpressionType returns the type of expression in a program with the given declarations and no statements.
+func ExpressionType(expression ast.Expression, program *ast.Program, globals *scope.Scope) (ast.Type, Diagnostics) {
+	a := newAnalyser(program)
+	_type := a.analyseExpression(expression, scope.FromParent(globals))
+	a.diagnostics.Sort()
+
+	return _type, a.diagnostics
+}
@@ -1,98 +1,441 @@
 package ast
 
+import "math/big"
+
+// The span is that of the <program> element. Comments are only kept so that the program can be formatted without losing them,
+// in the order they appear in the source.
 type Program struct {
+	Node
+	Records    []RecordDeclaration
+	Enums      []EnumDeclaration
 	Statements []Statement
+	Comments   []Comment
+	// what is between <?xml and ?> if the source starts with an XML declaration, like version="1.0"
+	Declaration string
+}
+
+// Comment is an XML comment; Text is everything between <!-- and -->.
+type Comment struct {
+	Node
+	Text string
 }
 
 type Statement interface {
+	GetSpan() Span
 }
 
 type OutputStatement struct {
+	Node
 	Exprs []Expression
 }
+
 var _ Statement = OutputStatement{}
 
 type VariableDeclarationStatement struct {
+	Node
 	Name string
 	Type Type
 }
+
 var _ Statement = VariableDeclarationStatement{}
 
 type VariableAssignmentStatement struct {
+	Node
 	Name string
 	Expr Expression
 }
+
 var _ Statement = VariableAssignmentStatement{}
 
 type FunctionStatement struct {
-	Name string
+	Node
+	Name    string
 	Returns Type
-	Args []FunctionArg
-	Body []Statement
+	Args    []FunctionArg
+	Body    []Statement
 }
+
 var _ Statement = FunctionStatement{}
 
 type FunctionArg struct {
+	Node
 	Name string
 	Type Type
 }
 
 type FunctionReturnStatement struct {
+	Node
 	Expr Expression
 }
+
 var _ Statement = FunctionReturnStatement{}
 
 type ConditionalStatement struct {
-	Ifs []ConditionIf
+	Node
+	Ifs  []ConditionIf
 	Else []Statement
 }
+
 var _ Statement = ConditionalStatement{}
 
 type ConditionIf struct {
+	Node
 	Expr Expression
 	Then []Statement
 }
 
+// Label is empty if the loop can only be targeted by an unlabelled <break/> or <continue/>.
 type LoopStatement struct {
+	Node
+	Label         string
 	LoopCondition Expression
-	Body []Statement
+	Body          []Statement
 }
+
 var _ Statement = LoopStatement{}
 
+// The bounds are evaluated once before the loop. Step is nil for a step of 1.
+// The loop runs while the variable is below To, or above it for negative steps, or equal to it if Inclusive.
 type ForStatement struct {
+	Node
+	Label     string
+	Name      string
+	From      Expression
+	To        Expression
+	Step      Expression
+	Inclusive bool
+	Body      []Statement
+}
+
+var _ Statement = ForStatement{}
+
+// An empty Label targets the innermost loop.
+type BreakStatement struct {
+	Node
+	Label string
+}
+
+var _ Statement = BreakStatement{}
+
+type ContinueStatement struct {
+	Node
+	Label string
+}
+
+var _ Statement = ContinueStatement{}
+
+type ThrowStatement struct {
+	Node
+	Expr Expression
+}
+
+var _ Statement = ThrowStatement{}
+
+// The first catch whose type the thrown value is assignable to handles it.
+// Finally runs however the body and catches are left.
+type TryStatement struct {
+	Node
+	Body       []Statement
+	Catches    []CatchClause
+	HasFinally bool
+	Finally    []Statement
+}
+
+var _ Statement = TryStatement{}
+
+// Name is empty if the caught value isn't bound to a variable.
+type CatchClause struct {
+	Node
 	Name string
-	From int
-	To int
+	Type Type
 	Body []Statement
 }
-var _ Statement = ForStatement{}
 
 type Expression interface {
+	GetSpan() Span
 }
 
 type LiteralExpression struct {
-	Type Type
-	String string
-	Bool bool
-	Int int
-	Float float32
+	Node
+	Type    Type
+	String  string
+	Bool    bool
+	Int     int
+	Float   float32
+	Int64   int64
+	Float64 float64
+	BigInt  *big.Int
 }
+
 var _ Expression = LiteralExpression{}
 
 type VariableExpression struct {
+	Node
 	Name string
 }
+
 var _ Expression = VariableExpression{}
 
 type OperatorExpression struct {
+	Node
 	Operator Operator
-	Exprs []Expression
+	Exprs    []Expression
 }
+
 var _ Expression = OperatorExpression{}
 
+// Callee is nil for calls by Name, which is a function or a variable holding one.
 type FunctionCall struct {
-	Name string
-	Args []Expression
+	Node
+	Name   string
+	Callee Expression
+	Args   []Expression
 }
+
 var _ Expression = FunctionCall{}
 var _ Statement = FunctionCall{}
+
+// LambdaExpression is an anonymous function that can see the variables of the scope it is created in.
+type LambdaExpression struct {
+	Node
+	Returns Type
+	Args    []FunctionArg
+	Body    []Statement
+}
+
+var _ Expression = LambdaExpression{}
+
+// CastExpression converts the value of Expr to Type, see CanCast.
+type CastExpression struct {
+	Node
+	Type Type
+	Expr Expression
+}
+
+var _ Expression = CastExpression{}
+
+type InputExpression struct {
+	Node
+}
+
+var _ Expression = InputExpression{}
+
+// ElemType is Void if it should be inferred from the elements.
+type ArrayLiteral struct {
+	Node
+	ElemType Type
+	Elems    []Expression
+}
+
+var _ Expression = ArrayLiteral{}
+
+type IndexExpression struct {
+	Node
+	Expr  Expression
+	Index Expression
+}
+
+var _ Expression = IndexExpression{}
+
+type LengthExpression struct {
+	Node
+	Expr Expression
+}
+
+var _ Expression = LengthExpression{}
+
+type AppendStatement struct {
+	Node
+	Array  Expression
+	Values []Expression
+}
+
+var _ Statement = AppendStatement{}
+
+type SetIndexStatement struct {
+	Node
+	Array Expression
+	Index Expression
+	Value Expression
+}
+
+var _ Statement = SetIndexStatement{}
+
+// ValueName is only used for maps, where Name is bound to the keys.
+type ForEachStatement struct {
+	Node
+	Label     string
+	Name      string
+	ValueName string
+	In        Expression
+	Body      []Statement
+}
+
+var _ Statement = ForEachStatement{}
+
+// KeyType and ValueType are Void if they should be inferred from the entries.
+type MapLiteral struct {
+	Node
+	KeyType   Type
+	ValueType Type
+	Entries   []MapEntry
+}
+
+var _ Expression = MapLiteral{}
+
+type MapEntry struct {
+	Node
+	Key   Expression
+	Value Expression
+}
+
+type GetExpression struct {
+	Node
+	Map Expression
+	Key Expression
+}
+
+var _ Expression = GetExpression{}
+
+type HasExpression struct {
+	Node
+	Map Expression
+	Key Expression
+}
+
+var _ Expression = HasExpression{}
+
+type KeysExpression struct {
+	Node
+	Map Expression
+}
+
+var _ Expression = KeysExpression{}
+
+type PutStatement struct {
+	Node
+	Map   Expression
+	Key   Expression
+	Value Expression
+}
+
+var _ Statement = PutStatement{}
+
+type DeleteStatement struct {
+	Node
+	Map Expression
+	Key Expression
+}
+
+var _ Statement = DeleteStatement{}
+
+type RecordDeclaration struct {
+	Node
+	Name   string
+	Type   Type
+	Fields []RecordField
+}
+
+type RecordField struct {
+	Node
+	Name string
+	Type Type
+}
+
+// Fields that aren't initialised start out with their zero value.
+type NewExpression struct {
+	Node
+	Type   Type
+	Fields []FieldInit
+}
+
+var _ Expression = NewExpression{}
+
+type FieldInit struct {
+	Node
+	Name string
+	Expr Expression
+}
+
+type GetFieldExpression struct {
+	Node
+	Expr Expression
+	Name string
+}
+
+var _ Expression = GetFieldExpression{}
+
+type SetFieldStatement struct {
+	Node
+	Record Expression
+	Name   string
+	Value  Expression
+}
+
+var _ Statement = SetFieldStatement{}
+
+type EnumDeclaration struct {
+	Node
+	Name     string
+	Type     Type
+	Variants []EnumVariant
+}
+
+type EnumVariant struct {
+	Node
+	Name string
+}
+
+type VariantLiteral struct {
+	Node
+	Type  Type
+	Name  string
+	Index int
+}
+
+var _ Expression = VariantLiteral{}
+
+type MatchStatement struct {
+	Node
+	Expr       Expression
+	Cases      []MatchCase
+	HasDefault bool
+	Default    []Statement
+}
+
+var _ Statement = MatchStatement{}
+
+type MatchCase struct {
+	Node
+	Variant string
+	Body    []Statement
+}
+
+type NoneLiteral struct {
+	Node
+}
+
+var _ Expression = NoneLiteral{}
+
+type SomeExpression struct {
+	Node
+	Expr Expression
+}
+
+var _ Expression = SomeExpression{}
+
+type IsNoneExpression struct {
+	Node
+	Expr Expression
+}
+
+var _ Expression = IsNoneExpression{}
+
+// Default is only evaluated if Expr is none.
+type UnwrapOrExpression struct {
+	Node
+	Expr    Expression
+	Default Expression
+}
+
+var _ Expression = UnwrapOrExpression{}
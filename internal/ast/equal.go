@@ -0,0 +1,71 @@
+package ast
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var (
+	spanType   = reflect.TypeOf(Span{})
+	bigIntType = reflect.TypeOf(&big.Int{})
+)
+
+// StructurallyEqual reports whether two nodes, or Programs, have the same structure. Spans are ignored, so a program is equal to itself
+// after being formatted. Floats are compared bit by bit, so NaN literals are equal too.
+func StructurallyEqual(a interface{}, b interface{}) bool {
+	return equal(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func equal(a reflect.Value, b reflect.Value) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+	if !a.IsValid() {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Type() == bigIntType {
+			return a.Interface().(*big.Int).Cmp(b.Interface().(*big.Int)) == 0
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type() == spanType {
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !equal(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Float32, reflect.Float64:
+		return math.Float64bits(a.Float()) == math.Float64bits(b.Float())
+	default:
+		// types are interned, so they can be compared like the other scalars
+		return a.Interface() == b.Interface()
+	}
+}
@@ -16,4 +16,26 @@ const (
 	Not
 	Or
 	And
-)
\ No newline at end of file
+)
+
+var operatorNames = [...]string{
+	Add:         "add",
+	Sub:         "sub",
+	Mul:         "mul",
+	Div:         "div",
+	Mod:         "mod",
+	Concat:      "concat",
+	Equal:       "equal",
+	LessThan:    "lt",
+	GreaterThan: "gt",
+	Not:         "not",
+	Or:          "or",
+	And:         "and",
+}
+
+func (o Operator) String() string {
+	if o >= 0 && int(o) < len(operatorNames) {
+		return operatorNames[o]
+	}
+	return "unknown"
+}
@@ -0,0 +1,225 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Tree is a node of the syntax tree in a form that can be printed, or encoded as JSON or XML.
+type Tree struct {
+	// the name of the Go type, like OutputStatement
+	Name string
+	Span Span
+	// all fields but the embedded Node, in declaration order
+	Fields []TreeField
+}
+
+// TreeField values are strings, bools or numbers, a *Tree, a []interface{} of them, or nil for a missing node.
+type TreeField struct {
+	Name  string
+	Value interface{}
+}
+
+// symbol is a string from a fmt.Stringer like a Type, which the text form doesn't quote
+type symbol string
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// NewTree converts a node, or a Program, into a Tree.
+func NewTree(node interface{}) *Tree {
+	tree, _ := treeValue(reflect.ValueOf(node)).(*Tree)
+	return tree
+}
+
+func treeValue(value reflect.Value) interface{} {
+	for value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Kind() == reflect.Ptr && value.IsNil() {
+		return nil
+	}
+	if value.Type().Implements(stringerType) {
+		return symbol(value.Interface().(fmt.Stringer).String())
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		return treeValue(value.Elem())
+	case reflect.Slice:
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			list[i] = treeValue(value.Index(i))
+		}
+		return list
+	case reflect.Struct:
+		tree := &Tree{
+			Name: value.Type().Name(),
+		}
+		if node, ok := value.Interface().(Spanned); ok {
+			tree.Span = node.GetSpan()
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Anonymous {
+				continue
+			}
+			tree.Fields = append(tree.Fields, TreeField{
+				Name:  field.Name,
+				Value: treeValue(value.Field(i)),
+			})
+		}
+		return tree
+	default:
+		return value.Interface()
+	}
+}
+
+// Fprint writes node as an indented tree, one field per line.
+func Fprint(w io.Writer, node interface{}) error {
+	p := printer{w: w}
+	p.print("", NewTree(node), 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) line(depth int, format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, strings.Repeat("  ", depth)+format+"\n", args...)
+}
+
+func (p *printer) print(label string, value interface{}, depth int) {
+	prefix := ""
+	if label != "" {
+		prefix = label + " "
+	}
+
+	switch v := value.(type) {
+	case *Tree:
+		if v.Span.Start.Line > 0 {
+			p.line(depth, "%s%s %d:%d", prefix, v.Name, v.Span.Start.Line, v.Span.Start.Column)
+		} else {
+			p.line(depth, "%s%s", prefix, v.Name)
+		}
+		for _, field := range v.Fields {
+			p.print(field.Name, field.Value, depth+1)
+		}
+	case []interface{}:
+		p.line(depth, "%s", label)
+		for _, element := range v {
+			p.print("", element, depth+1)
+		}
+	case string:
+		p.line(depth, "%s%q", prefix, v)
+	case symbol:
+		p.line(depth, "%s%s", prefix, v)
+	case nil:
+		p.line(depth, "%snil", prefix)
+	default:
+		p.line(depth, "%s%v", prefix, v)
+	}
+}
+
+// MarshalJSON encodes the tree as an object with the name under "node", the span under "span" and then the fields.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	buffer := bytes.Buffer{}
+	buffer.WriteString(`{"node":`)
+	name, _ := json.Marshal(t.Name)
+	buffer.Write(name)
+	if t.Span.Start.Line > 0 {
+		span, err := json.Marshal(t.Span)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString(`,"span":`)
+		buffer.Write(span)
+	}
+	for _, field := range t.Fields {
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(field.Name)
+		buffer.WriteByte(',')
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// MarshalXML encodes the tree as an element named like the node. Scalar fields become attributes,
+// nodes and lists become child elements named like the field, which contain them. Missing nodes are left out.
+func (t *Tree) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{
+		Name: xml.Name{Local: t.Name},
+	}
+	if t.Span.Start.Line > 0 {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "span"},
+			Value: fmt.Sprintf("%d:%d-%d:%d", t.Span.Start.Line, t.Span.Start.Column, t.Span.End.Line, t.Span.End.Column),
+		})
+	}
+	var children []TreeField
+	for _, field := range t.Fields {
+		switch field.Value.(type) {
+		case nil:
+		case *Tree, []interface{}:
+			children = append(children, field)
+		default:
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: field.Name},
+				Value: fmt.Sprint(field.Value),
+			})
+		}
+	}
+
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err = encodeField(e, child)
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeField(e *xml.Encoder, field TreeField) error {
+	start := xml.StartElement{
+		Name: xml.Name{Local: field.Name},
+	}
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	elements, ok := field.Value.([]interface{})
+	if !ok {
+		elements = []interface{}{field.Value}
+	}
+	for _, element := range elements {
+		if tree, ok := element.(*Tree); ok {
+			err = e.Encode(tree)
+		} else {
+			err = e.EncodeElement(element, xml.StartElement{Name: xml.Name{Local: "value"}})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
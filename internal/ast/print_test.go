@@ -0,0 +1,49 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/parser"
+)
+
+func TestTreeKeepsZeroValues(t *testing.T) {
+	src := `<program><output><add><int>0</int><int>1</int></add><bool>false</bool><string></string></output></program>`
+	program, err := parser.Parse("", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := strings.Builder{}
+	err = ast.Fprint(&text, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonOutput, err := json.Marshal(ast.NewTree(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlOutput, err := xml.Marshal(ast.NewTree(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"text", text.String(), []string{"Operator add", "Int 0", "Bool false", `String ""`}},
+		{"json", string(jsonOutput), []string{`"Operator":"add"`, `"Int":0`, `"Bool":false`, `"String":""`}},
+		{"xml", string(xmlOutput), []string{`Operator="add"`, `Int="0"`, `Bool="false"`, `String=""`}},
+	}
+	for _, output := range outputs {
+		for _, want := range output.want {
+			if !strings.Contains(output.output, want) {
+				t.Errorf("%s: expected %q in\n%s", output.name, want, output.output)
+			}
+		}
+	}
+}
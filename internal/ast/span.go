@@ -0,0 +1,65 @@
+package ast
+
+import "fmt"
+
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Before reports whether p comes before other in the same file.
+func (p Position) Before(other Position) bool {
+	return p.Line < other.Line || p.Line == other.Line && p.Column < other.Column
+}
+
+type Span struct {
+	File  string   `json:"file,omitempty"`
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+func (s Span) String() string {
+	file := s.File
+	if file == "" {
+		file = "<input>"
+	}
+	if s.Start.Line == 0 {
+		return file
+	}
+	if s.Start.Column == 0 {
+		return fmt.Sprintf("%s:%d", file, s.Start.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", file, s.Start.Line, s.Start.Column)
+}
+
+type Spanned interface {
+	GetSpan() Span
+}
+
+type Node struct {
+	Span Span
+}
+
+func (n Node) GetSpan() Span {
+	return n.Span
+}
+
+type Error struct {
+	Span Span
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Span, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func Errorf(span Span, format string, args ...interface{}) error {
+	return &Error{
+		Span: span,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
@@ -1,5 +1,11 @@
 package ast
 
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
 type Type int
 
 const (
@@ -8,8 +14,310 @@ const (
 	Bool
 	Int
 	Float
+	Int64
+	Float64
+	BigInt
+
+	Invalid
+
+	firstCompound
+)
+
+type Kind int
+
+const (
+	PrimitiveKind Kind = iota
+	ArrayKind
+	MapKind
+	RecordKind
+	EnumKind
+	OptionalKind
+	FunctionKind
+)
+
+type compound struct {
+	kind Kind
+	key  Type
+	elem Type
+	name string
+	// joined with commas, since slices can't be map keys
+	variants string
+	// the parameter types of functions, joined with commas as well
+	params string
+}
+
+// compound types are interned, so that types can still be compared with ==
+var (
+	compoundsLock sync.RWMutex
+	compounds     []compound
+	compoundTypes = map[compound]Type{}
+	// split once, so looking up variants doesn't allocate
+	enumVariants   = map[Type][]string{}
+	functionParams = map[Type][]Type{}
 )
 
+func intern(c compound) Type {
+	compoundsLock.RLock()
+	t, ok := compoundTypes[c]
+	compoundsLock.RUnlock()
+	if ok {
+		return t
+	}
+
+	compoundsLock.Lock()
+	defer compoundsLock.Unlock()
+	t, ok = compoundTypes[c]
+	if !ok {
+		t = firstCompound + Type(len(compounds))
+		compounds = append(compounds, c)
+		compoundTypes[c] = t
+	}
+	return t
+}
+
+func (t Type) compound() compound {
+	if t < firstCompound {
+		return compound{
+			kind: PrimitiveKind,
+		}
+	}
+	compoundsLock.RLock()
+	defer compoundsLock.RUnlock()
+	return compounds[t-firstCompound]
+}
+
+func ArrayOf(elem Type) Type {
+	return intern(compound{
+		kind: ArrayKind,
+		elem: elem,
+	})
+}
+
+// Either type may be Void for map literals that infer it from their entries.
+func MapOf(key Type, value Type) Type {
+	return intern(compound{
+		kind: MapKind,
+		key:  key,
+		elem: value,
+	})
+}
+
+// Records are identified by their name; their fields are part of the program's declarations.
+func RecordOf(name string) Type {
+	return intern(compound{
+		kind: RecordKind,
+		name: name,
+	})
+}
+
+// Enums are identified by their name and variants, and their values are the index of the variant.
+func EnumOf(name string, variants []string) Type {
+	t := intern(compound{
+		kind:     EnumKind,
+		name:     name,
+		variants: strings.Join(variants, ","),
+	})
+
+	compoundsLock.Lock()
+	defer compoundsLock.Unlock()
+	if _, ok := enumVariants[t]; !ok {
+		enumVariants[t] = append([]string(nil), variants...)
+	}
+	return t
+}
+
+func OptionalOf(elem Type) Type {
+	return intern(compound{
+		kind: OptionalKind,
+		elem: elem,
+	})
+}
+
+// Functions are identified by their parameter and return types; the return type is Void if there is none.
+func FunctionOf(params []Type, returns Type) Type {
+	ids := make([]string, len(params))
+	for i, param := range params {
+		ids[i] = strconv.Itoa(int(param))
+	}
+	t := intern(compound{
+		kind:   FunctionKind,
+		elem:   returns,
+		params: strings.Join(ids, ","),
+	})
+
+	compoundsLock.Lock()
+	defer compoundsLock.Unlock()
+	if _, ok := functionParams[t]; !ok {
+		functionParams[t] = append([]Type{}, params...)
+	}
+	return t
+}
+
+// None is the type of <none/>, which can be assigned to any optional.
+var None = OptionalOf(Void)
+
+// ErrorType is the type runtime errors are caught as: a record with the message and the stack trace.
+var ErrorType = RecordOf("error")
+
+var ErrorRecord = RecordDeclaration{
+	Name: "error",
+	Type: ErrorType,
+	Fields: []RecordField{
+		{Name: "message", Type: String},
+		{Name: "trace", Type: String},
+	},
+}
+
+func (t Type) AssignableTo(target Type) bool {
+	return t == target || (t == None && target.IsOptional())
+}
+
+// CanCast reports whether <cast> converts values of type from to type to; values.Cast documents how.
+func CanCast(from Type, to Type) bool {
+	scalar := func(t Type) bool {
+		return t == String || t == Bool || t.IsNumber() || t.Kind() == EnumKind
+	}
+	switch {
+	case from == to:
+		return true
+	case to == String || from == String:
+		return scalar(from) && scalar(to)
+	case from.IsNumber() && to.IsNumber():
+		return true
+	case from == Int:
+		return to == Float || to == Bool || to.Kind() == EnumKind
+	case to == Int:
+		return from == Float || from == Bool || from.Kind() == EnumKind
+	default:
+		return false
+	}
+}
+
+func (t Type) Kind() Kind {
+	return t.compound().kind
+}
+
+func (t Type) IsArray() bool {
+	return t.Kind() == ArrayKind
+}
+
+func (t Type) IsMap() bool {
+	return t.Kind() == MapKind
+}
+
+func (t Type) IsOptional() bool {
+	return t.Kind() == OptionalKind
+}
+
+func (t Type) IsFunction() bool {
+	return t.Kind() == FunctionKind
+}
+
+func (t Type) IsPrimitive() bool {
+	return t.Kind() == PrimitiveKind
+}
+
+// IsComparable reports whether values of the type can be used as map keys.
+// Bigints can't, since they are compared by value but stored as pointers. NaN floats are rejected at runtime.
+func (t Type) IsComparable() bool {
+	return t == String || t == Bool || (t.IsNumber() && t != BigInt) || t.Kind() == EnumKind
+}
+
+// Elem returns the element type of arrays and optionals, the value type of maps and the return type of functions.
+func (t Type) Elem() Type {
+	return t.compound().elem
+}
+
+// Key returns the key type of maps.
+func (t Type) Key() Type {
+	return t.compound().key
+}
+
+// Variants returns the variant names of enums. The slice must not be modified.
+func (t Type) Variants() []string {
+	compoundsLock.RLock()
+	defer compoundsLock.RUnlock()
+	return enumVariants[t]
+}
+
+// Params returns the parameter types of functions. The slice must not be modified.
+func (t Type) Params() []Type {
+	compoundsLock.RLock()
+	defer compoundsLock.RUnlock()
+	return functionParams[t]
+}
+
 func (t Type) IsNumber() bool {
-	return t == Int || t == Float
-}
\ No newline at end of file
+	return t.IsInteger() || t == Float || t == Float64
+}
+
+func (t Type) IsInteger() bool {
+	return t == Int || t == Int64 || t == BigInt
+}
+
+// Promote returns the type the operands of arithmetic and comparisons are converted to.
+// Ints are promoted to any other number, floats to float64 and int64s to bigint; everything else needs a <cast>.
+func Promote(a Type, b Type) (Type, bool) {
+	switch {
+	case a == b:
+		return a, true
+	case a == Int && b.IsNumber():
+		return b, true
+	case b == Int && a.IsNumber():
+		return a, true
+	case a == Float && b == Float64 || a == Float64 && b == Float:
+		return Float64, true
+	case a == Int64 && b == BigInt || a == BigInt && b == Int64:
+		return BigInt, true
+	default:
+		return Invalid, false
+	}
+}
+
+func (t Type) String() string {
+	switch t {
+	case Void:
+		return "void"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case Int64:
+		return "int64"
+	case Float64:
+		return "float64"
+	case BigInt:
+		return "bigint"
+	}
+
+	c := t.compound()
+	switch c.kind {
+	case ArrayKind:
+		return "array<" + c.elem.String() + ">"
+	case MapKind:
+		return "map<" + c.key.String() + ", " + c.elem.String() + ">"
+	case RecordKind, EnumKind:
+		return c.name
+	case OptionalKind:
+		if t == None {
+			return "none"
+		}
+		return "optional<" + c.elem.String() + ">"
+	case FunctionKind:
+		var params []string
+		for _, param := range t.Params() {
+			params = append(params, param.String())
+		}
+		result := "func(" + strings.Join(params, ", ") + ")"
+		if c.elem != Void {
+			result += ": " + c.elem.String()
+		}
+		return result
+	default:
+		return "<invalid>"
+	}
+}
@@ -0,0 +1,111 @@
+package ast
+
+// Inspect calls f for node and, as long as f returns true, for the statements and expressions inside it, depth first.
+// Clauses like the ifs of a conditional or the cases of a match are not passed to f, only what they contain.
+func Inspect(node Spanned, f func(Spanned) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch v := node.(type) {
+	case OutputStatement:
+		inspectExpressions(v.Exprs, f)
+	case VariableAssignmentStatement:
+		Inspect(v.Expr, f)
+	case FunctionStatement:
+		InspectStatements(v.Body, f)
+	case FunctionReturnStatement:
+		Inspect(v.Expr, f)
+	case ConditionalStatement:
+		for _, _if := range v.Ifs {
+			Inspect(_if.Expr, f)
+			InspectStatements(_if.Then, f)
+		}
+		InspectStatements(v.Else, f)
+	case LoopStatement:
+		Inspect(v.LoopCondition, f)
+		InspectStatements(v.Body, f)
+	case ForStatement:
+		Inspect(v.From, f)
+		Inspect(v.To, f)
+		Inspect(v.Step, f)
+		InspectStatements(v.Body, f)
+	case ForEachStatement:
+		Inspect(v.In, f)
+		InspectStatements(v.Body, f)
+	case ThrowStatement:
+		Inspect(v.Expr, f)
+	case TryStatement:
+		InspectStatements(v.Body, f)
+		for _, catch := range v.Catches {
+			InspectStatements(catch.Body, f)
+		}
+		InspectStatements(v.Finally, f)
+	case AppendStatement:
+		Inspect(v.Array, f)
+		inspectExpressions(v.Values, f)
+	case SetIndexStatement:
+		inspectExpressions([]Expression{v.Array, v.Index, v.Value}, f)
+	case PutStatement:
+		inspectExpressions([]Expression{v.Map, v.Key, v.Value}, f)
+	case DeleteStatement:
+		inspectExpressions([]Expression{v.Map, v.Key}, f)
+	case SetFieldStatement:
+		inspectExpressions([]Expression{v.Record, v.Value}, f)
+	case MatchStatement:
+		Inspect(v.Expr, f)
+		for _, _case := range v.Cases {
+			InspectStatements(_case.Body, f)
+		}
+		InspectStatements(v.Default, f)
+	case OperatorExpression:
+		inspectExpressions(v.Exprs, f)
+	case FunctionCall:
+		Inspect(v.Callee, f)
+		inspectExpressions(v.Args, f)
+	case LambdaExpression:
+		InspectStatements(v.Body, f)
+	case CastExpression:
+		Inspect(v.Expr, f)
+	case ArrayLiteral:
+		inspectExpressions(v.Elems, f)
+	case IndexExpression:
+		inspectExpressions([]Expression{v.Expr, v.Index}, f)
+	case LengthExpression:
+		Inspect(v.Expr, f)
+	case MapLiteral:
+		for _, entry := range v.Entries {
+			inspectExpressions([]Expression{entry.Key, entry.Value}, f)
+		}
+	case GetExpression:
+		inspectExpressions([]Expression{v.Map, v.Key}, f)
+	case HasExpression:
+		inspectExpressions([]Expression{v.Map, v.Key}, f)
+	case KeysExpression:
+		Inspect(v.Map, f)
+	case NewExpression:
+		for _, field := range v.Fields {
+			Inspect(field.Expr, f)
+		}
+	case GetFieldExpression:
+		Inspect(v.Expr, f)
+	case SomeExpression:
+		Inspect(v.Expr, f)
+	case IsNoneExpression:
+		Inspect(v.Expr, f)
+	case UnwrapOrExpression:
+		inspectExpressions([]Expression{v.Expr, v.Default}, f)
+	}
+}
+
+func InspectStatements(statements []Statement, f func(Spanned) bool) {
+	for _, statement := range statements {
+		Inspect(statement, f)
+	}
+}
+
+func inspectExpressions(expressions []Expression, f func(Spanned) bool) {
+	for _, expression := range expressions {
+		Inspect(expression, f)
+	}
+}
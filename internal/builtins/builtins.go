@@ -0,0 +1,263 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/values"
+)
+
+// Scope returns a new scope with the standard library; the globals of an interpreter are created below it.
+func Scope() *scope.Scope {
+	s := scope.New()
+	for _, function := range functions {
+		function.Builtin = true
+		s.AddFunction(function)
+	}
+	return s
+}
+
+var functions = []scope.Function{
+	{Name: "sqrt", Args: args(ast.Float), Return: ast.Float, Signature: floats(1, ast.Void), Native: sqrt},
+	{Name: "pow", Args: args(ast.Float, ast.Float), Return: ast.Float, Signature: floats(2, ast.Void), Native: pow},
+	{Name: "floor", Args: args(ast.Float), Return: ast.Int, Signature: floats(1, ast.Int), Native: floor},
+	{Name: "round", Args: args(ast.Float), Return: ast.Int, Signature: floats(1, ast.Int), Native: round},
+	{Name: "abs", Signature: numbers(1, 1), Native: abs},
+	{Name: "min", Signature: numbers(1, -1), Native: extreme(-1)},
+	{Name: "max", Signature: numbers(1, -1), Native: extreme(1)},
+
+	{Name: "len", Args: args(ast.String), Return: ast.Int, Native: length},
+	{Name: "substr", Args: args(ast.String, ast.Int, ast.Int), Return: ast.String, Native: substr},
+	{Name: "index", Args: args(ast.String, ast.String), Return: ast.Int, Native: index},
+	{Name: "upper", Args: args(ast.String), Return: ast.String, Native: stringFunction(strings.ToUpper)},
+	{Name: "lower", Args: args(ast.String), Return: ast.String, Native: stringFunction(strings.ToLower)},
+	{Name: "trim", Args: args(ast.String), Return: ast.String, Native: stringFunction(strings.TrimSpace)},
+	{Name: "split", Args: args(ast.String, ast.String), Return: ast.ArrayOf(ast.String), Native: split},
+	{Name: "join", Args: args(ast.ArrayOf(ast.String), ast.String), Return: ast.String, Native: join},
+	{Name: "replace", Args: args(ast.String, ast.String, ast.String), Return: ast.String, Native: replace},
+
+	{Name: "toInt", Args: args(ast.Float), Return: ast.Int, Native: cast(ast.Int)},
+	{Name: "toFloat", Args: args(ast.Int), Return: ast.Float, Native: cast(ast.Float)},
+	{Name: "toString", Signature: printable, Native: cast(ast.String)},
+	{Name: "parseInt", Args: args(ast.String), Return: ast.Int, Native: cast(ast.Int)},
+}
+
+func args(types ...ast.Type) []ast.FunctionArg {
+	var args []ast.FunctionArg
+	for index, _type := range types {
+		args = append(args, ast.FunctionArg{
+			Name: fmt.Sprintf("arg%d", index+1),
+			Type: _type,
+		})
+	}
+	return args
+}
+
+// numbers accepts at least min (and at most max, unless it is negative) numbers, and returns the type they are
+// promoted to like the operands of arithmetic
+func numbers(min int, max int) func(args []ast.Type) (ast.Type, error) {
+	return func(args []ast.Type) (ast.Type, error) {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			if min == max {
+				return ast.Invalid, fmt.Errorf("expected %d arguments, got %d", min, len(args))
+			}
+			return ast.Invalid, fmt.Errorf("expected at least %d arguments, got %d", min, len(args))
+		}
+		result := args[0]
+		for _, arg := range args {
+			if !arg.IsNumber() {
+				return ast.Invalid, fmt.Errorf("expected a number, got %v", arg)
+			}
+			promoted, ok := ast.Promote(result, arg)
+			if !ok {
+				return ast.Invalid, fmt.Errorf("can't mix %v and %v without a <cast>", result, arg)
+			}
+			result = promoted
+		}
+		return result, nil
+	}
+}
+
+// floats accepts count numbers that are promoted to float or float64, and returns that type, or result unless it is Void
+func floats(count int, result ast.Type) func(args []ast.Type) (ast.Type, error) {
+	promote := numbers(count, count)
+	return func(args []ast.Type) (ast.Type, error) {
+		promoted, err := promote(args)
+		if err != nil {
+			return ast.Invalid, err
+		}
+		if promoted != ast.Float && promoted != ast.Float64 {
+			return ast.Invalid, fmt.Errorf("expected float or float64, got %v", promoted)
+		}
+		if result != ast.Void {
+			return result, nil
+		}
+		return promoted, nil
+	}
+}
+
+func printable(args []ast.Type) (ast.Type, error) {
+	if len(args) != 1 {
+		return ast.Invalid, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	if !ast.CanCast(args[0], ast.String) {
+		return ast.Invalid, fmt.Errorf("expected string, bool, a number or an enum, got %v", args[0])
+	}
+	return ast.String, nil
+}
+
+func float(value float64) values.Value {
+	return values.Value{Type: ast.Float, Float: float32(value)}
+}
+
+func integer(value int) values.Value {
+	return values.Value{Type: ast.Int, Int: value}
+}
+
+func str(value string) values.Value {
+	return values.Value{Type: ast.String, String: value}
+}
+
+// floatFunction applies function to the arguments promoted to float or float64, and returns a result of that type
+func floatFunction(args []values.Value, function func(args ...float64) float64) values.Value {
+	args = values.Promote(args)
+	floats := make([]float64, len(args))
+	for i, arg := range args {
+		floats[i] = arg.Float64
+		if arg.Type == ast.Float {
+			floats[i] = float64(arg.Float)
+		}
+	}
+	result := function(floats...)
+	if args[0].Type == ast.Float64 {
+		return values.Value{Type: ast.Float64, Float64: result}
+	}
+	return float(result)
+}
+
+func sqrt(args []values.Value) (values.Value, error) {
+	return floatFunction(args, func(x ...float64) float64 {
+		return math.Sqrt(x[0])
+	}), nil
+}
+
+func pow(args []values.Value) (values.Value, error) {
+	return floatFunction(args, func(x ...float64) float64 {
+		return math.Pow(x[0], x[1])
+	}), nil
+}
+
+func floor(args []values.Value) (values.Value, error) {
+	return values.Cast(floatFunction(args, func(x ...float64) float64 {
+		return math.Floor(x[0])
+	}), ast.Int)
+}
+
+// round rounds halves away from zero
+func round(args []values.Value) (values.Value, error) {
+	return values.Cast(floatFunction(args, func(x ...float64) float64 {
+		return math.Round(x[0])
+	}), ast.Int)
+}
+
+func abs(args []values.Value) (values.Value, error) {
+	switch value := args[0]; value.Type {
+	case ast.Float:
+		return float(math.Abs(float64(value.Float))), nil
+	case ast.Float64:
+		return values.Value{Type: ast.Float64, Float64: math.Abs(value.Float64)}, nil
+	case ast.BigInt:
+		return values.Value{Type: ast.BigInt, BigInt: new(big.Int).Abs(value.BigInt)}, nil
+	case ast.Int64:
+		if value.Int64 == math.MinInt64 {
+			return values.Value{}, errors.New("the absolute value of the smallest int64 is out of range")
+		}
+		if value.Int64 < 0 {
+			value.Int64 = -value.Int64
+		}
+		return value, nil
+	default:
+		if value.Int == math.MinInt {
+			return values.Value{}, errors.New("the absolute value of the smallest int is out of range")
+		}
+		if value.Int < 0 {
+			return integer(-value.Int), nil
+		}
+		return value, nil
+	}
+}
+
+// extreme returns min for -1 and max for 1
+func extreme(sign int) scope.NativeFunction {
+	return func(args []values.Value) (values.Value, error) {
+		args = values.Promote(args)
+		result := args[0]
+		for _, arg := range args[1:] {
+			if sign < 0 && values.Less(arg, result) || sign > 0 && values.Less(result, arg) {
+				result = arg
+			}
+		}
+		return result, nil
+	}
+}
+
+func length(args []values.Value) (values.Value, error) {
+	return integer(utf8.RuneCountInString(args[0].String)), nil
+}
+
+// substr takes the start and length in characters, not bytes
+func substr(args []values.Value) (values.Value, error) {
+	runes := []rune(args[0].String)
+	start, count := args[1].Int, args[2].Int
+	if start < 0 || count < 0 || start > len(runes)-count {
+		return values.Value{}, fmt.Errorf("substring from %d with length %d is out of range for length %d", start, count, len(runes))
+	}
+	return str(string(runes[start : start+count])), nil
+}
+
+// index returns the position in characters of the first occurrence, or -1
+func index(args []values.Value) (values.Value, error) {
+	i := strings.Index(args[0].String, args[1].String)
+	if i < 0 {
+		return integer(-1), nil
+	}
+	return integer(utf8.RuneCountInString(args[0].String[:i])), nil
+}
+
+func stringFunction(function func(string) string) scope.NativeFunction {
+	return func(args []values.Value) (values.Value, error) {
+		return str(function(args[0].String)), nil
+	}
+}
+
+func split(args []values.Value) (values.Value, error) {
+	var parts []values.Value
+	for _, part := range strings.Split(args[0].String, args[1].String) {
+		parts = append(parts, str(part))
+	}
+	return values.NewArray(ast.String, parts), nil
+}
+
+func join(args []values.Value) (values.Value, error) {
+	var parts []string
+	for _, element := range args[0].Array.Elements {
+		parts = append(parts, element.String)
+	}
+	return str(strings.Join(parts, args[1].String)), nil
+}
+
+func replace(args []values.Value) (values.Value, error) {
+	return str(strings.ReplaceAll(args[0].String, args[1].String, args[2].String)), nil
+}
+
+// cast converts the same way as <cast>
+func cast(to ast.Type) scope.NativeFunction {
+	return func(args []values.Value) (values.Value, error) {
+		return values.Cast(args[0], to)
+	}
+}
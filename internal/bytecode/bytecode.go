@@ -0,0 +1,264 @@
+package bytecode
+
+import (
+	"fmt"
+	"strings"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/values"
+)
+
+type Op uint8
+
+const (
+	// A: constant index
+	OpConst Op = iota
+	// A: slot, B: ast.Type
+	OpDeclare
+	// A: slot
+	OpLoad
+	OpStore
+	// A: slot, B: number of frames to go up
+	OpLoadOuter
+	OpStoreOuter
+	// A: ast.Operator, B: number of operands
+	OpOperator
+	// A: target
+	OpJump
+	OpJumpIfFalse
+	// A: function index, B: number of frames to go up to the defining frame
+	OpCall
+	// A: native index, B: number of arguments
+	OpCallNative
+	OpReturn
+	OpReturnVoid
+	OpPop
+	// A: number of values
+	OpOutput
+	OpInput
+	// marks the start of a statement or loop iteration
+	OpTick
+	// A: ast.Type of the elements (or void to infer it), B: number of elements
+	OpArray
+	OpIndex
+	OpLength
+	// A: number of values
+	OpAppend
+	OpSetIndex
+	// A: ast.Type of the map (with void key or value types to infer them), B: number of entries
+	OpMap
+	OpGet
+	OpHas
+	OpKeys
+	OpPut
+	OpDelete
+	// A: 1 if the values of maps are needed too
+	OpIterate
+	// A: ast.Type
+	OpZero
+	// A: record index, takes all fields in declaration order
+	OpNew
+	// A: constant index of the field name
+	OpGetField
+	OpSetField
+	// A: jump table index
+	OpMatch
+	OpSome
+	OpIsNone
+	// A: target to jump to with the unwrapped value if it isn't none, otherwise it is popped
+	OpUnwrapOr
+	// A: target to jump to once the loop is done, B: the slot of from, followed by to, step and the iteration counter
+	// pushes the loop variable and counts the iteration; OpRangeInclusive also runs the loop for the variable equal to to
+	OpRange
+	OpRangeInclusive
+	// A: handler to jump to when an exception can be caught, B: slot the exception is stored in
+	// the handler is removed before jumping to it
+	OpTry
+	OpEndTry
+	OpThrow
+	// A: slot a handler stored the exception in, which is thrown again as it was
+	OpRethrow
+	// A: ast.Type a <catch> handles
+	OpCatches
+	// A: function index, B: number of frames to go up to the defining frame
+	OpClosure
+	// A: native index
+	OpNative
+	// A: number of arguments, takes the function value below them
+	OpCallValue
+	// A: scope index, creates the cells of the scope's variables
+	OpEnterScope
+	// like OpDeclare, OpLoadOuter and OpStoreOuter, for variables stored in cells
+	OpDeclareCell
+	OpLoadCell
+	OpStoreCell
+	// A: ast.Type to convert to
+	OpCast
+)
+
+var opNames = [...]string{
+	OpConst:          "const",
+	OpDeclare:        "declare",
+	OpLoad:           "load",
+	OpStore:          "store",
+	OpLoadOuter:      "load-outer",
+	OpStoreOuter:     "store-outer",
+	OpOperator:       "operator",
+	OpJump:           "jump",
+	OpJumpIfFalse:    "jump-if-false",
+	OpCall:           "call",
+	OpCallNative:     "call-native",
+	OpReturn:         "return",
+	OpReturnVoid:     "return-void",
+	OpPop:            "pop",
+	OpOutput:         "output",
+	OpInput:          "input",
+	OpTick:           "tick",
+	OpArray:          "array",
+	OpIndex:          "index",
+	OpLength:         "length",
+	OpAppend:         "append",
+	OpSetIndex:       "set-index",
+	OpMap:            "map",
+	OpGet:            "get",
+	OpHas:            "has",
+	OpKeys:           "keys",
+	OpPut:            "put",
+	OpDelete:         "delete",
+	OpIterate:        "iterate",
+	OpZero:           "zero",
+	OpNew:            "new",
+	OpGetField:       "get-field",
+	OpSetField:       "set-field",
+	OpMatch:          "match",
+	OpSome:           "some",
+	OpIsNone:         "is-none",
+	OpUnwrapOr:       "unwrap-or",
+	OpRange:          "range",
+	OpRangeInclusive: "range-inclusive",
+	OpTry:            "try",
+	OpEndTry:         "end-try",
+	OpThrow:          "throw",
+	OpRethrow:        "rethrow",
+	OpCatches:        "catches",
+	OpClosure:        "closure",
+	OpNative:         "native",
+	OpCallValue:      "call-value",
+	OpEnterScope:     "enter-scope",
+	OpDeclareCell:    "declare-cell",
+	OpLoadCell:       "load-cell",
+	OpStoreCell:      "store-cell",
+	OpCast:           "cast",
+}
+
+func (o Op) String() string {
+	if int(o) < len(opNames) && opNames[o] != "" {
+		return opNames[o]
+	}
+	return fmt.Sprintf("op(%d)", o)
+}
+
+type Instruction struct {
+	Op Op
+	A  int
+	B  int
+}
+
+// JumpTable has the pc of the case of each variant of Enum, by their index; the default for those without one.
+type JumpTable struct {
+	Enum    ast.Type
+	Targets []int
+	Default int
+}
+
+type Function struct {
+	Name      string
+	Type      ast.Type
+	NumArgs   int
+	NumLocals int
+
+	Code []Instruction
+	// the node each instruction was compiled from, for runtime errors
+	Nodes  []ast.Spanned
+	Tables []JumpTable
+	// the slots of the variables stored in cells, by the scope that creates them
+	Scopes [][]int
+}
+
+type Program struct {
+	Main      *Function
+	Functions []*Function
+	Constants []values.Value
+	Natives   []*scope.Function
+	Records   []*ast.RecordDeclaration
+}
+
+func formatConstant(value values.Value) string {
+	switch value.Type {
+	case ast.String:
+		return fmt.Sprintf("%q", value.String)
+	case ast.Bool:
+		return fmt.Sprint(value.Bool)
+	case ast.Int:
+		return fmt.Sprint(value.Int)
+	case ast.Float:
+		return fmt.Sprint(value.Float)
+	case ast.Int64:
+		return fmt.Sprint(value.Int64)
+	case ast.Float64:
+		return fmt.Sprint(value.Float64)
+	case ast.BigInt:
+		return value.BigInt.String()
+	default:
+		if value.Type == ast.None {
+			return "none"
+		}
+		if value.Type.Kind() == ast.EnumKind {
+			return value.Type.String() + "." + value.Type.Variants()[value.Int]
+		}
+		return value.Type.String()
+	}
+}
+
+func (p *Program) disassembleFunction(builder *strings.Builder, function *Function) {
+	fmt.Fprintf(builder, "%s (args %d, locals %d):\n", function.Name, function.NumArgs, function.NumLocals)
+	for pc, instruction := range function.Code {
+		fmt.Fprintf(builder, "  %4d  %-14v %4d %4d", pc, instruction.Op, instruction.A, instruction.B)
+		switch instruction.Op {
+		case OpConst, OpGetField, OpSetField:
+			fmt.Fprintf(builder, "  ; %v", formatConstant(p.Constants[instruction.A]))
+		case OpDeclare, OpDeclareCell:
+			fmt.Fprintf(builder, "  ; %v", ast.Type(instruction.B))
+		case OpArray, OpMap, OpZero, OpCast:
+			fmt.Fprintf(builder, "  ; %v", ast.Type(instruction.A))
+		case OpOperator:
+			fmt.Fprintf(builder, "  ; %v", ast.Operator(instruction.A))
+		case OpMatch:
+			table := function.Tables[instruction.A]
+			fmt.Fprint(builder, "  ;")
+			for i, variant := range table.Enum.Variants() {
+				fmt.Fprintf(builder, " %s->%d", variant, table.Targets[i])
+			}
+			fmt.Fprintf(builder, " default->%d", table.Default)
+		case OpNew:
+			fmt.Fprintf(builder, "  ; %s", p.Records[instruction.A].Name)
+		case OpCall, OpClosure:
+			fmt.Fprintf(builder, "  ; %s", p.Functions[instruction.A].Name)
+		case OpEnterScope:
+			fmt.Fprintf(builder, "  ; %v", function.Scopes[instruction.A])
+		case OpCallNative, OpNative:
+			fmt.Fprintf(builder, "  ; %s", p.Natives[instruction.A].Name)
+		}
+		builder.WriteString("\n")
+	}
+}
+
+func (p *Program) Disassemble() string {
+	builder := strings.Builder{}
+	p.disassembleFunction(&builder, p.Main)
+	for _, function := range p.Functions {
+		builder.WriteString("\n")
+		p.disassembleFunction(&builder, function)
+	}
+	return builder.String()
+}
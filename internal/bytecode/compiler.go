@@ -0,0 +1,1092 @@
+package bytecode
+
+import (
+	"xml-programming/internal/ast"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/values"
+)
+
+type variableBinding struct {
+	level int
+	slot  int
+	cell  bool
+}
+
+type functionBinding struct {
+	level int
+	index int
+}
+
+type block struct {
+	variables map[string]variableBinding
+	functions map[string]functionBinding
+	level     int
+	// the index of the scope whose cells the variables are stored in, or -1.
+	// The tree walker creates the variables of loop bodies and catches anew each time they run,
+	// which closures created in them can tell apart, so they can't share a slot.
+	scope int
+
+	parent *block
+}
+
+type loopState struct {
+	label string
+	// number of try statements around the loop, which a break or continue doesn't leave
+	tries int
+	// jumps to patch once the targets are known
+	breaks    []int
+	continues []int
+}
+
+type functionState struct {
+	function *Function
+	level    int
+	// innermost last
+	loops []*loopState
+	// try statements whose handler is installed, innermost last
+	tries []ast.TryStatement
+
+	parent *functionState
+}
+
+type constantKey struct {
+	Type ast.Type
+	// the digits of bigints
+	String  string
+	Bool    bool
+	Int     int
+	Float   float32
+	Int64   int64
+	Float64 float64
+}
+
+type compiler struct {
+	program *Program
+	globals *scope.Scope
+
+	constants map[constantKey]int
+	natives   map[string]int
+	records   map[ast.Type]int
+	// the enum of each match, from the analysis
+	matched map[ast.Span]ast.Type
+
+	current *functionState
+	block   *block
+}
+
+// Compile compiles an analysed program; matched are the enums its matches are on, see analysis.StaticAnalysis.
+func Compile(program *ast.Program, globals *scope.Scope, matched map[ast.Span]ast.Type) (*Program, error) {
+	c := &compiler{
+		program: &Program{
+			Main: &Function{
+				Name: "<program>",
+			},
+		},
+		globals:   globals,
+		constants: map[constantKey]int{},
+		natives:   map[string]int{},
+		records:   map[ast.Type]int{},
+		matched:   matched,
+	}
+	c.records[ast.ErrorType] = 0
+	c.program.Records = append(c.program.Records, &ast.ErrorRecord)
+	for i := range program.Records {
+		c.records[program.Records[i].Type] = len(c.program.Records)
+		c.program.Records = append(c.program.Records, &program.Records[i])
+	}
+	c.current = &functionState{
+		function: c.program.Main,
+	}
+	c.pushBlock()
+
+	err := c.compileStatements(program.Statements)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.program, nil
+}
+
+func (c *compiler) pushBlock() {
+	scope := -1
+	if c.block != nil && c.block.level == c.current.level {
+		scope = c.block.scope
+	}
+	c.block = &block{
+		variables: map[string]variableBinding{},
+		functions: map[string]functionBinding{},
+		level:     c.current.level,
+		scope:     scope,
+		parent:    c.block,
+	}
+}
+
+// pushScope pushes a block whose variables are created anew each time it runs, like the body of a for loop
+func (c *compiler) pushScope(node ast.Spanned, body []ast.Statement) {
+	c.pushBlock()
+	if !createsClosures(body) {
+		return
+	}
+	function := c.current.function
+	c.block.scope = len(function.Scopes)
+	function.Scopes = append(function.Scopes, nil)
+	c.emit(node, OpEnterScope, c.block.scope, 0)
+}
+
+func createsClosures(statements []ast.Statement) bool {
+	found := false
+	ast.InspectStatements(statements, func(node ast.Spanned) bool {
+		switch node.(type) {
+		case ast.LambdaExpression, ast.FunctionStatement:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *compiler) popBlock() {
+	c.block = c.block.parent
+}
+
+func (c *compiler) pushLoop(label string) *loopState {
+	loop := &loopState{
+		label: label,
+		tries: len(c.current.tries),
+	}
+	c.current.loops = append(c.current.loops, loop)
+	return loop
+}
+
+func (c *compiler) popLoop(loop *loopState, continueTarget int, breakTarget int) {
+	for _, jump := range loop.continues {
+		c.patch(jump, continueTarget)
+	}
+	for _, jump := range loop.breaks {
+		c.patch(jump, breakTarget)
+	}
+	c.current.loops = c.current.loops[:len(c.current.loops)-1]
+}
+
+func (c *compiler) loop(node ast.Spanned, label string) (*loopState, error) {
+	loops := c.current.loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label == "" || loops[i].label == label {
+			return loops[i], nil
+		}
+	}
+	return nil, ast.Errorf(node.GetSpan(), "no loop with label %q", label)
+}
+
+func (c *compiler) emit(node ast.Spanned, op Op, a, b int) int {
+	function := c.current.function
+	function.Code = append(function.Code, Instruction{
+		Op: op,
+		A:  a,
+		B:  b,
+	})
+	function.Nodes = append(function.Nodes, node)
+	return len(function.Code) - 1
+}
+
+func (c *compiler) here() int {
+	return len(c.current.function.Code)
+}
+
+func (c *compiler) patch(pc int, target int) {
+	c.current.function.Code[pc].A = target
+}
+
+func (c *compiler) constant(value values.Value) int {
+	key := constantKey{
+		Type:    value.Type,
+		String:  value.String,
+		Bool:    value.Bool,
+		Int:     value.Int,
+		Float:   value.Float,
+		Int64:   value.Int64,
+		Float64: value.Float64,
+	}
+	if value.Type == ast.BigInt {
+		key.String = value.BigInt.String()
+	}
+	index, ok := c.constants[key]
+	if !ok {
+		index = len(c.program.Constants)
+		c.program.Constants = append(c.program.Constants, value)
+		c.constants[key] = index
+	}
+	return index
+}
+
+func (c *compiler) newSlot() int {
+	slot := c.current.function.NumLocals
+	c.current.function.NumLocals++
+	return slot
+}
+
+func (c *compiler) declareVariable(name string) variableBinding {
+	binding := variableBinding{
+		level: c.current.level,
+		slot:  c.newSlot(),
+	}
+	if c.block.scope >= 0 {
+		binding.cell = true
+		scopes := c.current.function.Scopes
+		scopes[c.block.scope] = append(scopes[c.block.scope], binding.slot)
+	}
+	c.block.variables[name] = binding
+	return binding
+}
+
+// registerNative returns the index of a host function in the program's natives, if there is one by that name.
+func (c *compiler) registerNative(name string) (int, bool) {
+	index, ok := c.natives[name]
+	if ok {
+		return index, true
+	}
+	native := c.globals.GetFunction(name)
+	if native == nil || native.Native == nil {
+		return 0, false
+	}
+	index = len(c.program.Natives)
+	c.program.Natives = append(c.program.Natives, native)
+	c.natives[name] = index
+	return index, true
+}
+
+func (c *compiler) lookupVariable(name string) (variableBinding, bool) {
+	for b := c.block; b != nil; b = b.parent {
+		binding, ok := b.variables[name]
+		if ok {
+			return binding, true
+		}
+	}
+	return variableBinding{}, false
+}
+
+func (c *compiler) lookupFunction(name string) (functionBinding, bool) {
+	for b := c.block; b != nil; b = b.parent {
+		binding, ok := b.functions[name]
+		if ok {
+			return binding, true
+		}
+	}
+	return functionBinding{}, false
+}
+
+func (c *compiler) emitLoad(node ast.Spanned, name string) error {
+	binding, ok := c.lookupVariable(name)
+	if !ok {
+		// functions can be used as values by their name
+		function, ok := c.lookupFunction(name)
+		if ok {
+			c.emit(node, OpClosure, function.index, c.current.level-function.level)
+			return nil
+		}
+		index, ok := c.registerNative(name)
+		if ok {
+			c.emit(node, OpNative, index, 0)
+			return nil
+		}
+		return ast.Errorf(node.GetSpan(), "name %s not found in local scope", name)
+	}
+	if binding.cell {
+		c.emit(node, OpLoadCell, binding.slot, c.current.level-binding.level)
+	} else if binding.level == c.current.level {
+		c.emit(node, OpLoad, binding.slot, 0)
+	} else {
+		c.emit(node, OpLoadOuter, binding.slot, c.current.level-binding.level)
+	}
+	return nil
+}
+
+func (c *compiler) emitStore(node ast.Spanned, name string) error {
+	binding, ok := c.lookupVariable(name)
+	if !ok {
+		return ast.Errorf(node.GetSpan(), "name %s not found in local scope", name)
+	}
+	c.store(node, binding)
+	return nil
+}
+
+func (c *compiler) store(node ast.Spanned, binding variableBinding) {
+	if binding.cell {
+		c.emit(node, OpStoreCell, binding.slot, c.current.level-binding.level)
+	} else if binding.level == c.current.level {
+		c.emit(node, OpStore, binding.slot, 0)
+	} else {
+		c.emit(node, OpStoreOuter, binding.slot, c.current.level-binding.level)
+	}
+}
+
+func (c *compiler) compileStatements(statements []ast.Statement) error {
+	for _, statement := range statements {
+		err := c.compileStatement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) compileFunction(statement ast.FunctionStatement) error {
+	function := &Function{
+		Name:    statement.Name,
+		Type:    functionType(statement.Args, statement.Returns),
+		NumArgs: len(statement.Args),
+	}
+	index := len(c.program.Functions)
+	c.program.Functions = append(c.program.Functions, function)
+
+	// bound before the body is compiled, so the function can call itself
+	c.block.functions[statement.Name] = functionBinding{
+		level: c.current.level,
+		index: index,
+	}
+
+	return c.compileBody(statement, function, statement.Args, statement.Body)
+}
+
+func (c *compiler) compileLambda(expression ast.LambdaExpression) error {
+	function := &Function{
+		Name:    "<lambda>",
+		Type:    functionType(expression.Args, expression.Returns),
+		NumArgs: len(expression.Args),
+	}
+	index := len(c.program.Functions)
+	c.program.Functions = append(c.program.Functions, function)
+
+	err := c.compileBody(expression, function, expression.Args, expression.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(expression, OpClosure, index, 0)
+	return nil
+}
+
+func functionType(args []ast.FunctionArg, returns ast.Type) ast.Type {
+	params := make([]ast.Type, len(args))
+	for i, arg := range args {
+		params[i] = arg.Type
+	}
+	return ast.FunctionOf(params, returns)
+}
+
+func (c *compiler) compileBody(node ast.Spanned, function *Function, args []ast.FunctionArg, body []ast.Statement) error {
+	c.current = &functionState{
+		function: function,
+		level:    c.current.level + 1,
+		parent:   c.current,
+	}
+	c.pushBlock()
+
+	for _, arg := range args {
+		c.declareVariable(arg.Name)
+	}
+	err := c.compileStatements(body)
+	c.emit(node, OpReturnVoid, 0, 0)
+
+	c.popBlock()
+	c.current = c.current.parent
+
+	return err
+}
+
+func (c *compiler) compileConditional(statement ast.ConditionalStatement) error {
+	var endJumps []int
+
+	for _, _if := range statement.Ifs {
+		err := c.compileExpression(_if.Expr)
+		if err != nil {
+			return err
+		}
+		next := c.emit(_if, OpJumpIfFalse, 0, 0)
+
+		err = c.compileStatements(_if.Then)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(_if, OpJump, 0, 0))
+		c.patch(next, c.here())
+	}
+
+	err := c.compileStatements(statement.Else)
+	if err != nil {
+		return err
+	}
+
+	for _, jump := range endJumps {
+		c.patch(jump, c.here())
+	}
+	return nil
+}
+
+func (c *compiler) compileMatch(statement ast.MatchStatement) error {
+	err := c.compileExpression(statement.Expr)
+	if err != nil {
+		return err
+	}
+
+	enum, ok := c.matched[statement.Span]
+	if !ok {
+		return ast.Errorf(statement.Span, "unknown enum of match")
+	}
+	variants := enum.Variants()
+
+	function := c.current.function
+	index := len(function.Tables)
+	function.Tables = append(function.Tables, JumpTable{
+		Enum:    enum,
+		Targets: make([]int, len(variants)),
+	})
+	c.emit(statement, OpMatch, index, 0)
+
+	var endJumps []int
+	for _, _case := range statement.Cases {
+		for i, variant := range variants {
+			if variant == _case.Variant {
+				function.Tables[index].Targets[i] = c.here()
+			}
+		}
+		err := c.compileStatements(_case.Body)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(_case, OpJump, 0, 0))
+	}
+
+	function.Tables[index].Default = c.here()
+	for i, target := range function.Tables[index].Targets {
+		// a case can't start at the match itself
+		if target == 0 {
+			function.Tables[index].Targets[i] = c.here()
+		}
+	}
+	err = c.compileStatements(statement.Default)
+	if err != nil {
+		return err
+	}
+
+	for _, jump := range endJumps {
+		c.patch(jump, c.here())
+	}
+	return nil
+}
+
+func (c *compiler) compileLoop(statement ast.LoopStatement) error {
+	start := c.here()
+	c.emit(statement, OpTick, 0, 0)
+
+	err := c.compileExpression(statement.LoopCondition)
+	if err != nil {
+		return err
+	}
+	exit := c.emit(statement, OpJumpIfFalse, 0, 0)
+
+	loop := c.pushLoop(statement.Label)
+	err = c.compileStatements(statement.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(statement, OpJump, start, 0)
+	c.patch(exit, c.here())
+	c.popLoop(loop, start, c.here())
+
+	return nil
+}
+
+func (c *compiler) compileFor(statement ast.ForStatement) error {
+	// the bounds are evaluated once, and the loop variable is computed from a hidden counter,
+	// so assigning to it doesn't affect the iteration
+	from := c.newSlot()
+	to := c.newSlot()
+	step := c.newSlot()
+	counter := c.newSlot()
+	for _, bound := range []struct {
+		expr ast.Expression
+		slot int
+	}{{statement.From, from}, {statement.To, to}, {statement.Step, step}} {
+		if bound.expr == nil {
+			c.emit(statement, OpConst, c.constant(values.Value{Type: ast.Int, Int: 1}), 0)
+		} else {
+			err := c.compileExpression(bound.expr)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(statement, OpStore, bound.slot, 0)
+	}
+
+	c.emit(statement, OpConst, c.constant(values.Value{Type: ast.Int, Int: 0}), 0)
+	c.emit(statement, OpStore, counter, 0)
+
+	op := OpRange
+	if statement.Inclusive {
+		op = OpRangeInclusive
+	}
+
+	start := c.here()
+	exit := c.emit(statement, op, 0, from)
+	c.emit(statement, OpTick, 0, 0)
+
+	c.pushScope(statement, statement.Body)
+	c.store(statement, c.declareVariable(statement.Name))
+	loop := c.pushLoop(statement.Label)
+	err := c.compileStatements(statement.Body)
+	c.popBlock()
+	if err != nil {
+		return err
+	}
+
+	// OpRange counts the iterations itself
+	c.emit(statement, OpJump, start, 0)
+	c.patch(exit, c.here())
+	c.popLoop(loop, start, c.here())
+
+	return nil
+}
+
+// leaveTries removes the handlers of the try statements a jump leaves, and runs their finally blocks.
+func (c *compiler) leaveTries(node ast.Spanned, depth int) error {
+	tries := c.current.tries
+	defer func() {
+		c.current.tries = tries
+	}()
+
+	for i := len(tries) - 1; i >= depth; i-- {
+		c.emit(node, OpEndTry, 0, 0)
+		// a jump out of the finally only has to leave the try statements around it
+		c.current.tries = tries[:i]
+		err := c.compileFinally(tries[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileFinally inlines the finally block; it is compiled once for every way the try can be left
+func (c *compiler) compileFinally(statement ast.TryStatement) error {
+	if !statement.HasFinally {
+		return nil
+	}
+	c.pushBlock()
+	defer c.popBlock()
+	return c.compileStatements(statement.Finally)
+}
+
+func (c *compiler) pushTry(statement ast.TryStatement) {
+	c.current.tries = append(c.current.tries, statement)
+}
+
+func (c *compiler) popTry() {
+	c.current.tries = c.current.tries[:len(c.current.tries)-1]
+}
+
+func (c *compiler) compileTry(statement ast.TryStatement) error {
+	var endJumps []int
+
+	exception := c.newSlot()
+	handler := c.emit(statement, OpTry, 0, exception)
+	c.pushTry(statement)
+	err := c.compileStatements(statement.Body)
+	c.popTry()
+	if err != nil {
+		return err
+	}
+	c.emit(statement, OpEndTry, 0, 0)
+	err = c.compileFinally(statement)
+	if err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emit(statement, OpJump, 0, 0))
+
+	c.patch(handler, c.here())
+
+	// exceptions from the catches still have to run the finally
+	catchException := -1
+	catchHandler := -1
+	if statement.HasFinally {
+		catchException = c.newSlot()
+		catchHandler = c.emit(statement, OpTry, 0, catchException)
+	}
+
+	for _, catch := range statement.Catches {
+		c.emit(catch, OpLoad, exception, 0)
+		c.emit(catch, OpCatches, int(catch.Type), 0)
+		next := c.emit(catch, OpJumpIfFalse, 0, 0)
+
+		c.pushScope(catch, catch.Body)
+		if catch.Name != "" {
+			variable := c.declareVariable(catch.Name)
+			c.emit(catch, OpLoad, exception, 0)
+			c.store(catch, variable)
+		}
+		if statement.HasFinally {
+			c.pushTry(statement)
+		}
+		err := c.compileStatements(catch.Body)
+		if statement.HasFinally {
+			c.popTry()
+		}
+		c.popBlock()
+		if err != nil {
+			return err
+		}
+
+		if statement.HasFinally {
+			c.emit(catch, OpEndTry, 0, 0)
+		}
+		err = c.compileFinally(statement)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(catch, OpJump, 0, 0))
+		c.patch(next, c.here())
+	}
+
+	// no catch handles the exception
+	if statement.HasFinally {
+		c.emit(statement, OpEndTry, 0, 0)
+	}
+	err = c.compileFinally(statement)
+	if err != nil {
+		return err
+	}
+	c.emit(statement, OpRethrow, exception, 0)
+
+	if statement.HasFinally {
+		c.patch(catchHandler, c.here())
+		err = c.compileFinally(statement)
+		if err != nil {
+			return err
+		}
+		c.emit(statement, OpRethrow, catchException, 0)
+	}
+
+	for _, jump := range endJumps {
+		c.patch(jump, c.here())
+	}
+	return nil
+}
+
+func (c *compiler) emitIncrement(node ast.Spanned, slot int) {
+	c.emit(node, OpLoad, slot, 0)
+	c.emit(node, OpConst, c.constant(values.Value{Type: ast.Int, Int: 1}), 0)
+	c.emit(node, OpOperator, int(ast.Add), 2)
+	c.emit(node, OpStore, slot, 0)
+}
+
+func (c *compiler) compileForEach(statement ast.ForEachStatement) error {
+	err := c.compileExpression(statement.In)
+	if err != nil {
+		return err
+	}
+	withValues := statement.ValueName != ""
+	if withValues {
+		c.emit(statement, OpIterate, 1, 0)
+	} else {
+		c.emit(statement, OpIterate, 0, 0)
+	}
+	mapValues := -1
+	if withValues {
+		mapValues = c.newSlot()
+		c.emit(statement, OpStore, mapValues, 0)
+	}
+	array := c.newSlot()
+	c.emit(statement, OpStore, array, 0)
+
+	// elements appended by the body are not iterated over
+	count := c.newSlot()
+	c.emit(statement, OpLoad, array, 0)
+	c.emit(statement, OpLength, 0, 0)
+	c.emit(statement, OpStore, count, 0)
+
+	counter := c.newSlot()
+	c.emit(statement, OpConst, c.constant(values.Value{Type: ast.Int, Int: 0}), 0)
+	c.emit(statement, OpStore, counter, 0)
+
+	start := c.here()
+	c.emit(statement, OpLoad, counter, 0)
+	c.emit(statement, OpLoad, count, 0)
+	c.emit(statement, OpOperator, int(ast.LessThan), 2)
+	exit := c.emit(statement, OpJumpIfFalse, 0, 0)
+	c.emit(statement, OpTick, 0, 0)
+
+	c.pushScope(statement, statement.Body)
+	variable := c.declareVariable(statement.Name)
+	c.emit(statement, OpLoad, array, 0)
+	c.emit(statement, OpLoad, counter, 0)
+	c.emit(statement, OpIndex, 0, 0)
+	c.store(statement, variable)
+	if withValues {
+		value := c.declareVariable(statement.ValueName)
+		c.emit(statement, OpLoad, mapValues, 0)
+		c.emit(statement, OpLoad, counter, 0)
+		c.emit(statement, OpIndex, 0, 0)
+		c.store(statement, value)
+	}
+	loop := c.pushLoop(statement.Label)
+	err = c.compileStatements(statement.Body)
+	c.popBlock()
+	if err != nil {
+		return err
+	}
+
+	next := c.here()
+	c.emitIncrement(statement, counter)
+	c.emit(statement, OpJump, start, 0)
+	c.patch(exit, c.here())
+	c.popLoop(loop, next, c.here())
+
+	return nil
+}
+
+func (c *compiler) compileStatement(statement ast.Statement) error {
+	c.emit(statement, OpTick, 1, 0)
+
+	switch v := statement.(type) {
+	case ast.OutputStatement:
+		err := c.compileExpressions(v.Exprs)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpOutput, len(v.Exprs), 0)
+	case ast.VariableDeclarationStatement:
+		variable := c.declareVariable(v.Name)
+		if variable.cell {
+			c.emit(v, OpDeclareCell, variable.slot, int(v.Type))
+		} else {
+			c.emit(v, OpDeclare, variable.slot, int(v.Type))
+		}
+	case ast.VariableAssignmentStatement:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		return c.emitStore(v, v.Name)
+	case ast.FunctionStatement:
+		return c.compileFunction(v)
+	case ast.FunctionReturnStatement:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		if len(c.current.tries) > 0 {
+			// the finally blocks could return themselves, which would leave the value on the stack
+			result := c.newSlot()
+			c.emit(v, OpStore, result, 0)
+			err = c.leaveTries(v, 0)
+			if err != nil {
+				return err
+			}
+			c.emit(v, OpLoad, result, 0)
+		}
+		c.emit(v, OpReturn, 0, 0)
+	case ast.FunctionCall:
+		err := c.compileCall(v)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpPop, 0, 0)
+	case ast.ConditionalStatement:
+		return c.compileConditional(v)
+	case ast.LoopStatement:
+		return c.compileLoop(v)
+	case ast.ForStatement:
+		return c.compileFor(v)
+	case ast.ForEachStatement:
+		return c.compileForEach(v)
+	case ast.ThrowStatement:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpThrow, 0, 0)
+	case ast.TryStatement:
+		return c.compileTry(v)
+	case ast.BreakStatement:
+		loop, err := c.loop(v, v.Label)
+		if err != nil {
+			return err
+		}
+		err = c.leaveTries(v, loop.tries)
+		if err != nil {
+			return err
+		}
+		loop.breaks = append(loop.breaks, c.emit(v, OpJump, 0, 0))
+	case ast.ContinueStatement:
+		loop, err := c.loop(v, v.Label)
+		if err != nil {
+			return err
+		}
+		err = c.leaveTries(v, loop.tries)
+		if err != nil {
+			return err
+		}
+		loop.continues = append(loop.continues, c.emit(v, OpJump, 0, 0))
+	case ast.AppendStatement:
+		err := c.compileExpression(v.Array)
+		if err != nil {
+			return err
+		}
+		err = c.compileExpressions(v.Values)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpAppend, len(v.Values), 0)
+	case ast.SetIndexStatement:
+		err := c.compileExpressions([]ast.Expression{v.Array, v.Index, v.Value})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpSetIndex, 0, 0)
+	case ast.MatchStatement:
+		return c.compileMatch(v)
+	case ast.SetFieldStatement:
+		err := c.compileExpressions([]ast.Expression{v.Record, v.Value})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpSetField, c.constant(values.Value{Type: ast.String, String: v.Name}), 0)
+	case ast.PutStatement:
+		err := c.compileExpressions([]ast.Expression{v.Map, v.Key, v.Value})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpPut, 0, 0)
+	case ast.DeleteStatement:
+		err := c.compileExpressions([]ast.Expression{v.Map, v.Key})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpDelete, 0, 0)
+	default:
+		return ast.Errorf(statement.GetSpan(), "statement not yet implemented")
+	}
+
+	return nil
+}
+
+func (c *compiler) compileExpressions(expressions []ast.Expression) error {
+	for _, expression := range expressions {
+		err := c.compileExpression(expression)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isVariableCall reports whether a call by name calls a variable, because there is no function by that name
+func (c *compiler) isVariableCall(name string) bool {
+	_, ok := c.lookupFunction(name)
+	if ok || c.globals.GetFunction(name) != nil {
+		return false
+	}
+	_, ok = c.lookupVariable(name)
+	return ok
+}
+
+func (c *compiler) compileCall(call ast.FunctionCall) error {
+	callee := call.Callee
+	if callee == nil && c.isVariableCall(call.Name) {
+		// variables holding functions are called like any other function value
+		callee = ast.VariableExpression{Node: call.Node, Name: call.Name}
+	}
+	if callee != nil {
+		err := c.compileExpression(callee)
+		if err != nil {
+			return err
+		}
+		err = c.compileExpressions(call.Args)
+		if err != nil {
+			return err
+		}
+		c.emit(call, OpCallValue, len(call.Args), 0)
+		return nil
+	}
+
+	err := c.compileExpressions(call.Args)
+	if err != nil {
+		return err
+	}
+
+	binding, ok := c.lookupFunction(call.Name)
+	if ok {
+		c.emit(call, OpCall, binding.index, c.current.level-binding.level)
+		return nil
+	}
+
+	index, ok := c.registerNative(call.Name)
+	if !ok {
+		return ast.Errorf(call.Span, "name %s not found in local scope", call.Name)
+	}
+	c.emit(call, OpCallNative, index, len(call.Args))
+	return nil
+}
+
+func (c *compiler) compileNew(expression ast.NewExpression) error {
+	index := c.records[expression.Type]
+	record := c.program.Records[index]
+
+	for _, field := range record.Fields {
+		initialised := false
+		for _, init := range expression.Fields {
+			if init.Name == field.Name {
+				err := c.compileExpression(init.Expr)
+				if err != nil {
+					return err
+				}
+				initialised = true
+			}
+		}
+		if !initialised {
+			c.emit(expression, OpZero, int(field.Type), 0)
+		}
+	}
+
+	c.emit(expression, OpNew, index, 0)
+	return nil
+}
+
+func (c *compiler) compileLogic(expression ast.OperatorExpression) error {
+	// and jumps out at the first false operand, or at the first true one
+	shortCircuit := expression.Operator == ast.Or
+
+	var exits []int
+	for _, expr := range expression.Exprs {
+		err := c.compileExpression(expr)
+		if err != nil {
+			return err
+		}
+		if shortCircuit {
+			c.emit(expression, OpOperator, int(ast.Not), 1)
+		}
+		exits = append(exits, c.emit(expression, OpJumpIfFalse, 0, 0))
+	}
+
+	c.emit(expression, OpConst, c.constant(values.Value{Type: ast.Bool, Bool: !shortCircuit}), 0)
+	end := c.emit(expression, OpJump, 0, 0)
+	for _, exit := range exits {
+		c.patch(exit, c.here())
+	}
+	c.emit(expression, OpConst, c.constant(values.Value{Type: ast.Bool, Bool: shortCircuit}), 0)
+	c.patch(end, c.here())
+
+	return nil
+}
+
+func (c *compiler) compileExpression(expression ast.Expression) error {
+	switch v := expression.(type) {
+	case ast.LiteralExpression:
+		c.emit(v, OpConst, c.constant(values.FromLiteralExpression(v)), 0)
+	case ast.VariableExpression:
+		return c.emitLoad(v, v.Name)
+	case ast.OperatorExpression:
+		if v.Operator == ast.And || v.Operator == ast.Or {
+			return c.compileLogic(v)
+		}
+		err := c.compileExpressions(v.Exprs)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpOperator, int(v.Operator), len(v.Exprs))
+	case ast.FunctionCall:
+		return c.compileCall(v)
+	case ast.LambdaExpression:
+		return c.compileLambda(v)
+	case ast.CastExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpCast, int(v.Type), 0)
+	case ast.InputExpression:
+		c.emit(v, OpInput, 0, 0)
+	case ast.ArrayLiteral:
+		err := c.compileExpressions(v.Elems)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpArray, int(v.ElemType), len(v.Elems))
+	case ast.IndexExpression:
+		err := c.compileExpressions([]ast.Expression{v.Expr, v.Index})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpIndex, 0, 0)
+	case ast.MapLiteral:
+		for _, entry := range v.Entries {
+			err := c.compileExpressions([]ast.Expression{entry.Key, entry.Value})
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(v, OpMap, int(ast.MapOf(v.KeyType, v.ValueType)), len(v.Entries))
+	case ast.NoneLiteral:
+		c.emit(v, OpConst, c.constant(values.Value{Type: ast.None}), 0)
+	case ast.SomeExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpSome, 0, 0)
+	case ast.IsNoneExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpIsNone, 0, 0)
+	case ast.UnwrapOrExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		end := c.emit(v, OpUnwrapOr, 0, 0)
+		err = c.compileExpression(v.Default)
+		if err != nil {
+			return err
+		}
+		c.patch(end, c.here())
+	case ast.VariantLiteral:
+		c.emit(v, OpConst, c.constant(values.Value{Type: v.Type, Int: v.Index}), 0)
+	case ast.NewExpression:
+		return c.compileNew(v)
+	case ast.GetFieldExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpGetField, c.constant(values.Value{Type: ast.String, String: v.Name}), 0)
+	case ast.GetExpression:
+		err := c.compileExpressions([]ast.Expression{v.Map, v.Key})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpGet, 0, 0)
+	case ast.HasExpression:
+		err := c.compileExpressions([]ast.Expression{v.Map, v.Key})
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpHas, 0, 0)
+	case ast.KeysExpression:
+		err := c.compileExpression(v.Map)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpKeys, 0, 0)
+	case ast.LengthExpression:
+		err := c.compileExpression(v.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(v, OpLength, 0, 0)
+	default:
+		return ast.Errorf(expression.GetSpan(), "expression not yet implemented")
+	}
+
+	return nil
+}
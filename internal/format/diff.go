@@ -0,0 +1,158 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// lines of context around changes, like diff -u
+const context = 3
+
+// edit is a line that is kept, removed (-) or added (+)
+type edit struct {
+	kind byte
+	line string
+}
+
+// Diff returns a unified diff from before to after, or nil if they are the same.
+func Diff(beforeName string, afterName string, before []byte, after []byte) []byte {
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	buffer := bytes.Buffer{}
+	fmt.Fprintf(&buffer, "--- %s\n+++ %s\n", beforeName, afterName)
+	for start := 0; start < len(edits); {
+		if edits[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		// a hunk goes on until there are more unchanged lines than fit into the context of two hunks
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*context; end++ {
+			if edits[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && edits[end-1].kind == ' ' {
+			end--
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := end + context
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		beforeLine, afterLine := 1, 1
+		for _, e := range edits[:from] {
+			if e.kind != '+' {
+				beforeLine++
+			}
+			if e.kind != '-' {
+				afterLine++
+			}
+		}
+		beforeCount, afterCount := 0, 0
+		for _, e := range edits[from:to] {
+			if e.kind != '+' {
+				beforeCount++
+			}
+			if e.kind != '-' {
+				afterCount++
+			}
+		}
+		fmt.Fprintf(&buffer, "@@ -%s +%s @@\n", hunkRange(beforeLine, beforeCount), hunkRange(afterLine, afterCount))
+		for _, e := range edits[from:to] {
+			buffer.WriteByte(e.kind)
+			buffer.WriteString(e.line)
+			buffer.WriteByte('\n')
+		}
+		start = to
+	}
+	return buffer.Bytes()
+}
+
+// hunkRange leaves out counts of one, and starts empty ranges at the line before, like diff -u
+func hunkRange(line int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	default:
+		return fmt.Sprintf("%d,%d", line, count)
+	}
+}
+
+func splitLines(content []byte) []string {
+	text := strings.TrimSuffix(string(content), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines finds the longest common subsequence of lines, after skipping the common prefix and suffix,
+// which is all that changes for most formatting
+func diffLines(before []string, after []string) []edit {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	a := before[prefix : len(before)-suffix]
+	b := after[prefix : len(after)-suffix]
+
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	for _, line := range before[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for _, line := range before[len(before)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
@@ -0,0 +1,437 @@
+// Package format prints programs in a canonical layout.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/parser"
+)
+
+const indentation = "    "
+
+// Source parses src and prints it with Program.
+// It fails if the result wouldn't parse to the same program, which would be a bug of the printer.
+func Source(file string, src []byte) ([]byte, error) {
+	program, err := parser.Parse(file, src)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted := Program(program)
+	reparsed, err := parser.Parse(file, formatted)
+	if err != nil {
+		return nil, fmt.Errorf("%v: formatted program doesn't parse: %w", file, err)
+	}
+	if !ast.StructurallyEqual(program, reparsed) {
+		return nil, fmt.Errorf("%v: formatting changed the program", file)
+	}
+	return formatted, nil
+}
+
+// Program prints every element on its own line, indented by four spaces per level, with attributes in a fixed order.
+// Shorthands are used wherever the parser allows them: literal bounds of <for> and variables to iterate over are
+// attributes, and optional attributes and elements are left out if they have their default value.
+// Comments are put back before the element that followed them in the source, or at the end of the element they were in,
+// and the XML declaration is kept.
+func Program(program *ast.Program) []byte {
+	root := &element{
+		name: parser.ProgramElementName,
+		span: program.Span,
+	}
+	for _, record := range program.Records {
+		root.children = append(root.children, recordElement(record))
+	}
+	for _, enum := range program.Enums {
+		root.children = append(root.children, enumElement(enum))
+	}
+	for _, statement := range program.Statements {
+		root.children = append(root.children, statementElement(statement))
+	}
+	// types are kept apart by the parser, but stay where they were declared
+	sort.SliceStable(root.children, func(i, j int) bool {
+		return root.children[i].span.Start.Before(root.children[j].span.Start)
+	})
+
+	w := writer{comments: program.Comments}
+	if program.Declaration != "" {
+		fmt.Fprintf(&w.buffer, "<?xml %s?>\n", program.Declaration)
+	}
+	w.element(root, 0, nil)
+	w.flush(nil, 0)
+	return w.buffer.Bytes()
+}
+
+// element is what a node is printed as. Elements without a span, like <body>, don't belong to a node of their own.
+type element struct {
+	name string
+	// pairs of names and values
+	attrs    []string
+	text     string
+	hasText  bool
+	children []*element
+	span     ast.Span
+}
+
+func newElement(name string, node ast.Spanned, attrs ...string) *element {
+	e := &element{
+		name: name,
+	}
+	if node != nil {
+		e.span = node.GetSpan()
+	}
+	e.attr(attrs...)
+	return e
+}
+
+// attr adds attributes that aren't empty
+func (e *element) attr(pairs ...string) *element {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			e.attrs = append(e.attrs, pairs[i], pairs[i+1])
+		}
+	}
+	return e
+}
+
+func (e *element) add(children ...*element) *element {
+	e.children = append(e.children, children...)
+	return e
+}
+
+func (e *element) setText(text string) *element {
+	e.text = text
+	e.hasText = true
+	return e
+}
+
+func typeName(_type ast.Type) string {
+	if _type == ast.Void {
+		return ""
+	}
+	return _type.String()
+}
+
+func block(name string, statements []ast.Statement) *element {
+	e := newElement(name, nil)
+	for _, statement := range statements {
+		e.add(statementElement(statement))
+	}
+	return e
+}
+
+func expressions(e *element, exprs ...ast.Expression) *element {
+	for _, expr := range exprs {
+		e.add(expressionElement(expr))
+	}
+	return e
+}
+
+func recordElement(record ast.RecordDeclaration) *element {
+	e := newElement(parser.RecordElementName, record, "name", record.Name)
+	for _, field := range record.Fields {
+		e.add(newElement(parser.RecordFieldElementName, field, "name", field.Name, "type", typeName(field.Type)))
+	}
+	return e
+}
+
+func enumElement(enum ast.EnumDeclaration) *element {
+	e := newElement(parser.EnumElementName, enum, "name", enum.Name)
+	for _, variant := range enum.Variants {
+		e.add(newElement(parser.EnumVariantElementName, variant, "name", variant.Name))
+	}
+	return e
+}
+
+func function(e *element, args []ast.FunctionArg, returns ast.Type, body []ast.Statement) *element {
+	if len(args) > 0 || returns != ast.Void {
+		argsElement := newElement(parser.FunctionArgsElementName, nil)
+		for _, arg := range args {
+			argsElement.add(newElement(parser.FunctionArgElementName, arg, "name", arg.Name, "type", typeName(arg.Type)))
+		}
+		if returns != ast.Void {
+			argsElement.add(newElement(parser.FunctionReturnsElementName, nil, "type", typeName(returns)))
+		}
+		e.add(argsElement)
+	}
+	return e.add(block(parser.BodyElementName, body))
+}
+
+func call(name string, v ast.FunctionCall) *element {
+	e := newElement(name, v)
+	if v.Callee == nil {
+		// even if it's empty, the name tells the parser that the first argument isn't the callee
+		e.attrs = append(e.attrs, "name", v.Name)
+	} else {
+		e.add(expressionElement(v.Callee))
+	}
+	return expressions(e, v.Args...)
+}
+
+// bound is an attribute for int literals, and a child element otherwise
+func bound(e *element, name string, expr ast.Expression) {
+	if literal, ok := expr.(ast.LiteralExpression); ok && literal.Type == ast.Int {
+		e.attr(name, strconv.Itoa(literal.Int))
+		return
+	}
+	e.add(expressions(newElement(name, nil), expr))
+}
+
+func statementElement(statement ast.Statement) *element {
+	switch v := statement.(type) {
+	case ast.OutputStatement:
+		return expressions(newElement(parser.OutputStatementElementName, v), v.Exprs...)
+	case ast.VariableDeclarationStatement:
+		return newElement(parser.VariableDeclarationElementName, v, "name", v.Name, "type", typeName(v.Type))
+	case ast.VariableAssignmentStatement:
+		return expressions(newElement(parser.VariableAssignmentElementName, v, "name", v.Name), v.Expr)
+	case ast.FunctionStatement:
+		return function(newElement(parser.FunctionElementName, v, "name", v.Name), v.Args, v.Returns, v.Body)
+	case ast.FunctionReturnStatement:
+		return expressions(newElement(parser.FunctionReturnElementName, v), v.Expr)
+	case ast.FunctionCall:
+		return call(parser.FunctionCallStatementElementName, v)
+	case ast.ConditionalStatement:
+		e := newElement(parser.ConditionStatementElementName, v)
+		for _, _if := range v.Ifs {
+			e.add(newElement(parser.ConditionIfElementName, _if).add(
+				expressions(newElement(parser.ConditionElementName, nil), _if.Expr),
+				block(parser.ConditionThenElementName, _if.Then),
+			))
+		}
+		if len(v.Else) > 0 {
+			e.add(newElement(parser.ConditionElseElementName, nil).add(block(parser.ConditionThenElementName, v.Else)))
+		}
+		return e
+	case ast.LoopStatement:
+		return newElement(parser.LoopStatementElementName, v, "label", v.Label).add(
+			expressions(newElement(parser.ConditionElementName, nil), v.LoopCondition),
+			block(parser.BodyElementName, v.Body),
+		)
+	case ast.ForStatement:
+		e := newElement(parser.ForStatementElementName, v, "label", v.Label, "name", v.Name)
+		if literal, ok := v.From.(ast.LiteralExpression); !ok || literal.Type != ast.Int || literal.Int != 0 {
+			bound(e, parser.ForFromElementName, v.From)
+		}
+		bound(e, parser.ForToElementName, v.To)
+		if v.Step != nil {
+			bound(e, parser.ForStepElementName, v.Step)
+		}
+		if v.Inclusive {
+			e.attr("inclusive", "true")
+		}
+		return e.add(block(parser.BodyElementName, v.Body))
+	case ast.ForEachStatement:
+		e := newElement(parser.ForEachStatementElementName, v, "label", v.Label, "name", v.Name, "value", v.ValueName)
+		if variable, ok := v.In.(ast.VariableExpression); ok {
+			e.attrs = append(e.attrs, "in", variable.Name)
+		} else {
+			e.add(expressions(newElement(parser.ForEachInElementName, nil), v.In))
+		}
+		return e.add(block(parser.BodyElementName, v.Body))
+	case ast.BreakStatement:
+		return newElement(parser.BreakStatementElementName, v, "label", v.Label)
+	case ast.ContinueStatement:
+		return newElement(parser.ContinueStatementElementName, v, "label", v.Label)
+	case ast.ThrowStatement:
+		return expressions(newElement(parser.ThrowStatementElementName, v), v.Expr)
+	case ast.TryStatement:
+		e := newElement(parser.TryStatementElementName, v).add(block(parser.BodyElementName, v.Body))
+		for _, catch := range v.Catches {
+			e.add(newElement(parser.TryCatchElementName, catch, "name", catch.Name, "type", typeName(catch.Type)).add(
+				block(parser.BodyElementName, catch.Body),
+			))
+		}
+		if v.HasFinally {
+			e.add(newElement(parser.TryFinallyElementName, nil).add(block(parser.BodyElementName, v.Finally)))
+		}
+		return e
+	case ast.AppendStatement:
+		return expressions(newElement(parser.AppendStatementElementName, v), append([]ast.Expression{v.Array}, v.Values...)...)
+	case ast.SetIndexStatement:
+		return expressions(newElement(parser.SetIndexStatementElementName, v), v.Array, v.Index, v.Value)
+	case ast.PutStatement:
+		return expressions(newElement(parser.PutStatementElementName, v), v.Map, v.Key, v.Value)
+	case ast.DeleteStatement:
+		return expressions(newElement(parser.DeleteStatementElementName, v), v.Map, v.Key)
+	case ast.SetFieldStatement:
+		return expressions(newElement(parser.SetFieldStatementElementName, v, "name", v.Name), v.Record, v.Value)
+	case ast.MatchStatement:
+		e := newElement(parser.MatchStatementElementName, v).add(
+			expressions(newElement(parser.MatchValueElementName, nil), v.Expr),
+		)
+		for _, _case := range v.Cases {
+			e.add(newElement(parser.MatchCaseElementName, _case, "variant", _case.Variant).add(
+				block(parser.ConditionThenElementName, _case.Body),
+			))
+		}
+		if v.HasDefault {
+			e.add(newElement(parser.MatchDefaultElementName, nil).add(block(parser.ConditionThenElementName, v.Default)))
+		}
+		return e
+	default:
+		panic(fmt.Sprintf("unknown statement: %T", statement))
+	}
+}
+
+func literal(v ast.LiteralExpression) *element {
+	switch v.Type {
+	case ast.String:
+		return newElement(parser.LiteralExpressionStringElementName, v).setText(v.String)
+	case ast.Bool:
+		return newElement(parser.LiteralExpressionBoolElementName, v).setText(strconv.FormatBool(v.Bool))
+	case ast.Int:
+		return newElement(parser.LiteralExpressionIntElementName, v).setText(strconv.Itoa(v.Int))
+	case ast.Float:
+		return newElement(parser.LiteralExpressionFloatElementName, v).setText(strconv.FormatFloat(float64(v.Float), 'g', -1, 32))
+	case ast.Int64:
+		return newElement(parser.LiteralExpressionInt64ElementName, v).setText(strconv.FormatInt(v.Int64, 10))
+	case ast.Float64:
+		return newElement(parser.LiteralExpressionFloat64ElementName, v).setText(strconv.FormatFloat(v.Float64, 'g', -1, 64))
+	case ast.BigInt:
+		return newElement(parser.LiteralExpressionBigIntElementName, v).setText(v.BigInt.String())
+	default:
+		panic(fmt.Sprintf("unknown literal type: %v", v.Type))
+	}
+}
+
+func expressionElement(expression ast.Expression) *element {
+	switch v := expression.(type) {
+	case ast.LiteralExpression:
+		return literal(v)
+	case ast.VariableExpression:
+		return newElement(parser.VariableExpressionElementName, v, "name", v.Name)
+	case ast.OperatorExpression:
+		// the operators are named like their elements
+		return expressions(newElement(v.Operator.String(), v), v.Exprs...)
+	case ast.FunctionCall:
+		return call(parser.FunctionCallExpressionElementName, v)
+	case ast.LambdaExpression:
+		return function(newElement(parser.LambdaExpressionElementName, v), v.Args, v.Returns, v.Body)
+	case ast.CastExpression:
+		return expressions(newElement(parser.CastExpressionElementName, v, "type", typeName(v.Type)), v.Expr)
+	case ast.InputExpression:
+		return newElement(parser.InputExpressionElementName, v)
+	case ast.ArrayLiteral:
+		return expressions(newElement(parser.ArrayExpressionElementName, v, "type", typeName(v.ElemType)), v.Elems...)
+	case ast.IndexExpression:
+		return expressions(newElement(parser.IndexExpressionElementName, v), v.Expr, v.Index)
+	case ast.LengthExpression:
+		return expressions(newElement(parser.LengthExpressionElementName, v), v.Expr)
+	case ast.MapLiteral:
+		e := newElement(parser.MapExpressionElementName, v, "key", typeName(v.KeyType), "value", typeName(v.ValueType))
+		for _, entry := range v.Entries {
+			e.add(expressions(newElement(parser.MapEntryElementName, entry), entry.Key, entry.Value))
+		}
+		return e
+	case ast.GetExpression:
+		return expressions(newElement(parser.GetExpressionElementName, v), v.Map, v.Key)
+	case ast.HasExpression:
+		return expressions(newElement(parser.HasExpressionElementName, v), v.Map, v.Key)
+	case ast.KeysExpression:
+		return expressions(newElement(parser.KeysExpressionElementName, v), v.Map)
+	case ast.NewExpression:
+		e := newElement(parser.NewExpressionElementName, v, "type", typeName(v.Type))
+		for _, field := range v.Fields {
+			e.add(expressions(newElement(parser.RecordFieldElementName, field, "name", field.Name), field.Expr))
+		}
+		return e
+	case ast.GetFieldExpression:
+		return expressions(newElement(parser.GetFieldExpressionElementName, v, "name", v.Name), v.Expr)
+	case ast.VariantLiteral:
+		return newElement(parser.VariantExpressionElementName, v, "type", typeName(v.Type), "name", v.Name)
+	case ast.NoneLiteral:
+		return newElement(parser.NoneExpressionElementName, v)
+	case ast.SomeExpression:
+		return expressions(newElement(parser.SomeExpressionElementName, v), v.Expr)
+	case ast.IsNoneExpression:
+		return expressions(newElement(parser.IsNoneExpressionElementName, v), v.Expr)
+	case ast.UnwrapOrExpression:
+		return expressions(newElement(parser.UnwrapOrExpressionElementName, v), v.Expr, v.Default)
+	default:
+		panic(fmt.Sprintf("unknown expression: %T", expression))
+	}
+}
+
+type writer struct {
+	buffer bytes.Buffer
+	// the comments that haven't been written yet
+	comments []ast.Comment
+}
+
+func (w *writer) indent(depth int) {
+	w.buffer.WriteString(strings.Repeat(indentation, depth))
+}
+
+// pending reports whether the next comment starts before position
+func (w *writer) pending(position ast.Position) bool {
+	return len(w.comments) > 0 && w.comments[0].Span.Start.Before(position)
+}
+
+// flush writes the comments that start before position, or all of them for nil
+func (w *writer) flush(position *ast.Position, depth int) {
+	for len(w.comments) > 0 && (position == nil || w.pending(*position)) {
+		w.indent(depth)
+		w.buffer.WriteString("<!--" + w.comments[0].Text + "-->\n")
+		w.comments = w.comments[1:]
+	}
+}
+
+// end is where the comments that are written before the closing tag have to start before.
+// Elements without a span take it over from their parent if they are its last child, so that comments at the end
+// of a <body> stay in it.
+func (w *writer) element(e *element, depth int, end *ast.Position) {
+	hasSpan := e.span.Start.Line > 0
+	if hasSpan {
+		w.flush(&e.span.Start, depth)
+		end = &e.span.End
+	}
+
+	w.indent(depth)
+	w.buffer.WriteString("<" + e.name)
+	for i := 0; i < len(e.attrs); i += 2 {
+		w.buffer.WriteString(" " + e.attrs[i] + `="` + escape(e.attrs[i+1], true) + `"`)
+	}
+
+	switch {
+	// comments inside of text would change it, so they are written after the element instead
+	case len(e.children) > 0 || end != nil && !e.hasText && w.pending(*end):
+		w.buffer.WriteString(">\n")
+		for i, child := range e.children {
+			var childEnd *ast.Position
+			if i == len(e.children)-1 {
+				childEnd = end
+			}
+			w.element(child, depth+1, childEnd)
+		}
+		if end != nil {
+			w.flush(end, depth+1)
+		}
+		w.indent(depth)
+	case e.text != "":
+		w.buffer.WriteString(">" + escape(e.text, false))
+	default:
+		w.buffer.WriteString("/>\n")
+		if hasSpan {
+			w.flush(end, depth)
+		}
+		return
+	}
+	w.buffer.WriteString("</" + e.name + ">\n")
+	if hasSpan && e.hasText {
+		w.flush(end, depth)
+	}
+}
+
+// escape only escapes what has to be, so types read like array&lt;int> just as they are written by hand.
+// Carriage returns are escaped too, since XML parsers turn them into line feeds.
+func escape(text string, attribute bool) string {
+	replacements := []string{"&", "&amp;", "<", "&lt;", "]]>", "]]&gt;", "\r", "&#xD;"}
+	if attribute {
+		replacements = append(replacements, `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
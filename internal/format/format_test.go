@@ -0,0 +1,88 @@
+package format
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/parser"
+)
+
+func comments(program *ast.Program) []string {
+	var texts []string
+	for _, comment := range program.Comments {
+		texts = append(texts, comment.Text)
+	}
+	return texts
+}
+
+func TestSource(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "../../test.xml")
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			program, err := parser.Parse(file, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			formatted, err := Source(file, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reparsed, err := parser.Parse(file, formatted)
+			if err != nil {
+				t.Fatalf("formatted program doesn't parse: %v", err)
+			}
+			if !ast.StructurallyEqual(program, reparsed) {
+				t.Errorf("formatting changed the program:\n%s", formatted)
+			}
+			if got, want := comments(reparsed), comments(program); strings.Join(got, "\x00") != strings.Join(want, "\x00") || len(got) != bytes.Count(src, []byte("<!--")) {
+				t.Errorf("expected comments %q, got %q", want, got)
+			}
+			if reparsed.Declaration != program.Declaration {
+				t.Errorf("expected XML declaration %q, got %q", program.Declaration, reparsed.Declaration)
+			}
+
+			again, err := Source(file, formatted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, formatted) {
+				t.Errorf("formatting isn't idempotent:\n%s", Diff(file, file, formatted, again))
+			}
+		})
+	}
+}
+
+func TestSourceKeepsEverything(t *testing.T) {
+	sources := []struct {
+		src  string
+		want string
+	}{
+		{`<program><output><string>a<int>5</int>b</string></output></program>`, "unexpected <int> in <string>"},
+		{`<program><output foo="bar"><int>1</int></output></program>`, "unexpected attribute foo on <output>"},
+		{`<program><declare name="x" type="int"/><output><var name="x" junk="1"/></output></program>`, "unexpected attribute junk on <var>"},
+		{`<program><output>text<int>1</int></output></program>`, `unexpected text "text" in <output>`},
+		{`<program><declare name="x" type="int"><int>1</int></declare></program>`, "unexpected <int> in <declare>"},
+		{`<program><record name="P"><field name="x" type="int"><int>1</int></field></record></program>`, "unexpected <int> in <field>"},
+		{`<program><record name="P"><field name="x" type="int"/></record><output><new type="P"><field name="x" type="int"><int>1</int></field></new></output></program>`, "unexpected attribute type on <field>"},
+		{`<program><switch><if><cond><bool>true</bool></cond><then/></if><else><then/><output/></else></switch></program>`, "unexpected <output> in <else>"},
+	}
+	for _, source := range sources {
+		_, err := Source("test.xml", []byte(source.src))
+		if err == nil || !strings.Contains(err.Error(), source.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", source.src, source.want, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+	"xml-programming/internal/ast"
+	"xml-programming/xmlp"
+)
+
+// document is a file the editor has open. Offsets are bytes into text, like the columns of spans;
+// the editor counts characters in UTF-16 code units instead.
+type document struct {
+	uri  string
+	text string
+	// the offsets at which lines start
+	lines []int
+
+	// from the last version of the text that parsed, which is indexed; completion still uses it while the text doesn't parse
+	index   *xmlp.Index
+	indexed string
+}
+
+func newDocument(uri string, text string) *document {
+	d := &document{
+		uri: uri,
+	}
+	d.update(text)
+	return d
+}
+
+func (d *document) update(text string) {
+	d.text = text
+	d.lines = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// current reports whether the index belongs to the text as it is now, so its spans can be found in it
+func (d *document) current() bool {
+	return d.index != nil && d.indexed == d.text
+}
+
+func (d *document) lineEnd(line int) int {
+	if line+1 < len(d.lines) {
+		return d.lines[line+1] - 1
+	}
+	return len(d.text)
+}
+
+func (d *document) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[p.Line]
+	end := d.lineEnd(p.Line)
+	for units := 0; offset < end && units < p.Character; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16Length(r)
+		offset += size
+	}
+	return offset
+}
+
+func utf16Length(r rune) int {
+	if r >= 0x10000 {
+		// a surrogate pair
+		return 2
+	}
+	return 1
+}
+
+func (d *document) position(offset int) position {
+	line := sort.Search(len(d.lines), func(i int) bool {
+		return d.lines[i] > offset
+	}) - 1
+	units := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		units += utf16Length(r)
+	}
+	return position{Line: line, Character: units}
+}
+
+// astOffset returns the offset of a position of a span, whose lines and columns start at 1
+func (d *document) astOffset(p ast.Position) int {
+	if p.Line < 1 {
+		return 0
+	}
+	if p.Line > len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[p.Line-1]
+	if p.Column > 1 {
+		offset += p.Column - 1
+	}
+	if end := d.lineEnd(p.Line - 1); offset > end && p.Line < len(d.lines) {
+		// columns can't go past the end of their line, unless the span ends after the last line break
+		offset = end
+	}
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	return offset
+}
+
+func (d *document) astPosition(offset int) ast.Position {
+	p := d.position(offset)
+	return ast.Position{Line: p.Line + 1, Column: offset - d.lines[p.Line] + 1}
+}
+
+func (d *document) textRange(start int, end int) textRange {
+	return textRange{Start: d.position(start), End: d.position(end)}
+}
+
+// spanRange returns the range of the start tag of the element at span; the rest of an element can be very long,
+// like the body of a function. Spans of a single point, like those of syntax errors, cover the rest of their line.
+func (d *document) spanRange(span ast.Span) textRange {
+	start := d.astOffset(span.Start)
+	if span.End.Line == 0 || span.End == span.Start {
+		return d.textRange(start, d.lineEnd(d.position(start).Line))
+	}
+	end := d.astOffset(span.End)
+	if tagEnd := strings.IndexByte(d.text[start:end], '>'); tagEnd >= 0 {
+		end = start + tagEnd + 1
+	}
+	return d.textRange(start, end)
+}
+
+// elementRange returns the range of the whole element at span
+func (d *document) elementRange(span ast.Span) textRange {
+	return d.textRange(d.astOffset(span.Start), d.astOffset(span.End))
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// attribute finds the value of the attribute name in the start tag of the element at span, and returns its offsets
+func (d *document) attribute(span ast.Span, name string) (int, int, bool) {
+	text := d.text
+	i := d.astOffset(span.Start)
+	if i >= len(text) || text[i] != '<' {
+		return 0, 0, false
+	}
+	for i++; i < len(text) && !isSpace(text[i]) && text[i] != '>' && text[i] != '/'; i++ {
+	}
+
+	for {
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+		if i >= len(text) || text[i] == '>' || text[i] == '/' {
+			return 0, 0, false
+		}
+		nameStart := i
+		for i < len(text) && text[i] != '=' && !isSpace(text[i]) && text[i] != '>' {
+			i++
+		}
+		attr := text[nameStart:i]
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+		if i >= len(text) || text[i] != '=' {
+			return 0, 0, false
+		}
+		for i++; i < len(text) && isSpace(text[i]); i++ {
+		}
+		if i >= len(text) || (text[i] != '"' && text[i] != '\'') {
+			return 0, 0, false
+		}
+		quote := text[i]
+		i++
+		length := strings.IndexByte(text[i:], quote)
+		if length < 0 {
+			return 0, 0, false
+		}
+		if attr == name {
+			return i, i + length, true
+		}
+		i += length + 1
+	}
+}
+
+// occurrence is where the name of a symbol is written, in its declaration or a reference
+type occurrence struct {
+	start  int
+	end    int
+	symbol *xmlp.Symbol
+}
+
+func (d *document) occurrences() []occurrence {
+	if !d.current() {
+		return nil
+	}
+	var occurrences []occurrence
+	for _, symbol := range d.index.Symbols {
+		if symbol.IsGlobal() {
+			continue
+		}
+		if start, end, ok := d.attribute(symbol.Span, symbol.Attr); ok {
+			occurrences = append(occurrences, occurrence{start, end, symbol})
+		}
+	}
+	for _, reference := range d.index.References {
+		if start, end, ok := d.attribute(reference.Span, reference.Attr); ok {
+			occurrences = append(occurrences, occurrence{start, end, reference.Symbol})
+		}
+	}
+	return occurrences
+}
+
+// occurrenceAt returns the name at offset, which might be right after its last character
+func (d *document) occurrenceAt(offset int) (occurrence, bool) {
+	for _, o := range d.occurrences() {
+		if o.start <= offset && offset <= o.end {
+			return o, true
+		}
+	}
+	return occurrence{}, false
+}
+
+// completion is what is being typed at an offset: the name of an element, or the value of one of its attributes
+type completion struct {
+	// the element is empty for an element name
+	element   string
+	attribute string
+}
+
+func (d *document) completionAt(offset int) (completion, bool) {
+	before := d.text[:offset]
+	open := strings.LastIndexByte(before, '<')
+	if open < 0 {
+		return completion{}, false
+	}
+	tag := before[open+1:]
+	if strings.HasPrefix(tag, "/") || strings.HasPrefix(tag, "!") || strings.HasPrefix(tag, "?") {
+		return completion{}, false
+	}
+
+	// types in attributes can contain >, so the end of the tag is only found outside of quotes
+	var quote byte
+	valueStart := 0
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			valueStart = i
+		case c == '>':
+			return completion{}, false
+		}
+	}
+
+	nameEnd := strings.IndexFunc(tag, func(r rune) bool {
+		return r < utf8.RuneSelf && isSpace(byte(r))
+	})
+	if nameEnd < 0 {
+		return completion{}, true
+	}
+	if quote == 0 {
+		return completion{}, false
+	}
+	attribute := strings.TrimRight(tag[:valueStart], " \t\r\n=")
+	attributeStart := strings.LastIndexFunc(attribute, func(r rune) bool {
+		return r < utf8.RuneSelf && isSpace(byte(r))
+	})
+	return completion{
+		element:   tag[:nameEnd],
+		attribute: attribute[attributeStart+1:],
+	}, true
+}
@@ -0,0 +1,502 @@
+// Package lsp implements a Language Server Protocol server for programs, so that editors can show their diagnostics,
+// go to definitions, show types on hover, complete names and rename variables and functions.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"xml-programming/internal/analysis"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/parser"
+	"xml-programming/xmlp"
+)
+
+// ErrNoShutdown is returned by Serve if the editor exits the server without asking it to shut down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+type server struct {
+	interpreter *xmlp.Interpreter
+	out         io.Writer
+	documents   map[string]*document
+	shutdown    bool
+}
+
+// Serve answers requests read from in until the editor exits the server, or closes in.
+// Programs are analysed with the functions of interpreter.
+func Serve(in io.Reader, out io.Writer, interpreter *xmlp.Interpreter) error {
+	s := &server{
+		interpreter: interpreter,
+		out:         out,
+		documents:   map[string]*document{},
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		m, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		var invalid *responseError
+		if errors.As(err, &invalid) {
+			// the message can't be answered without its ID
+			err = s.send(&message{ID: &nullID, Error: invalid})
+		}
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		err = s.handle(m)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var nullID = json.RawMessage("null")
+
+func (s *server) send(m *message) error {
+	return writeMessage(s.out, m)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.send(&message{Method: method, Params: content})
+}
+
+func (s *server) handle(m *message) error {
+	if m.ID == nil {
+		return s.notification(m.Method, m.Params)
+	}
+
+	result, err := s.request(m.Method, m.Params)
+	response := &message{ID: m.ID}
+	if err != nil {
+		var failed *responseError
+		if !errors.As(err, &failed) {
+			failed = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		response.Error = failed
+	} else {
+		response.Result, err = json.Marshal(result)
+		if err != nil {
+			return err
+		}
+	}
+	return s.send(response)
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	err := json.Unmarshal(params, v)
+	if err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *server) notification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if decode(params, &p) != nil {
+			return nil
+		}
+		s.documents[p.TextDocument.URI] = newDocument(p.TextDocument.URI, p.TextDocument.Text)
+		return s.analyse(s.documents[p.TextDocument.URI])
+	case "textDocument/didChange":
+		var p didChangeParams
+		if decode(params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		d, ok := s.documents[p.TextDocument.URI]
+		if !ok {
+			return nil
+		}
+		d.update(p.ContentChanges[len(p.ContentChanges)-1].Text)
+		return s.analyse(d)
+	case "textDocument/didClose":
+		var p didCloseParams
+		if decode(params, &p) != nil {
+			return nil
+		}
+		delete(s.documents, p.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         p.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+	default:
+		// like initialized, or notifications of features the server doesn't have
+		return nil
+	}
+}
+
+func (s *server) request(method string, params json.RawMessage) (interface{}, error) {
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shutting down"}
+	}
+
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"<", `"`},
+				},
+				"renameProvider":         true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{
+				"name": "xmlp",
+			},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		return s.withPosition(params, s.definition)
+	case "textDocument/hover":
+		return s.withPosition(params, s.hover)
+	case "textDocument/completion":
+		return s.withPosition(params, s.completion)
+	case "textDocument/rename":
+		var p renameParams
+		err := decode(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.rename(d, d.offset(p.Position), p.NewName)
+	case "textDocument/documentSymbol":
+		var p documentSymbolParams
+		err := decode(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(d), nil
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + method}
+	}
+}
+
+func (s *server) document(uri string) (*document, error) {
+	d, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "document is not open: " + uri}
+	}
+	return d, nil
+}
+
+func (s *server) withPosition(params json.RawMessage, f func(d *document, offset int) (interface{}, error)) (interface{}, error) {
+	var p positionParams
+	err := decode(params, &p)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return f(d, d.offset(p.Position))
+}
+
+// analyse publishes the diagnostics of the document, and indexes it if it parses
+func (s *server) analyse(d *document) error {
+	index, diagnostics := s.interpreter.Analyse(d.uri, []byte(d.text))
+	if index != nil {
+		d.index = index
+		d.indexed = d.text
+	}
+
+	published := []diagnostic{}
+	for _, diagnostic := range diagnostics {
+		severity := severityError
+		if diagnostic.Severity == xmlp.Warning {
+			severity = severityWarning
+		}
+		published = append(published, newDiagnostic(d.spanRange(diagnostic.Span), severity, diagnostic))
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         d.uri,
+		Diagnostics: published,
+	})
+}
+
+func newDiagnostic(r textRange, severity int, d xmlp.Diagnostic) diagnostic {
+	return diagnostic{
+		Range:    r,
+		Severity: severity,
+		Code:     string(d.Code),
+		Source:   "xmlp",
+		Message:  d.Message,
+	}
+}
+
+func (s *server) definition(d *document, offset int) (interface{}, error) {
+	o, ok := d.occurrenceAt(offset)
+	if !ok || o.symbol.IsGlobal() {
+		return nil, nil
+	}
+	start, end, ok := d.attribute(o.symbol.Span, o.symbol.Attr)
+	if !ok {
+		return location{URI: d.uri, Range: d.spanRange(o.symbol.Span)}, nil
+	}
+	return location{URI: d.uri, Range: d.textRange(start, end)}, nil
+}
+
+// describe shows a symbol like it would be declared: x: int, or func f(int): int
+func describe(symbol *xmlp.Symbol) string {
+	if symbol.Kind == analysis.VariableSymbol {
+		return symbol.Name + ": " + symbol.Type.String()
+	}
+	if symbol.Type == ast.Void {
+		// functions of the standard library that accept several types
+		return "func " + symbol.Name
+	}
+	return "func " + symbol.Name + strings.TrimPrefix(symbol.Type.String(), "func")
+}
+
+func (s *server) hover(d *document, offset int) (interface{}, error) {
+	if o, ok := d.occurrenceAt(offset); ok {
+		r := d.textRange(o.start, o.end)
+		return hover{
+			Contents: markupContent{Kind: "plaintext", Value: describe(o.symbol)},
+			Range:    &r,
+		}, nil
+	}
+	if !d.current() {
+		return nil, nil
+	}
+	_type, ok := d.index.TypeAt(d.astPosition(offset))
+	if !ok {
+		return nil, nil
+	}
+	return hover{
+		Contents: markupContent{Kind: "plaintext", Value: _type.String()},
+	}, nil
+}
+
+// elementNames are offered when the name of an element is being typed
+var elementNames = func() []string {
+	names := []string{
+		parser.ProgramElementName, parser.OutputStatementElementName, parser.VariableDeclarationElementName,
+		parser.VariableAssignmentElementName, parser.LiteralExpressionStringElementName, parser.LiteralExpressionBoolElementName,
+		parser.LiteralExpressionIntElementName, parser.LiteralExpressionFloatElementName, parser.LiteralExpressionInt64ElementName,
+		parser.LiteralExpressionFloat64ElementName, parser.LiteralExpressionBigIntElementName, parser.VariableExpressionElementName,
+		parser.OperatorExpressionAddElementName, parser.OperatorExpressionSubElementName, parser.OperatorExpressionMulElementName,
+		parser.OperatorExpressionDivElementName, parser.OperatorExpressionModElementName, parser.OperatorExpressionConcatElementName,
+		parser.OperatorExpressionEqualElementName, parser.OperatorExpressionGreaterThanElementName,
+		parser.OperatorExpressionLessThanElementName, parser.OperatorExpressionNotElementName, parser.OperatorExpressionAndElementName,
+		parser.OperatorExpressionOrElementName, parser.FunctionCallExpressionElementName, parser.InputExpressionElementName,
+		parser.ArrayExpressionElementName, parser.IndexExpressionElementName, parser.LengthExpressionElementName,
+		parser.MapExpressionElementName, parser.MapEntryElementName, parser.GetExpressionElementName, parser.HasExpressionElementName,
+		parser.KeysExpressionElementName, parser.NewExpressionElementName, parser.GetFieldExpressionElementName,
+		parser.VariantExpressionElementName, parser.NoneExpressionElementName, parser.SomeExpressionElementName,
+		parser.IsNoneExpressionElementName, parser.UnwrapOrExpressionElementName, parser.LambdaExpressionElementName,
+		parser.CastExpressionElementName, parser.FunctionElementName, parser.FunctionArgsElementName, parser.FunctionArgElementName,
+		parser.FunctionReturnsElementName, parser.BodyElementName, parser.FunctionReturnElementName, parser.ConditionStatementElementName,
+		parser.ConditionIfElementName, parser.ConditionElseElementName, parser.ConditionElementName, parser.ConditionThenElementName,
+		parser.LoopStatementElementName, parser.ForStatementElementName, parser.ForFromElementName, parser.ForToElementName,
+		parser.ForStepElementName, parser.ForEachStatementElementName, parser.ForEachInElementName, parser.BreakStatementElementName,
+		parser.ContinueStatementElementName, parser.ThrowStatementElementName, parser.TryStatementElementName,
+		parser.TryCatchElementName, parser.TryFinallyElementName, parser.AppendStatementElementName,
+		parser.SetIndexStatementElementName, parser.PutStatementElementName, parser.DeleteStatementElementName,
+		parser.SetFieldStatementElementName, parser.RecordElementName, parser.RecordFieldElementName, parser.EnumElementName,
+		parser.MatchStatementElementName, parser.MatchValueElementName, parser.MatchCaseElementName, parser.MatchDefaultElementName,
+	}
+	sort.Strings(names)
+	unique := names[:0]
+	for i, name := range names {
+		// some names are used by several elements, like <call> for statements and expressions
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}()
+
+func (s *server) completion(d *document, offset int) (interface{}, error) {
+	items := []completionItem{}
+	c, ok := d.completionAt(offset)
+	if !ok {
+		return items, nil
+	}
+	if c.element == "" {
+		for _, name := range elementNames {
+			items = append(items, completionItem{Label: name, Kind: completionKeyword})
+		}
+		return items, nil
+	}
+
+	isVariable := func(symbol *xmlp.Symbol) bool {
+		return symbol.Kind == analysis.VariableSymbol
+	}
+	var accepts func(symbol *xmlp.Symbol) bool
+	switch {
+	case c.element == parser.VariableExpressionElementName && c.attribute == "name":
+		// functions can be used as values, unless they have no function type
+		accepts = func(symbol *xmlp.Symbol) bool {
+			return isVariable(symbol) || symbol.Type != ast.Void
+		}
+	case c.element == parser.VariableAssignmentElementName && c.attribute == "name",
+		c.element == parser.ForEachStatementElementName && c.attribute == "in":
+		accepts = isVariable
+	case c.element == parser.FunctionCallExpressionElementName && c.attribute == "name":
+		accepts = func(symbol *xmlp.Symbol) bool {
+			return !isVariable(symbol) || symbol.Type.IsFunction()
+		}
+	}
+	if d.index == nil || accepts == nil {
+		return items, nil
+	}
+
+	for _, symbol := range d.index.Visible(d.astPosition(offset)) {
+		if !accepts(symbol) {
+			continue
+		}
+		kind := completionFunction
+		if isVariable(symbol) {
+			kind = completionVariable
+		}
+		items = append(items, completionItem{Label: symbol.Name, Kind: kind, Detail: describe(symbol)})
+	}
+	return items, nil
+}
+
+// names have to stay what the parser reads as the same attribute value, and can't be empty
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "<>&\"' \t\r\n")
+}
+
+func (s *server) rename(d *document, offset int, newName string) (interface{}, error) {
+	o, ok := d.occurrenceAt(offset)
+	if !ok {
+		return nil, fmt.Errorf("there is no variable or function to rename here")
+	}
+	if o.symbol.IsGlobal() {
+		return nil, fmt.Errorf("%s can't be renamed, it isn't declared by the program", o.symbol.Name)
+	}
+	if !validName(newName) {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid name: %q", newName)}
+	}
+
+	if newName != o.symbol.Name {
+		err := conflict(d.index, o.symbol, newName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var edits []textEdit
+	for _, other := range d.occurrences() {
+		if other.symbol == o.symbol {
+			edits = append(edits, textEdit{Range: d.textRange(other.start, other.end), NewText: newName})
+		}
+	}
+	return workspaceEdit{Changes: map[string][]textEdit{d.uri: edits}}, nil
+}
+
+// uses returns where symbol is declared and referenced
+func uses(index *xmlp.Index, symbol *xmlp.Symbol) []ast.Position {
+	var positions []ast.Position
+	if !symbol.IsGlobal() {
+		positions = append(positions, symbol.Span.Start)
+	}
+	for _, reference := range index.ReferencesTo(symbol) {
+		positions = append(positions, reference.Span.Start)
+	}
+	return positions
+}
+
+// conflict checks that renaming symbol to newName doesn't change what any name refers to: no other symbol
+// called newName may be visible where symbol is used, and symbol may not shadow one where that is used
+func conflict(index *xmlp.Index, symbol *xmlp.Symbol, newName string) error {
+	for _, position := range uses(index, symbol) {
+		for _, other := range index.Visible(position) {
+			if other != symbol && other.Name == newName {
+				return fmt.Errorf("%s can't be renamed to %s, which is already visible at %d:%d", symbol.Name, newName, position.Line, position.Column)
+			}
+		}
+	}
+	for _, other := range index.Symbols {
+		if other == symbol || other.Name != newName {
+			continue
+		}
+		for _, position := range uses(index, other) {
+			// names resolve to the innermost symbol, and one in the same scope would be a duplicate
+			if symbol.InScope(position) && !symbol.Scope.Start.Before(other.Scope.Start) {
+				return fmt.Errorf("%s can't be renamed to %s, it would shadow the %s at %d:%d", symbol.Name, newName, newName, position.Line, position.Column)
+			}
+		}
+	}
+	return nil
+}
+
+// documentSymbols lists the functions, with the ones declared inside of others as their children
+func (s *server) documentSymbols(d *document) []documentSymbol {
+	if !d.current() {
+		return []documentSymbol{}
+	}
+
+	var functions []*xmlp.Symbol
+	for _, symbol := range d.index.Symbols {
+		if symbol.Kind == analysis.FunctionSymbol && !symbol.IsGlobal() {
+			functions = append(functions, symbol)
+		}
+	}
+	sort.SliceStable(functions, func(i, j int) bool {
+		return functions[i].Span.Start.Before(functions[j].Span.Start)
+	})
+
+	var nest func(parent *ast.Span) []documentSymbol
+	nest = func(parent *ast.Span) []documentSymbol {
+		symbols := []documentSymbol{}
+		for len(functions) > 0 {
+			function := functions[0]
+			if parent != nil && !function.Span.Start.Before(parent.End) {
+				break
+			}
+			functions = functions[1:]
+
+			symbol := documentSymbol{
+				Name:   function.Name,
+				Detail: strings.TrimPrefix(function.Type.String(), "func"),
+				Kind:   symbolFunction,
+				Range:  d.elementRange(function.Span),
+			}
+			symbol.SelectionRange = d.spanRange(function.Span)
+			if start, end, ok := d.attribute(function.Span, function.Attr); ok {
+				symbol.SelectionRange = d.textRange(start, end)
+			}
+			symbol.Children = nest(&function.Span)
+			symbols = append(symbols, symbol)
+		}
+		return symbols
+	}
+	return nest(nil)
+}
@@ -0,0 +1,293 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"xml-programming/xmlp"
+)
+
+// client talks to a server over pipes, like an editor over stdio
+type client struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	nextID int
+	// what the server sends is read all the time, as it blocks until it is
+	messages chan *message
+	// the notifications received while waiting for responses
+	notifications []*message
+}
+
+func newClient(t *testing.T) (*client, chan error) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := Serve(inReader, outWriter, xmlp.New())
+		_ = outWriter.Close()
+		done <- err
+	}()
+
+	messages := make(chan *message, 16)
+	go func() {
+		reader := bufio.NewReader(outReader)
+		for {
+			m, err := readMessage(reader)
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- m
+		}
+	}()
+	return &client{t: t, in: inWriter, messages: messages}, done
+}
+
+func (c *client) write(m *message, params interface{}) {
+	c.t.Helper()
+	content, err := json.Marshal(params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	m.Params = content
+	err = writeMessage(c.in, m)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.write(&message{Method: method}, params)
+}
+
+// request returns the response to a request, after the notifications sent before it
+func (c *client) request(method string, params interface{}) *message {
+	c.t.Helper()
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	c.write(&message{ID: &id, Method: method}, params)
+	for {
+		m, ok := <-c.messages
+		if !ok {
+			c.t.Fatalf("the server stopped before answering %s", method)
+		}
+		if m.ID == nil {
+			c.notifications = append(c.notifications, m)
+			continue
+		}
+		if string(*m.ID) != string(id) {
+			c.t.Fatalf("expected a response to %s, got one to %s", id, *m.ID)
+		}
+		return m
+	}
+}
+
+// result decodes the result of a request into v, which fails the test if the request failed
+func (c *client) result(method string, params interface{}, v interface{}) {
+	c.t.Helper()
+	m := c.request(method, params)
+	if m.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, m.Error.Message)
+	}
+	err := json.Unmarshal(m.Result, v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// diagnostics returns the last diagnostics published for uri
+func (c *client) diagnostics(uri string) []diagnostic {
+	c.t.Helper()
+	// the server answers in order, so the notifications of earlier messages have arrived with the response
+	c.request("textDocument/hover", positionParams{TextDocument: textDocumentIdentifier{URI: uri}})
+	var last *publishDiagnosticsParams
+	for _, m := range c.notifications {
+		var p publishDiagnosticsParams
+		if m.Method == "textDocument/publishDiagnostics" && json.Unmarshal(m.Params, &p) == nil && p.URI == uri {
+			last = &p
+		}
+	}
+	if last == nil {
+		c.t.Fatalf("no diagnostics were published for %s", uri)
+	}
+	return last.Diagnostics
+}
+
+var testProgram = []string{
+	`<program>`,
+	`    <declare name="total" type="int"/>`,
+	`    <assign name="total"><int>3</int></assign>`,
+	`    <func name="twice"><args><arg name="n" type="int"/><returns type="int"/></args><body>`,
+	`        <return><mul><var name="n"/><int>2</int></mul></return>`,
+	`    </body></func>`,
+	`    <for name="i" from="0" to="1"><body><output><var name="total"/></output></body></for>`,
+	`    <output><call name="twice"><var name="total"/></call></output>`,
+	`</program>`,
+}
+
+const testURI = "file:///test.xml"
+
+// at returns the position of the nth (from 0) occurrence of text on a line of the test program, plus offset
+func at(t *testing.T, line int, text string, nth int, offset int) position {
+	t.Helper()
+	character := -1
+	for i := 0; i <= nth; i++ {
+		next := strings.Index(testProgram[line][character+1:], text)
+		if next < 0 {
+			t.Fatalf("%q isn't on line %d %d times", text, line, nth+1)
+		}
+		character += next + 1
+	}
+	return position{Line: line, Character: character + offset}
+}
+
+func params(p position) positionParams {
+	return positionParams{TextDocument: textDocumentIdentifier{URI: testURI}, Position: p}
+}
+
+func TestServer(t *testing.T) {
+	c, done := newClient(t)
+	c.result("initialize", map[string]interface{}{}, &map[string]interface{}{})
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", didOpenParams{TextDocument: textDocumentItem{
+		URI:  "file:///broken.xml",
+		Text: `<program><output><var name="missing"/></output></program>`,
+	}})
+	diagnostics := c.diagnostics("file:///broken.xml")
+	if len(diagnostics) != 1 || diagnostics[0].Code != "unknown-name" || diagnostics[0].Range.Start != (position{0, 17}) {
+		t.Errorf("expected an unknown-name diagnostic at 0:17, got %+v", diagnostics)
+	}
+
+	c.notify("textDocument/didOpen", didOpenParams{TextDocument: textDocumentItem{
+		URI:  testURI,
+		Text: strings.Join(testProgram, "\n"),
+	}})
+	if diagnostics := c.diagnostics(testURI); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diagnostics)
+	}
+
+	t.Run("definition", func(t *testing.T) {
+		definitions := []struct {
+			name  string
+			from  position
+			start position
+		}{
+			{"var", at(t, 6, `"total"`, 0, 2), at(t, 1, `total`, 0, 0)},
+			{"call", at(t, 7, `"twice"`, 0, 2), at(t, 3, `twice`, 0, 0)},
+			{"argument", at(t, 4, `"n"`, 0, 1), at(t, 3, `"n"`, 0, 1)},
+		}
+		for _, definition := range definitions {
+			var got location
+			c.result("textDocument/definition", params(definition.from), &got)
+			if got.URI != testURI || got.Range.Start != definition.start {
+				t.Errorf("%s: expected a definition at %v, got %+v", definition.name, definition.start, got)
+			}
+		}
+	})
+
+	t.Run("hover", func(t *testing.T) {
+		hovers := []struct {
+			at   position
+			want string
+		}{
+			{at(t, 6, `"total"`, 0, 2), "total: int"},
+			{at(t, 7, `"twice"`, 0, 2), "func twice(int): int"},
+			{at(t, 4, `<mul>`, 0, 1), "int"},
+		}
+		for _, h := range hovers {
+			var got hover
+			c.result("textDocument/hover", params(h.at), &got)
+			if got.Contents.Value != h.want {
+				t.Errorf("at %v: expected %q, got %q", h.at, h.want, got.Contents.Value)
+			}
+		}
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		labels := func(p position) map[string]bool {
+			var items []completionItem
+			c.result("textDocument/completion", params(p), &items)
+			found := map[string]bool{}
+			for _, item := range items {
+				found[item.Label] = true
+			}
+			return found
+		}
+
+		names := labels(at(t, 4, `"n"`, 0, 1))
+		for _, name := range []string{"n", "total", "twice"} {
+			if !names[name] {
+				t.Errorf("expected %s to be completed in the function, got %v", name, names)
+			}
+		}
+		if names["i"] {
+			t.Errorf("i isn't in scope in the function, but it is completed")
+		}
+
+		elements := labels(at(t, 4, `<mul>`, 0, 1))
+		if !elements["mul"] || !elements["output"] || elements["n"] {
+			t.Errorf("expected element names, got %v", elements)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		var edit workspaceEdit
+		c.result("textDocument/rename", renameParams{positionParams: params(at(t, 1, `total`, 0, 1)), NewName: "sum"}, &edit)
+		// the declaration, the assignment and two reads
+		if edits := edit.Changes[testURI]; len(edits) != 4 {
+			t.Errorf("expected 4 edits, got %+v", edits)
+		}
+		for _, e := range edit.Changes[testURI] {
+			if e.NewText != "sum" {
+				t.Errorf("expected sum, got %q", e.NewText)
+			}
+		}
+
+		conflicts := []struct {
+			from    position
+			newName string
+		}{
+			// the loop variable would be read instead of total
+			{at(t, 1, `total`, 0, 1), "i"},
+			// the loop variable would shadow total
+			{at(t, 6, `"i"`, 0, 1), "total"},
+			// twice would be a duplicate of total
+			{at(t, 3, `twice`, 0, 1), "total"},
+			// total would be a duplicate of twice, and be called instead of it
+			{at(t, 1, `total`, 0, 1), "twice"},
+		}
+		for _, conflict := range conflicts {
+			m := c.request("textDocument/rename", renameParams{positionParams: params(conflict.from), NewName: conflict.newName})
+			if m.Error == nil {
+				t.Errorf("expected renaming at %v to %s to fail, got %s", conflict.from, conflict.newName, m.Result)
+			}
+		}
+
+		c.result("textDocument/rename", renameParams{positionParams: params(at(t, 4, `"n"`, 0, 1)), NewName: "m"}, &edit)
+		if edits := edit.Changes[testURI]; len(edits) != 2 {
+			t.Errorf("expected 2 edits, got %+v", edits)
+		}
+	})
+
+	t.Run("documentSymbol", func(t *testing.T) {
+		var symbols []documentSymbol
+		c.result("textDocument/documentSymbol", documentSymbolParams{TextDocument: textDocumentIdentifier{URI: testURI}}, &symbols)
+		if len(symbols) != 1 || symbols[0].Name != "twice" || symbols[0].Detail != "(int): int" || symbols[0].Kind != symbolFunction {
+			t.Fatalf("expected the function twice, got %+v", symbols)
+		}
+		if symbols[0].SelectionRange.Start != at(t, 3, `twice`, 0, 0) || symbols[0].Range.End.Line != 5 {
+			t.Errorf("unexpected ranges %+v", symbols[0])
+		}
+	})
+
+	c.result("shutdown", nil, new(interface{}))
+	c.notify("exit", nil)
+	if err := <-done; err != nil {
+		t.Errorf("expected the server to exit cleanly, got %v", err)
+	}
+}
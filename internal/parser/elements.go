@@ -1,47 +1,27 @@
 package parser
 
-import "encoding/xml"
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"xml-programming/internal/ast"
+)
 
-type ProgramElement struct {
-	XMLName    xml.Name          `xml:"program"`
-	Statements []StatementElement `xml:",any"`
-}
-
-type StatementElement struct {
-	XMLName xml.Name
-
-	OutputStatementElement
-	VariableDeclarationElement
-	VariableAssignmentElement
-	FunctionElement
-	ConditionStatementElement
-	LoopStatementElement
-	ForStatementElement
-
-	Name string `xml:"name,attr"`
-	Type string `xml:"type,attr"`
-
-	Exprs []ExpressionElement `xml:",any"`
-
-	Body StatementBody
-}
+const ProgramElementName = "program"
 
 const OutputStatementElementName = "output"
-type OutputStatementElement struct {
-}
-
 const VariableDeclarationElementName = "declare"
-type VariableDeclarationElement struct {
-}
-
 const VariableAssignmentElementName = "assign"
-type VariableAssignmentElement struct {
-}
 
 const LiteralExpressionStringElementName = "string"
 const LiteralExpressionBoolElementName = "bool"
 const LiteralExpressionIntElementName = "int"
 const LiteralExpressionFloatElementName = "float"
+const LiteralExpressionInt64ElementName = "int64"
+const LiteralExpressionFloat64ElementName = "float64"
+const LiteralExpressionBigIntElementName = "bigint"
 const VariableExpressionElementName = "var"
 const OperatorExpressionAddElementName = "add"
 const OperatorExpressionSubElementName = "sub"
@@ -56,86 +36,363 @@ const OperatorExpressionNotElementName = "not"
 const OperatorExpressionAndElementName = "and"
 const OperatorExpressionOrElementName = "or"
 const FunctionCallExpressionElementName = "call"
-type ExpressionElement struct {
-	XMLName xml.Name
+const InputExpressionElementName = "input"
+const ArrayExpressionElementName = "array"
+const IndexExpressionElementName = "index"
+const LengthExpressionElementName = "len"
+const MapExpressionElementName = "map"
+const MapEntryElementName = "entry"
+const GetExpressionElementName = "get"
+const HasExpressionElementName = "has"
+const KeysExpressionElementName = "keys"
+const NewExpressionElementName = "new"
+const GetFieldExpressionElementName = "get-field"
+const VariantExpressionElementName = "variant"
+const NoneExpressionElementName = "none"
+const SomeExpressionElementName = "some"
+const IsNoneExpressionElementName = "is-none"
+const UnwrapOrExpressionElementName = "unwrap-or"
+const LambdaExpressionElementName = "lambda"
+const CastExpressionElementName = "cast"
 
-	Name string `xml:"name,attr"`
+const FunctionElementName = "func"
+const FunctionArgsElementName = "args"
+const FunctionArgElementName = "arg"
+const FunctionReturnsElementName = "returns"
+const BodyElementName = "body"
 
-	Content string `xml:",chardata"`
-	Exprs []ExpressionElement `xml:",any"`
-}
+const FunctionReturnElementName = "return"
+const FunctionCallStatementElementName = "call"
 
-const FunctionElementName = "func"
-type FunctionElement struct {
-	Args FunctionArgs `xml:"args"`
-}
+const ConditionStatementElementName = "switch"
+const ConditionIfElementName = "if"
+const ConditionElseElementName = "else"
+const ConditionElementName = "cond"
+const ConditionThenElementName = "then"
 
-type FunctionArgs struct {
-	Returns FunctionReturnType `xml:"returns"`
-	Args    []FunctionArg      `xml:"arg"`
-}
+const LoopStatementElementName = "loop"
+const ForStatementElementName = "for"
+const ForFromElementName = "from"
+const ForToElementName = "to"
+const ForStepElementName = "step"
+const ForEachStatementElementName = "foreach"
+const ForEachInElementName = "in"
+const BreakStatementElementName = "break"
+const ContinueStatementElementName = "continue"
 
-type FunctionArg struct {
-	Name string `xml:"name,attr"`
-	Type string `xml:"type,attr"`
-}
+const ThrowStatementElementName = "throw"
+const TryStatementElementName = "try"
+const TryCatchElementName = "catch"
+const TryFinallyElementName = "finally"
+
+const AppendStatementElementName = "append"
+const SetIndexStatementElementName = "set-index"
+const PutStatementElementName = "put"
+const DeleteStatementElementName = "delete"
+const SetFieldStatementElementName = "set-field"
+
+const RecordElementName = "record"
+const RecordFieldElementName = "field"
+const EnumElementName = "enum"
+const EnumVariantElementName = "variant"
 
-type FunctionReturnType struct {
-	Type string `xml:"type,attr"`
+const MatchStatementElementName = "match"
+const MatchValueElementName = "value"
+const MatchCaseElementName = "case"
+const MatchDefaultElementName = "default"
+
+// elementAttrs has the attributes each element can have. <field> and <variant> have the attributes of all the places
+// they are used in, which the parser narrows down.
+var elementAttrs = map[string][]string{
+	VariableDeclarationElementName:    {"name", "type"},
+	VariableAssignmentElementName:     {"name"},
+	VariableExpressionElementName:     {"name"},
+	FunctionCallExpressionElementName: {"name"},
+	ArrayExpressionElementName:        {"type"},
+	MapExpressionElementName:          {"key", "value"},
+	NewExpressionElementName:          {"type"},
+	GetFieldExpressionElementName:     {"name"},
+	VariantExpressionElementName:      {"type", "name"},
+	CastExpressionElementName:         {"type"},
+	FunctionElementName:               {"name"},
+	FunctionArgElementName:            {"name", "type"},
+	FunctionReturnsElementName:        {"type"},
+	LoopStatementElementName:          {"label"},
+	ForStatementElementName:           {"label", "name", ForFromElementName, ForToElementName, ForStepElementName, "inclusive"},
+	ForEachStatementElementName:       {"label", "name", "value", ForEachInElementName},
+	BreakStatementElementName:         {"label"},
+	ContinueStatementElementName:      {"label"},
+	TryCatchElementName:               {"name", "type"},
+	SetFieldStatementElementName:      {"name"},
+	RecordElementName:                 {"name"},
+	RecordFieldElementName:            {"name", "type"},
+	EnumElementName:                   {"name"},
+	MatchCaseElementName:              {"variant"},
 }
 
-type StatementBody struct {
-	XMLName xml.Name `xml:"body"`
-	Statements []StatementElement `xml:",any"`
+// textElements are the literals, the only elements with text
+var textElements = map[string]bool{
+	LiteralExpressionStringElementName:  true,
+	LiteralExpressionBoolElementName:    true,
+	LiteralExpressionIntElementName:     true,
+	LiteralExpressionFloatElementName:   true,
+	LiteralExpressionInt64ElementName:   true,
+	LiteralExpressionFloat64ElementName: true,
+	LiteralExpressionBigIntElementName:  true,
 }
 
-const FunctionReturnElementName = "return"
-type FunctionReturnElement struct {
+// leafElements can't have children. <field> of records is a leaf too, but not in <new>.
+var leafElements = map[string]bool{
+	VariableDeclarationElementName: true,
+	VariableExpressionElementName:  true,
+	InputExpressionElementName:     true,
+	NoneExpressionElementName:      true,
+	BreakStatementElementName:      true,
+	ContinueStatementElementName:   true,
+	FunctionArgElementName:         true,
+	FunctionReturnsElementName:     true,
+	EnumVariantElementName:         true,
 }
 
-const FunctionCallStatementElementName = "call"
-type FunctionCallStatementElement struct {
+type Element struct {
+	Name     string
+	Attrs    []xml.Attr
+	Text     string
+	Children []*Element
+	// comments directly inside the element, or around it for the root element
+	Comments []ast.Comment
+	// the XML declaration of the document, for the root element
+	Declaration string
+
+	Span ast.Span
 }
 
-const ConditionStatementElementName = "switch"
-type ConditionStatementElement struct {
-	Ifs []ConditionStatementIfClauseElement `xml:"if"`
-	Else *ConditionStatementIfClauseElement `xml:"else"`
+func (e *Element) Attr(name string) (string, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
 }
 
-type ConditionStatementIfClauseElement struct {
-	Condition ConditionStatementConditionElement `xml:"cond"`
-	Then      ConditionStatementThenElement
+func (e *Element) Child(name string) *Element {
+	for _, child := range e.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
 }
 
-type ConditionStatementElseClauseElement struct {
-	Then ConditionStatementThenElement
+func (e *Element) ChildrenNamed(name string) []*Element {
+	var children []*Element
+	for _, child := range e.Children {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
 }
 
-type ConditionStatementConditionElement struct {
-	Expr ExpressionElement `xml:",any"`
+func ReadElements(file string, content []byte) (*Element, error) {
+	root, err := readElements(file, content, nil)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, ast.Errorf(ast.Span{File: file}, "document is empty")
+	}
+	err = checkElement(root)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
 }
 
-type ConditionStatementThenElement struct {
-	XMLName xml.Name `xml:"then"`
-	Statements []StatementElement `xml:",any"`
+// ReadFragment reads any number of elements that aren't wrapped in a root element.
+func ReadFragment(file string, content []byte) ([]*Element, error) {
+	fragment := &Element{}
+	_, err := readElements(file, content, fragment)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range fragment.Children {
+		err = checkElement(element)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fragment.Children, nil
 }
 
-const LoopStatementElementName = "loop"
-type LoopStatementElement struct {
-	Condition LoopConditionElement `xml:"cond"`
+// checkElement reports attributes, text and children that no element has, as the parser would ignore them.
+func checkElement(element *Element) error {
+	allowed := elementAttrs[element.Name]
+outer:
+	for _, attr := range element.Attrs {
+		if attr.Name.Space == "" {
+			for _, name := range allowed {
+				if attr.Name.Local == name {
+					continue outer
+				}
+			}
+		}
+		return ast.Errorf(element.Span, "unexpected attribute %v on <%v>", attrName(attr.Name), element.Name)
+	}
+
+	if !textElements[element.Name] && strings.TrimSpace(element.Text) != "" {
+		return ast.Errorf(element.Span, "unexpected text %q in <%v>", strings.TrimSpace(element.Text), element.Name)
+	}
+	if (textElements[element.Name] || leafElements[element.Name]) && len(element.Children) > 0 {
+		return ast.Errorf(element.Children[0].Span, "unexpected <%v> in <%v>", element.Children[0].Name, element.Name)
+	}
+
+	for _, child := range element.Children {
+		err := checkElement(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-type LoopConditionElement struct {
-	Expr ExpressionElement `xml:",any"`
+func attrName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
 }
 
-type LoopBodyElement struct {
-	Statements []StatementElement `xml:",any"`
+// Incomplete reports whether content stops in the middle of an element, so that more input is needed to read it.
+func Incomplete(content []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return false
+		}
+		var syntaxError *xml.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return syntaxError.Msg == "unexpected EOF"
+		}
+		if err != nil {
+			return false
+		}
+	}
 }
 
-const ForStatementElementName = "for"
-type ForStatementElement struct {
-	To int `xml:"to,attr"`
-	From int `xml:"from,attr"`
-}
\ No newline at end of file
+// readElements returns the root element, or puts all top level elements into fragment if it isn't nil
+func readElements(file string, content []byte, fragment *Element) (*Element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+
+	var root *Element
+	var stack []*Element
+	// comments and the XML declaration, which come before the root element
+	var comments []ast.Comment
+	var declaration string
+	if fragment != nil {
+		stack = append(stack, fragment)
+	}
+
+	for {
+		line, column := decoder.InputPos()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syntaxError *xml.SyntaxError
+			if errors.As(err, &syntaxError) {
+				// the error only has a line; the column is where the token with the error starts if it is on that line,
+				// or where the decoder stopped
+				errorColumn := column
+				if line != syntaxError.Line {
+					var errorLine int
+					errorLine, errorColumn = decoder.InputPos()
+					if errorLine != syntaxError.Line {
+						errorColumn = 1
+					}
+				}
+				return nil, ast.Errorf(ast.Span{
+					File:  file,
+					Start: ast.Position{Line: syntaxError.Line, Column: errorColumn},
+				}, "%s", syntaxError.Msg)
+			}
+			return nil, ast.Errorf(ast.Span{
+				File:  file,
+				Start: ast.Position{Line: line, Column: column},
+			}, "%v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &Element{
+				Name:  t.Name.Local,
+				Attrs: t.Attr,
+				Span: ast.Span{
+					File:  file,
+					Start: ast.Position{Line: line, Column: column},
+				},
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, element)
+			} else if root != nil {
+				return nil, ast.Errorf(element.Span, "unexpected second root element <%v>", element.Name)
+			} else {
+				root = element
+				root.Comments = comments
+				root.Declaration = declaration
+			}
+			stack = append(stack, element)
+		case xml.EndElement:
+			element := stack[len(stack)-1]
+			line, column = decoder.InputPos()
+			element.Span.End = ast.Position{Line: line, Column: column}
+			stack = stack[:len(stack)-1]
+		case xml.Comment:
+			endLine, endColumn := decoder.InputPos()
+			comment := ast.Comment{
+				Node: ast.Node{Span: ast.Span{
+					File:  file,
+					Start: ast.Position{Line: line, Column: column},
+					End:   ast.Position{Line: endLine, Column: endColumn},
+				}},
+				Text: string(t),
+			}
+			switch {
+			case len(stack) > 0:
+				parent := stack[len(stack)-1]
+				parent.Comments = append(parent.Comments, comment)
+			case root != nil:
+				root.Comments = append(root.Comments, comment)
+			default:
+				comments = append(comments, comment)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				declaration = strings.TrimSpace(string(t.Inst))
+			}
+		case xml.CharData:
+			if fragment != nil && len(stack) == 1 {
+				if strings.TrimSpace(string(t)) != "" {
+					return nil, ast.Errorf(ast.Span{
+						File:  file,
+						Start: ast.Position{Line: line, Column: column},
+					}, "unexpected text outside of an element")
+				}
+			} else if len(stack) > 0 {
+				element := stack[len(stack)-1]
+				element.Text += string(t)
+			} else if strings.TrimSpace(string(t)) != "" {
+				return nil, ast.Errorf(ast.Span{
+					File:  file,
+					Start: ast.Position{Line: line, Column: column},
+				}, "unexpected text outside of root element")
+			}
+		}
+	}
+
+	return root, nil
+}
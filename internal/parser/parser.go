@@ -1,26 +1,103 @@
 package parser
 
 import (
-	"encoding/xml"
 	"errors"
-	"fmt"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"xml-programming/internal/ast"
 )
 
-func Parse(content []byte) (*ast.Program, error) {
-	var programElement ProgramElement
-	err := xml.Unmarshal(content, &programElement)
+// ErrUnknownStatement is returned by ParseStatement for elements that aren't statements, which might still be expressions.
+var ErrUnknownStatement = errors.New("unknown statement")
+
+// Parser keeps track of the types declared by the program, so that they can be referenced by name.
+type Parser struct {
+	Types map[string]ast.Type
+}
+
+func NewParser() *Parser {
+	return &Parser{
+		Types: map[string]ast.Type{},
+	}
+}
+
+func Parse(file string, content []byte) (*ast.Program, error) {
+	return NewParser().Parse(file, content)
+}
+
+func (p *Parser) Parse(file string, content []byte) (*ast.Program, error) {
+	root, err := ReadElements(file, content)
 	if err != nil {
 		return nil, err
 	}
 
-	//json, _ := json.MarshalIndent(programElement, "", "  ")
-	//fmt.Println(string(json))
+	if root.Name != ProgramElementName {
+		return nil, ast.Errorf(root.Span, "root element has to be <%v>, not <%v>", ProgramElementName, root.Name)
+	}
+
+	program, err := p.parseProgram(root.Children)
+	if err != nil {
+		return nil, err
+	}
+	program.Span = root.Span
+	program.Declaration = root.Declaration
+	program.Comments = collectComments(root, nil)
+	sort.SliceStable(program.Comments, func(i, j int) bool {
+		return program.Comments[i].Span.Start.Before(program.Comments[j].Span.Start)
+	})
+	return program, nil
+}
+
+func collectComments(element *Element, comments []ast.Comment) []ast.Comment {
+	comments = append(comments, element.Comments...)
+	for _, child := range element.Children {
+		comments = collectComments(child, comments)
+	}
+	return comments
+}
+
+// ParseFragment parses what would be the contents of <program>, like the input of a REPL.
+// The types it declares stay declared, so later fragments can use them.
+func (p *Parser) ParseFragment(file string, content []byte) (*ast.Program, error) {
+	elements, err := ReadFragment(file, content)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseProgram(elements)
+}
 
+func (p *Parser) parseProgram(elements []*Element) (*ast.Program, error) {
 	var program ast.Program
-	program.Statements, err = ParseStatements(programElement.Statements)
+	var statementElements []*Element
+
+	// types can be used before they are declared
+	for _, element := range elements {
+		if element.Name == RecordElementName || element.Name == EnumElementName {
+			err := p.declareType(element)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, element := range elements {
+		switch element.Name {
+		case RecordElementName:
+			record, err := p.parseRecord(element)
+			if err != nil {
+				return nil, err
+			}
+			program.Records = append(program.Records, record)
+		case EnumElementName:
+			program.Enums = append(program.Enums, p.parseEnum(element))
+		default:
+			statementElements = append(statementElements, element)
+		}
+	}
+
+	var err error
+	program.Statements, err = p.ParseStatements(statementElements)
 	if err != nil {
 		return nil, err
 	}
@@ -28,10 +105,214 @@ func Parse(content []byte) (*ast.Program, error) {
 	return &program, nil
 }
 
-func ParseStatements(statementsElements []StatementElement) ([]ast.Statement, error) {
+func (p *Parser) declareType(element *Element) error {
+	name, _ := element.Attr("name")
+	if !isTypeName(name) {
+		return ast.Errorf(element.Span, "invalid type name: %q", name)
+	}
+	if _, ok := p.Types[name]; ok {
+		return ast.Errorf(element.Span, "type %v is already declared", name)
+	}
+
+	if element.Name == RecordElementName {
+		p.Types[name] = ast.RecordOf(name)
+		return nil
+	}
+
+	err := expectOnlyChildren(element, EnumVariantElementName)
+	if err != nil {
+		return err
+	}
+	if len(element.Children) == 0 {
+		return ast.Errorf(element.Span, "enum %v needs at least one variant", name)
+	}
+	var variants []string
+	for _, variantElement := range element.Children {
+		err := expectOnlyAttrs(variantElement, "name")
+		if err != nil {
+			return err
+		}
+		variant, _ := variantElement.Attr("name")
+		if !isIdentifier(variant) {
+			return ast.Errorf(variantElement.Span, "invalid variant name: %q", variant)
+		}
+		variants = append(variants, variant)
+	}
+	p.Types[name] = ast.EnumOf(name, variants)
+	return nil
+}
+
+// the variants were already checked by declareType
+func (p *Parser) parseEnum(element *Element) ast.EnumDeclaration {
+	name, _ := element.Attr("name")
+	enum := ast.EnumDeclaration{
+		Node: ast.Node{Span: element.Span},
+		Name: name,
+		Type: p.Types[name],
+	}
+	for _, variantElement := range element.Children {
+		variant, _ := variantElement.Attr("name")
+		enum.Variants = append(enum.Variants, ast.EnumVariant{
+			Node: ast.Node{Span: variantElement.Span},
+			Name: variant,
+		})
+	}
+	return enum
+}
+
+func (p *Parser) parseVariant(element *Element) (ast.Expression, error) {
+	_type, err := p.parseTypeAttr(element)
+	if err != nil {
+		return nil, err
+	}
+	if _type.Kind() != ast.EnumKind {
+		return nil, ast.Errorf(element.Span, "%v is not an enum", _type)
+	}
+
+	name, _ := element.Attr("name")
+	for i, variant := range _type.Variants() {
+		if variant == name {
+			return ast.VariantLiteral{
+				Node:  ast.Node{Span: element.Span},
+				Type:  _type,
+				Name:  name,
+				Index: i,
+			}, nil
+		}
+	}
+	return nil, ast.Errorf(element.Span, "enum %v has no variant %s", _type, name)
+}
+
+func (p *Parser) parseMatch(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, MatchValueElementName, MatchCaseElementName, MatchDefaultElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	valueElement, err := expectChild(element, MatchValueElementName)
+	if err != nil {
+		return nil, err
+	}
+	match := ast.MatchStatement{
+		Node: ast.Node{Span: element.Span},
+	}
+	match.Expr, err = p.expectSingleExpression(valueElement)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, caseElement := range element.ChildrenNamed(MatchCaseElementName) {
+		err := expectOnlyChildren(caseElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		thenElement, err := expectChild(caseElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.ParseStatements(thenElement.Children)
+		if err != nil {
+			return nil, err
+		}
+		variant, _ := caseElement.Attr("variant")
+		match.Cases = append(match.Cases, ast.MatchCase{
+			Node:    ast.Node{Span: caseElement.Span},
+			Variant: variant,
+			Body:    body,
+		})
+	}
+
+	defaultElement := element.Child(MatchDefaultElementName)
+	if defaultElement != nil {
+		err := expectOnlyChildren(defaultElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		thenElement, err := expectChild(defaultElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		match.HasDefault = true
+		match.Default, err = p.ParseStatements(thenElement.Children)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return match, nil
+}
+
+func (p *Parser) parseRecord(element *Element) (ast.RecordDeclaration, error) {
+	err := expectOnlyChildren(element, RecordFieldElementName)
+	if err != nil {
+		return ast.RecordDeclaration{}, err
+	}
+
+	name, _ := element.Attr("name")
+	record := ast.RecordDeclaration{
+		Node: ast.Node{Span: element.Span},
+		Name: name,
+		Type: p.Types[name],
+	}
+	for _, fieldElement := range element.Children {
+		err := expectOnlyChildren(fieldElement)
+		if err != nil {
+			return ast.RecordDeclaration{}, err
+		}
+		fieldName, _ := fieldElement.Attr("name")
+		field := ast.RecordField{
+			Node: ast.Node{Span: fieldElement.Span},
+			Name: fieldName,
+		}
+		field.Type, err = p.parseTypeAttr(fieldElement)
+		if err != nil {
+			return ast.RecordDeclaration{}, err
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, nil
+}
+
+func (p *Parser) parseNew(element *Element) (ast.Expression, error) {
+	err := expectOnlyChildren(element, RecordFieldElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	expression := ast.NewExpression{
+		Node: ast.Node{Span: element.Span},
+	}
+	expression.Type, err = p.parseTypeAttr(element)
+	if err != nil {
+		return nil, err
+	}
+	if expression.Type.Kind() != ast.RecordKind {
+		return nil, ast.Errorf(element.Span, "<%v> can only create records, not %v", element.Name, expression.Type)
+	}
+
+	for _, fieldElement := range element.Children {
+		err := expectOnlyAttrs(fieldElement, "name")
+		if err != nil {
+			return nil, err
+		}
+		expr, err := p.expectSingleExpression(fieldElement)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := fieldElement.Attr("name")
+		expression.Fields = append(expression.Fields, ast.FieldInit{
+			Node: ast.Node{Span: fieldElement.Span},
+			Name: name,
+			Expr: expr,
+		})
+	}
+	return expression, nil
+}
+
+func (p *Parser) ParseStatements(statementsElements []*Element) ([]ast.Statement, error) {
 	var statements []ast.Statement
 	for _, element := range statementsElements {
-		statement, err := ParseStatement(element)
+		statement, err := p.ParseStatement(element)
 		if err != nil {
 			return nil, err
 		}
@@ -41,261 +322,922 @@ func ParseStatements(statementsElements []StatementElement) ([]ast.Statement, er
 	return statements, nil
 }
 
-func ParseType(str string) (ast.Type, error) {
-	switch str {
-	case "string":
-		return ast.String, nil
-	case "bool":
-		return ast.Bool, nil
-	case "int":
-		return ast.Int, nil
-	case "float":
-		return ast.Float, nil
-	default:
-		return ast.Void, fmt.Errorf("unknown type: %v", str)
+func (p *Parser) parseTypeAttr(element *Element) (ast.Type, error) {
+	str, _ := element.Attr("type")
+	t, err := p.ParseType(str)
+	if err != nil {
+		return ast.Void, &ast.Error{Span: element.Span, Err: err}
 	}
+	return t, nil
 }
 
-func ParseStatement(statement StatementElement) (ast.Statement, error) {
-	switch statement.XMLName.Local {
-	case OutputStatementElementName:
-		var exprs []ast.Expression
-		for _, exprElement := range statement.Exprs {
-			expr, err := ParseExpression(exprElement)
+// parseOptionalTypeAttr returns Void if the attribute is missing
+func (p *Parser) parseOptionalTypeAttr(element *Element, name string) (ast.Type, error) {
+	str, ok := element.Attr(name)
+	if !ok {
+		return ast.Void, nil
+	}
+	t, err := p.ParseType(str)
+	if err != nil {
+		return ast.Void, &ast.Error{Span: element.Span, Err: err}
+	}
+	return t, nil
+}
+
+func expectChild(element *Element, name string) (*Element, error) {
+	child := element.Child(name)
+	if child == nil {
+		return nil, ast.Errorf(element.Span, "<%v> is missing <%v>", element.Name, name)
+	}
+	return child, nil
+}
+
+func expectOnlyChildren(element *Element, names ...string) error {
+outer:
+	for _, child := range element.Children {
+		for _, name := range names {
+			if child.Name == name {
+				continue outer
+			}
+		}
+		return ast.Errorf(child.Span, "unexpected <%v> in <%v>", child.Name, element.Name)
+	}
+	return nil
+}
+
+// expectOnlyAttrs narrows down the attributes checked by checkElement, for elements used in more than one place
+func expectOnlyAttrs(element *Element, names ...string) error {
+outer:
+	for _, attr := range element.Attrs {
+		for _, name := range names {
+			if attr.Name.Local == name {
+				continue outer
+			}
+		}
+		return ast.Errorf(element.Span, "unexpected attribute %v on <%v>", attr.Name.Local, element.Name)
+	}
+	return nil
+}
+
+func (p *Parser) expectSingleExpression(element *Element) (ast.Expression, error) {
+	if len(element.Children) != 1 {
+		return nil, ast.Errorf(element.Span, "<%v> must have exactly one expression", element.Name)
+	}
+	return p.ParseExpression(element.Children[0])
+}
+
+func (p *Parser) parseBody(element *Element) ([]ast.Statement, error) {
+	body, err := expectChild(element, BodyElementName)
+	if err != nil {
+		return nil, err
+	}
+	return p.ParseStatements(body.Children)
+}
+
+func (p *Parser) parseSignature(element *Element) ([]ast.FunctionArg, ast.Type, error) {
+	var args []ast.FunctionArg
+	returns := ast.Void
+
+	argsElement := element.Child(FunctionArgsElementName)
+	if argsElement != nil {
+		err := expectOnlyChildren(argsElement, FunctionArgElementName, FunctionReturnsElementName)
+		if err != nil {
+			return nil, ast.Void, err
+		}
+		for _, argElement := range argsElement.ChildrenNamed(FunctionArgElementName) {
+			name, _ := argElement.Attr("name")
+			arg := ast.FunctionArg{
+				Node: ast.Node{Span: argElement.Span},
+				Name: name,
+			}
+			arg.Type, err = p.parseTypeAttr(argElement)
+			if err != nil {
+				return nil, ast.Void, err
+			}
+			args = append(args, arg)
+		}
+		returnsElement := argsElement.Child(FunctionReturnsElementName)
+		if returnsElement != nil {
+			returns, err = p.parseTypeAttr(returnsElement)
+			if err != nil {
+				return nil, ast.Void, err
+			}
+		}
+	}
+	return args, returns, nil
+}
+
+func (p *Parser) parseFunction(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, FunctionArgsElementName, BodyElementName)
+	if err != nil {
+		return nil, err
+	}
+	args, returns, err := p.parseSignature(element)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody(element)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := element.Attr("name")
+	return ast.FunctionStatement{
+		Node:    ast.Node{Span: element.Span},
+		Name:    name,
+		Returns: returns,
+		Args:    args,
+		Body:    body,
+	}, nil
+}
+
+func (p *Parser) parseLambda(element *Element) (ast.Expression, error) {
+	err := expectOnlyChildren(element, FunctionArgsElementName, BodyElementName)
+	if err != nil {
+		return nil, err
+	}
+	args, returns, err := p.parseSignature(element)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody(element)
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.LambdaExpression{
+		Node:    ast.Node{Span: element.Span},
+		Returns: returns,
+		Args:    args,
+		Body:    body,
+	}, nil
+}
+
+// parseCall parses calls by name, or without a name attribute calls of the function the first child evaluates to.
+func (p *Parser) parseCall(element *Element) (ast.FunctionCall, error) {
+	call := ast.FunctionCall{
+		Node: ast.Node{Span: element.Span},
+	}
+	children := element.Children
+	name, ok := element.Attr("name")
+	if ok {
+		call.Name = name
+	} else {
+		if len(children) == 0 {
+			return call, ast.Errorf(element.Span, "<%v> needs a name or an expression to call", element.Name)
+		}
+		callee, err := p.ParseExpression(children[0])
+		if err != nil {
+			return call, err
+		}
+		call.Callee = callee
+		children = children[1:]
+	}
+
+	args, err := p.ParseExpressionList(children)
+	if err != nil {
+		return call, err
+	}
+	call.Args = args
+	return call, nil
+}
+
+func (p *Parser) parseExpressionCount(element *Element, count int) ([]ast.Expression, error) {
+	if len(element.Children) != count {
+		return nil, ast.Errorf(element.Span, "<%v> must have exactly %d expressions", element.Name, count)
+	}
+	return p.ParseExpressionList(element.Children)
+}
+
+func (p *Parser) parseForEach(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, ForEachInElementName, BodyElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	var in ast.Expression
+	inElement := element.Child(ForEachInElementName)
+	if inElement != nil {
+		in, err = p.expectSingleExpression(inElement)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		variable, ok := element.Attr("in")
+		if !ok {
+			return nil, ast.Errorf(element.Span, "<%v> needs either an in attribute or an <%v> element", element.Name, ForEachInElementName)
+		}
+		in = ast.VariableExpression{
+			Node: ast.Node{Span: element.Span},
+			Name: variable,
+		}
+	}
+
+	body, err := p.parseBody(element)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := element.Attr("name")
+	valueName, _ := element.Attr("value")
+	label, _ := element.Attr("label")
+	return ast.ForEachStatement{
+		Node:      ast.Node{Span: element.Span},
+		Label:     label,
+		Name:      name,
+		ValueName: valueName,
+		In:        in,
+		Body:      body,
+	}, nil
+}
+
+func (p *Parser) parseFor(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, ForFromElementName, ForToElementName, ForStepElementName, BodyElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := p.parseBound(element, ForFromElementName)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil {
+		from = ast.LiteralExpression{
+			Node: ast.Node{Span: element.Span},
+			Type: ast.Int,
+		}
+	}
+	to, err := p.parseBound(element, ForToElementName)
+	if err != nil {
+		return nil, err
+	}
+	if to == nil {
+		return nil, ast.Errorf(element.Span, "<%v> needs either a %v attribute or a <%v> element", element.Name, ForToElementName, ForToElementName)
+	}
+	step, err := p.parseBound(element, ForStepElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	inclusive := false
+	if str, ok := element.Attr("inclusive"); ok {
+		inclusive, err = strconv.ParseBool(strings.TrimSpace(str))
+		if err != nil {
+			return nil, ast.Errorf(element.Span, "unable to parse attribute inclusive: %w", err)
+		}
+	}
+
+	body, err := p.parseBody(element)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := element.Attr("name")
+	label, _ := element.Attr("label")
+	return ast.ForStatement{
+		Node:      ast.Node{Span: element.Span},
+		Label:     label,
+		Name:      name,
+		From:      from,
+		To:        to,
+		Step:      step,
+		Inclusive: inclusive,
+		Body:      body,
+	}, nil
+}
+
+// parseBound parses a bound of a <for> loop: either a child element, or a number attribute as a shorthand.
+// It returns nil if there is neither.
+func (p *Parser) parseBound(element *Element, name string) (ast.Expression, error) {
+	child := element.Child(name)
+	str, isAttr := element.Attr(name)
+	if child != nil && isAttr {
+		return nil, ast.Errorf(element.Span, "<%v> has both a %v attribute and a <%v> element", element.Name, name, name)
+	}
+	if child != nil {
+		return p.expectSingleExpression(child)
+	}
+	if !isAttr {
+		return nil, nil
+	}
+
+	node := ast.Node{Span: element.Span}
+	str = strings.TrimSpace(str)
+	if val, err := strconv.Atoi(str); err == nil {
+		return ast.LiteralExpression{
+			Node: node,
+			Type: ast.Int,
+			Int:  val,
+		}, nil
+	}
+	val, err := strconv.ParseFloat(str, 32)
+	if err != nil {
+		return nil, ast.Errorf(element.Span, "unable to parse attribute %v: %w", name, err)
+	}
+	return ast.LiteralExpression{
+		Node:  node,
+		Type:  ast.Float,
+		Float: float32(val),
+	}, nil
+}
+
+func (p *Parser) parseTry(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, BodyElementName, TryCatchElementName, TryFinallyElementName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody(element)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := ast.TryStatement{
+		Node: ast.Node{Span: element.Span},
+		Body: body,
+	}
+	for _, child := range element.Children {
+		switch child.Name {
+		case TryCatchElementName:
+			err := expectOnlyChildren(child, BodyElementName)
+			if err != nil {
+				return nil, err
+			}
+			_type, err := p.parseTypeAttr(child)
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.parseBody(child)
+			if err != nil {
+				return nil, err
+			}
+			name, _ := child.Attr("name")
+			statement.Catches = append(statement.Catches, ast.CatchClause{
+				Node: ast.Node{Span: child.Span},
+				Name: name,
+				Type: _type,
+				Body: body,
+			})
+		case TryFinallyElementName:
+			if statement.HasFinally {
+				return nil, ast.Errorf(child.Span, "<%v> can only have one <%v>", element.Name, child.Name)
+			}
+			err := expectOnlyChildren(child, BodyElementName)
+			if err != nil {
+				return nil, err
+			}
+			statement.HasFinally = true
+			statement.Finally, err = p.parseBody(child)
 			if err != nil {
 				return nil, err
 			}
-			exprs = append(exprs, expr)
+		}
+	}
+
+	if len(statement.Catches) == 0 && !statement.HasFinally {
+		return nil, ast.Errorf(element.Span, "<%v> needs at least one <%v> or a <%v>", element.Name, TryCatchElementName, TryFinallyElementName)
+	}
+	return statement, nil
+}
+
+func (p *Parser) parseMap(element *Element) (ast.Expression, error) {
+	err := expectOnlyChildren(element, MapEntryElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	literal := ast.MapLiteral{
+		Node: ast.Node{Span: element.Span},
+	}
+	literal.KeyType, err = p.parseOptionalTypeAttr(element, "key")
+	if err != nil {
+		return nil, err
+	}
+	if literal.KeyType != ast.Void && !literal.KeyType.IsComparable() {
+		return nil, ast.Errorf(element.Span, "map keys have to be strings, bools, fixed width numbers or enums, not %v", literal.KeyType)
+	}
+	literal.ValueType, err = p.parseOptionalTypeAttr(element, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entryElement := range element.Children {
+		exprs, err := p.parseExpressionCount(entryElement, 2)
+		if err != nil {
+			return nil, err
+		}
+		literal.Entries = append(literal.Entries, ast.MapEntry{
+			Node:  ast.Node{Span: entryElement.Span},
+			Key:   exprs[0],
+			Value: exprs[1],
+		})
+	}
+
+	return literal, nil
+}
+
+func (p *Parser) parseConditional(element *Element) (ast.Statement, error) {
+	err := expectOnlyChildren(element, ConditionIfElementName, ConditionElseElementName)
+	if err != nil {
+		return nil, err
+	}
+
+	conditional := ast.ConditionalStatement{
+		Node: ast.Node{Span: element.Span},
+	}
+
+	for _, ifElement := range element.ChildrenNamed(ConditionIfElementName) {
+		err := expectOnlyChildren(ifElement, ConditionElementName, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		condElement, err := expectChild(ifElement, ConditionElementName)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := p.expectSingleExpression(condElement)
+		if err != nil {
+			return nil, err
+		}
+		thenElement, err := expectChild(ifElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		then, err := p.ParseStatements(thenElement.Children)
+		if err != nil {
+			return nil, err
+		}
+		conditional.Ifs = append(conditional.Ifs, ast.ConditionIf{
+			Node: ast.Node{Span: ifElement.Span},
+			Expr: expr,
+			Then: then,
+		})
+	}
+
+	elseElement := element.Child(ConditionElseElementName)
+	if elseElement != nil {
+		err := expectOnlyChildren(elseElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		thenElement, err := expectChild(elseElement, ConditionThenElementName)
+		if err != nil {
+			return nil, err
+		}
+		then, err := p.ParseStatements(thenElement.Children)
+		if err != nil {
+			return nil, err
+		}
+		conditional.Else = then
+	}
+
+	return conditional, nil
+}
+
+func (p *Parser) ParseStatement(element *Element) (ast.Statement, error) {
+	node := ast.Node{Span: element.Span}
+	name, _ := element.Attr("name")
+
+	switch element.Name {
+	case OutputStatementElementName:
+		exprs, err := p.ParseExpressionList(element.Children)
+		if err != nil {
+			return nil, err
 		}
 		return ast.OutputStatement{
+			Node:  node,
 			Exprs: exprs,
 		}, nil
 	case VariableDeclarationElementName:
-		t, err := ParseType(statement.Type)
+		t, err := p.parseTypeAttr(element)
 		if err != nil {
 			return nil, err
 		}
 		return ast.VariableDeclarationStatement{
-			Name: statement.Name,
+			Node: node,
+			Name: name,
 			Type: t,
 		}, nil
 	case VariableAssignmentElementName:
-		if len(statement.Exprs) != 1 {
-			return nil, errors.New("variable assignments must have exactly one expression")
-		}
-		expr, err := ParseExpression(statement.Exprs[0])
+		expr, err := p.expectSingleExpression(element)
 		if err != nil {
 			return nil, err
 		}
 		return ast.VariableAssignmentStatement{
-			Name: statement.Name,
+			Node: node,
+			Name: name,
 			Expr: expr,
 		}, nil
 	case FunctionElementName:
-		var err error
-		var args []ast.FunctionArg
-		for _, argElement := range statement.Args.Args {
-			arg := ast.FunctionArg{
-				Name: argElement.Name,
-			}
-			arg.Type, err = ParseType(argElement.Type)
-			if err != nil {
-				return nil, fmt.Errorf("couldn't parse function argument list: %w", err)
-			}
-			args = append(args, arg)
-		}
-		returns, err := ParseType(statement.Args.Returns.Type)
-		if  err != nil {
-			return nil, fmt.Errorf("couldn't parse function return type: %w", err)
-		}
-		var body []ast.Statement
-		for _, statementElement := range statement.Body.Statements {
-			bodyStatement, err := ParseStatement(statementElement)
-			if err != nil {
-				return nil, err
-			}
-			body = append(body, bodyStatement)
-		}
-		return ast.FunctionStatement{
-			Name:    statement.Name,
-			Returns: returns,
-			Args:    args,
-			Body:    body,
-		}, nil
+		return p.parseFunction(element)
 	case FunctionReturnElementName:
-		if len(statement.Exprs) != 1 {
-			return nil, errors.New("a return statement must have exactly one expression")
-		}
-		expr, err := ParseExpression(statement.Exprs[0])
+		expr, err := p.expectSingleExpression(element)
 		if err != nil {
 			return nil, err
 		}
 		return ast.FunctionReturnStatement{
+			Node: node,
 			Expr: expr,
 		}, nil
 	case FunctionCallStatementElementName:
-		exprs, err := ParseExpressionList(statement.Exprs)
+		call, err := p.parseCall(element)
 		if err != nil {
 			return nil, err
 		}
-		return ast.FunctionCall{
-			Name: statement.Name,
-			Args: exprs,
-		}, nil
+		return call, nil
 	case ConditionStatementElementName:
-		conditional := ast.ConditionalStatement{}
-
-		for _, _if := range statement.Ifs {
-			then, err := ParseStatements(_if.Then.Statements)
-			if err != nil {
-				return nil, err
-			}
-			expr, err := ParseExpression(_if.Condition.Expr)
-			if err != nil {
-				return nil, err
-			}
-			conditional.Ifs = append(conditional.Ifs, ast.ConditionIf{
-				Expr: expr,
-				Then: then,
-			})
+		return p.parseConditional(element)
+	case LoopStatementElementName:
+		err := expectOnlyChildren(element, ConditionElementName, BodyElementName)
+		if err != nil {
+			return nil, err
 		}
-
-		if statement.Else != nil {
-			then, err := ParseStatements(statement.Else.Then.Statements)
-			if err != nil {
-				return nil, err
-			}
-			conditional.Else = then
+		condElement, err := expectChild(element, ConditionElementName)
+		if err != nil {
+			return nil, err
 		}
-
-		return conditional, nil
-	case LoopStatementElementName:
-		expr, err := ParseExpression(statement.LoopStatementElement.Condition.Expr)
+		expr, err := p.expectSingleExpression(condElement)
 		if err != nil {
 			return nil, err
 		}
-		body, err := ParseStatements(statement.Body.Statements)
+		body, err := p.parseBody(element)
 		if err != nil {
 			return nil, err
 		}
+		label, _ := element.Attr("label")
 		return ast.LoopStatement{
+			Node:          node,
+			Label:         label,
 			LoopCondition: expr,
 			Body:          body,
 		}, nil
 	case ForStatementElementName:
-		body, err := ParseStatements(statement.Body.Statements)
+		return p.parseFor(element)
+	case ForEachStatementElementName:
+		return p.parseForEach(element)
+	case ThrowStatementElementName:
+		expr, err := p.expectSingleExpression(element)
 		if err != nil {
 			return nil, err
 		}
-		return ast.ForStatement{
-			From: statement.From,
-			To: statement.To,
-			Name: statement.Name,
-			Body:          body,
+		return ast.ThrowStatement{
+			Node: node,
+			Expr: expr,
+		}, nil
+	case TryStatementElementName:
+		return p.parseTry(element)
+	case BreakStatementElementName:
+		err := expectOnlyChildren(element)
+		if err != nil {
+			return nil, err
+		}
+		label, _ := element.Attr("label")
+		return ast.BreakStatement{
+			Node:  node,
+			Label: label,
+		}, nil
+	case ContinueStatementElementName:
+		err := expectOnlyChildren(element)
+		if err != nil {
+			return nil, err
+		}
+		label, _ := element.Attr("label")
+		return ast.ContinueStatement{
+			Node:  node,
+			Label: label,
+		}, nil
+	case AppendStatementElementName:
+		if len(element.Children) < 2 {
+			return nil, ast.Errorf(element.Span, "<%v> needs an array and at least one value", element.Name)
+		}
+		exprs, err := p.ParseExpressionList(element.Children)
+		if err != nil {
+			return nil, err
+		}
+		return ast.AppendStatement{
+			Node:   node,
+			Array:  exprs[0],
+			Values: exprs[1:],
+		}, nil
+	case SetIndexStatementElementName:
+		exprs, err := p.parseExpressionCount(element, 3)
+		if err != nil {
+			return nil, err
+		}
+		return ast.SetIndexStatement{
+			Node:  node,
+			Array: exprs[0],
+			Index: exprs[1],
+			Value: exprs[2],
+		}, nil
+	case RecordElementName, EnumElementName:
+		return nil, ast.Errorf(element.Span, "types can only be declared at the top level")
+	case MatchStatementElementName:
+		return p.parseMatch(element)
+	case SetFieldStatementElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
+		if err != nil {
+			return nil, err
+		}
+		return ast.SetFieldStatement{
+			Node:   node,
+			Record: exprs[0],
+			Name:   name,
+			Value:  exprs[1],
+		}, nil
+	case PutStatementElementName:
+		exprs, err := p.parseExpressionCount(element, 3)
+		if err != nil {
+			return nil, err
+		}
+		return ast.PutStatement{
+			Node:  node,
+			Map:   exprs[0],
+			Key:   exprs[1],
+			Value: exprs[2],
+		}, nil
+	case DeleteStatementElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
+		if err != nil {
+			return nil, err
+		}
+		return ast.DeleteStatement{
+			Node: node,
+			Map:  exprs[0],
+			Key:  exprs[1],
 		}, nil
 	default:
-		return nil, fmt.Errorf("unknown statement: <%v>", statement.XMLName.Local)
+		return nil, ast.Errorf(element.Span, "%w: <%v>", ErrUnknownStatement, element.Name)
 	}
 }
 
-func ParseExpressionList(exprList []ExpressionElement) ([]ast.Expression, error) {
+func (p *Parser) ParseExpressionList(exprList []*Element) ([]ast.Expression, error) {
 	exprs := make([]ast.Expression, len(exprList))
 
 	var err error
 	for i, expr := range exprList {
-		exprs[i], err = ParseExpression(expr)
+		exprs[i], err = p.ParseExpression(expr)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse sub expression: %w", err)
+			return nil, err
 		}
 	}
 
 	return exprs, nil
 }
 
-func ParseOperatorExpression(exprElement ExpressionElement, operator ast.Operator) (ast.Expression, error) {
-	exprs, err := ParseExpressionList(exprElement.Exprs)
+func (p *Parser) ParseOperatorExpression(element *Element, operator ast.Operator) (ast.Expression, error) {
+	exprs, err := p.ParseExpressionList(element.Children)
 	if err != nil {
 		return nil, err
 	}
 
 	return ast.OperatorExpression{
+		Node:     ast.Node{Span: element.Span},
 		Operator: operator,
 		Exprs:    exprs,
 	}, nil
 }
 
-func ParseExpression(exprElement ExpressionElement) (ast.Expression, error) {
-	switch exprElement.XMLName.Local {
+func (p *Parser) ParseExpression(element *Element) (ast.Expression, error) {
+	node := ast.Node{Span: element.Span}
+
+	switch element.Name {
 	case LiteralExpressionStringElementName:
 		return ast.LiteralExpression{
+			Node:   node,
 			Type:   ast.String,
-			String: exprElement.Content,
+			String: element.Text,
 		}, nil
 	case LiteralExpressionBoolElementName:
-		val, err := strconv.ParseBool(strings.TrimSpace(exprElement.Content))
+		val, err := strconv.ParseBool(strings.TrimSpace(element.Text))
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse int value: %w", err)
+			return nil, ast.Errorf(element.Span, "unable to parse bool value: %w", err)
 		}
 		return ast.LiteralExpression{
-			Type:   ast.Bool,
+			Node: node,
+			Type: ast.Bool,
 			Bool: val,
 		}, nil
 	case LiteralExpressionIntElementName:
-		val, err := strconv.Atoi(strings.TrimSpace(exprElement.Content))
+		val, err := strconv.Atoi(strings.TrimSpace(element.Text))
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse int value: %w", err)
+			return nil, ast.Errorf(element.Span, "unable to parse int value: %w", err)
 		}
 		return ast.LiteralExpression{
-			Type:   ast.Int,
-			Int: val,
+			Node: node,
+			Type: ast.Int,
+			Int:  val,
 		}, nil
 	case LiteralExpressionFloatElementName:
-		val, err := strconv.ParseFloat(strings.TrimSpace(exprElement.Content), 32)
+		val, err := strconv.ParseFloat(strings.TrimSpace(element.Text), 32)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse float value: %w", err)
+			return nil, ast.Errorf(element.Span, "unable to parse float value: %w", err)
 		}
 		return ast.LiteralExpression{
-			Type:   ast.Float,
+			Node:  node,
+			Type:  ast.Float,
 			Float: float32(val),
 		}, nil
+	case LiteralExpressionInt64ElementName:
+		val, err := strconv.ParseInt(strings.TrimSpace(element.Text), 10, 64)
+		if err != nil {
+			return nil, ast.Errorf(element.Span, "unable to parse int64 value: %w", err)
+		}
+		return ast.LiteralExpression{
+			Node:  node,
+			Type:  ast.Int64,
+			Int64: val,
+		}, nil
+	case LiteralExpressionFloat64ElementName:
+		val, err := strconv.ParseFloat(strings.TrimSpace(element.Text), 64)
+		if err != nil {
+			return nil, ast.Errorf(element.Span, "unable to parse float64 value: %w", err)
+		}
+		return ast.LiteralExpression{
+			Node:    node,
+			Type:    ast.Float64,
+			Float64: val,
+		}, nil
+	case LiteralExpressionBigIntElementName:
+		val, ok := new(big.Int).SetString(strings.TrimSpace(element.Text), 10)
+		if !ok {
+			return nil, ast.Errorf(element.Span, "unable to parse bigint value: %q is not an integer", strings.TrimSpace(element.Text))
+		}
+		return ast.LiteralExpression{
+			Node:   node,
+			Type:   ast.BigInt,
+			BigInt: val,
+		}, nil
 	case VariableExpressionElementName:
+		name, _ := element.Attr("name")
 		return ast.VariableExpression{
-			Name: exprElement.Name,
+			Node: node,
+			Name: name,
 		}, nil
 	case OperatorExpressionAddElementName:
-		return ParseOperatorExpression(exprElement, ast.Add)
+		return p.ParseOperatorExpression(element, ast.Add)
 	case OperatorExpressionSubElementName:
-		return ParseOperatorExpression(exprElement, ast.Sub)
+		return p.ParseOperatorExpression(element, ast.Sub)
 	case OperatorExpressionMulElementName:
-		return ParseOperatorExpression(exprElement, ast.Mul)
+		return p.ParseOperatorExpression(element, ast.Mul)
 	case OperatorExpressionDivElementName:
-		return ParseOperatorExpression(exprElement, ast.Div)
+		return p.ParseOperatorExpression(element, ast.Div)
 	case OperatorExpressionModElementName:
-		return ParseOperatorExpression(exprElement, ast.Mod)
+		return p.ParseOperatorExpression(element, ast.Mod)
 	case OperatorExpressionConcatElementName:
-		return ParseOperatorExpression(exprElement, ast.Concat)
+		return p.ParseOperatorExpression(element, ast.Concat)
 	case OperatorExpressionEqualElementName:
-		return ParseOperatorExpression(exprElement, ast.Equal)
+		return p.ParseOperatorExpression(element, ast.Equal)
 	case OperatorExpressionGreaterThanElementName:
-		return ParseOperatorExpression(exprElement, ast.GreaterThan)
+		return p.ParseOperatorExpression(element, ast.GreaterThan)
 	case OperatorExpressionLessThanElementName:
-		return ParseOperatorExpression(exprElement, ast.LessThan)
+		return p.ParseOperatorExpression(element, ast.LessThan)
 	case OperatorExpressionNotElementName:
-		return ParseOperatorExpression(exprElement, ast.Not)
+		return p.ParseOperatorExpression(element, ast.Not)
 	case OperatorExpressionAndElementName:
-		return ParseOperatorExpression(exprElement, ast.And)
+		return p.ParseOperatorExpression(element, ast.And)
 	case OperatorExpressionOrElementName:
-		return ParseOperatorExpression(exprElement, ast.Or)
+		return p.ParseOperatorExpression(element, ast.Or)
 	case FunctionCallExpressionElementName:
-		exprs, err := ParseExpressionList(exprElement.Exprs)
+		call, err := p.parseCall(element)
+		if err != nil {
+			return nil, err
+		}
+		return call, nil
+	case LambdaExpressionElementName:
+		return p.parseLambda(element)
+	case CastExpressionElementName:
+		_type, err := p.parseTypeAttr(element)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		return ast.CastExpression{
+			Node: node,
+			Type: _type,
+			Expr: expr,
+		}, nil
+	case InputExpressionElementName:
+		return ast.InputExpression{
+			Node: node,
+		}, nil
+	case ArrayExpressionElementName:
+		elemType, err := p.parseOptionalTypeAttr(element, "type")
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := p.ParseExpressionList(element.Children)
+		if err != nil {
+			return nil, err
+		}
+		return ast.ArrayLiteral{
+			Node:     node,
+			ElemType: elemType,
+			Elems:    exprs,
+		}, nil
+	case IndexExpressionElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
+		if err != nil {
+			return nil, err
+		}
+		return ast.IndexExpression{
+			Node:  node,
+			Expr:  exprs[0],
+			Index: exprs[1],
+		}, nil
+	case VariantExpressionElementName:
+		return p.parseVariant(element)
+	case NoneExpressionElementName:
+		if len(element.Children) > 0 {
+			return nil, ast.Errorf(element.Span, "<%v> can not have children", element.Name)
+		}
+		return ast.NoneLiteral{
+			Node: node,
+		}, nil
+	case SomeExpressionElementName:
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		return ast.SomeExpression{
+			Node: node,
+			Expr: expr,
+		}, nil
+	case IsNoneExpressionElementName:
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		return ast.IsNoneExpression{
+			Node: node,
+			Expr: expr,
+		}, nil
+	case UnwrapOrExpressionElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
 		if err != nil {
 			return nil, err
 		}
-		return ast.FunctionCall{
-			Name: exprElement.Name,
-			Args: exprs,
+		return ast.UnwrapOrExpression{
+			Node:    node,
+			Expr:    exprs[0],
+			Default: exprs[1],
+		}, nil
+	case NewExpressionElementName:
+		return p.parseNew(element)
+	case GetFieldExpressionElementName:
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := element.Attr("name")
+		return ast.GetFieldExpression{
+			Node: node,
+			Expr: expr,
+			Name: name,
+		}, nil
+	case MapExpressionElementName:
+		return p.parseMap(element)
+	case GetExpressionElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
+		if err != nil {
+			return nil, err
+		}
+		return ast.GetExpression{
+			Node: node,
+			Map:  exprs[0],
+			Key:  exprs[1],
+		}, nil
+	case HasExpressionElementName:
+		exprs, err := p.parseExpressionCount(element, 2)
+		if err != nil {
+			return nil, err
+		}
+		return ast.HasExpression{
+			Node: node,
+			Map:  exprs[0],
+			Key:  exprs[1],
+		}, nil
+	case KeysExpressionElementName:
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		return ast.KeysExpression{
+			Node: node,
+			Map:  expr,
+		}, nil
+	case LengthExpressionElementName:
+		expr, err := p.expectSingleExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		return ast.LengthExpression{
+			Node: node,
+			Expr: expr,
 		}, nil
 	default:
-		return nil, errors.New("unknown expression type")
+		return nil, ast.Errorf(element.Span, "unknown expression: <%v>", element.Name)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"xml-programming/internal/ast"
+)
+
+// since < has to be escaped in attributes, array<int> is written as array&lt;int> in the source
+type typeParser struct {
+	input string
+	pos   int
+	types map[string]ast.Type
+}
+
+var builtinTypeNames = map[string]bool{
+	"string":   true,
+	"bool":     true,
+	"int":      true,
+	"float":    true,
+	"int64":    true,
+	"float64":  true,
+	"bigint":   true,
+	"array":    true,
+	"map":      true,
+	"optional": true,
+	"error":    true,
+	"func":     true,
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTypeName reports whether name can be used for a declared type
+func isTypeName(name string) bool {
+	return isIdentifier(name) && !builtinTypeNames[name]
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *typeParser) name() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) {
+		c, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		p.pos += size
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *typeParser) expect(token byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != token {
+		return fmt.Errorf("expected %q at offset %d", token, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *typeParser) parameters(count int) ([]ast.Type, error) {
+	err := p.expect('<')
+	if err != nil {
+		return nil, err
+	}
+	var types []ast.Type
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			err = p.expect(',')
+			if err != nil {
+				return nil, err
+			}
+		}
+		t, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, p.expect('>')
+}
+
+// function parses the rest of func(int, int): int; without a return type the function returns nothing
+func (p *typeParser) function() (ast.Type, error) {
+	err := p.expect('(')
+	if err != nil {
+		return ast.Void, err
+	}
+	var params []ast.Type
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == ')' {
+		p.pos++
+	} else {
+		for {
+			t, err := p.parse()
+			if err != nil {
+				return ast.Void, err
+			}
+			params = append(params, t)
+			p.skipSpace()
+			if p.pos < len(p.input) && p.input[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			err = p.expect(')')
+			if err != nil {
+				return ast.Void, err
+			}
+			break
+		}
+	}
+
+	returns := ast.Void
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == ':' {
+		p.pos++
+		returns, err = p.parse()
+		if err != nil {
+			return ast.Void, err
+		}
+	}
+	return ast.FunctionOf(params, returns), nil
+}
+
+func (p *typeParser) parse() (ast.Type, error) {
+	name := p.name()
+	switch name {
+	case "string":
+		return ast.String, nil
+	case "bool":
+		return ast.Bool, nil
+	case "int":
+		return ast.Int, nil
+	case "float":
+		return ast.Float, nil
+	case "int64":
+		return ast.Int64, nil
+	case "float64":
+		return ast.Float64, nil
+	case "bigint":
+		return ast.BigInt, nil
+	case "error":
+		return ast.ErrorType, nil
+	case "array":
+		params, err := p.parameters(1)
+		if err != nil {
+			return ast.Void, err
+		}
+		return ast.ArrayOf(params[0]), nil
+	case "map":
+		params, err := p.parameters(2)
+		if err != nil {
+			return ast.Void, err
+		}
+		if !params[0].IsComparable() {
+			return ast.Void, fmt.Errorf("map keys have to be strings, bools, fixed width numbers or enums, not %v", params[0])
+		}
+		return ast.MapOf(params[0], params[1]), nil
+	case "optional":
+		params, err := p.parameters(1)
+		if err != nil {
+			return ast.Void, err
+		}
+		return ast.OptionalOf(params[0]), nil
+	case "func":
+		return p.function()
+	case "":
+		return ast.Void, fmt.Errorf("expected type at offset %d", p.pos)
+	default:
+		t, ok := p.types[name]
+		if !ok {
+			return ast.Void, fmt.Errorf("unknown type: %v", name)
+		}
+		return t, nil
+	}
+}
+
+// ParseType only knows the builtin types, see Parser.ParseType for declared ones.
+func ParseType(str string) (ast.Type, error) {
+	return parseType(str, nil)
+}
+
+func (p *Parser) ParseType(str string) (ast.Type, error) {
+	return parseType(str, p.Types)
+}
+
+func parseType(str string, types map[string]ast.Type) (ast.Type, error) {
+	p := typeParser{
+		input: str,
+		types: types,
+	}
+	t, err := p.parse()
+	if err != nil {
+		return ast.Void, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return ast.Void, fmt.Errorf("unexpected %q after type %v", strings.TrimSpace(p.input[p.pos:]), t)
+	}
+	return t, nil
+}
@@ -5,11 +5,60 @@ import (
 	"xml-programming/internal/values"
 )
 
+type NativeFunction func(args []values.Value) (values.Value, error)
+
 type Function struct {
-	Name string
-	Args []ast.FunctionArg
+	Name   string
+	Args   []ast.FunctionArg
 	Return ast.Type
-	Body []ast.Statement
+	Body   []ast.Statement
+	// the scope the function was declared in, which its body can see
+	Scope *Scope
+
+	// the last argument may be repeated any number of times (including zero)
+	Variadic bool
+	Native   NativeFunction
+	// for natives of the standard library, whose errors are reported differently from those of host functions
+	Builtin bool
+	// for natives that accept several types: checks the argument types and returns the result type instead of Args and Return,
+	// which are only the type of the function when it's used as a value, if they are set
+	Signature func(args []ast.Type) (ast.Type, error)
+}
+
+// FunctionName is the name calls of the function are shown with in stack traces.
+func (f *Function) FunctionName() string {
+	return f.Name
+}
+
+// HasType reports whether the function can be used as a value; variadic functions and those with a Signature but no Args can't.
+func (f *Function) HasType() bool {
+	return !f.Variadic && (f.Signature == nil || f.Args != nil)
+}
+
+// Type is the type of values referring to the function, see HasType.
+func (f *Function) Type() ast.Type {
+	params := make([]ast.Type, len(f.Args))
+	for i, arg := range f.Args {
+		params[i] = arg.Type
+	}
+	return ast.FunctionOf(params, f.Return)
+}
+
+func (f *Function) AcceptsArgumentCount(count int) bool {
+	if f.Signature != nil {
+		return true
+	}
+	if f.Variadic {
+		return count >= len(f.Args)-1
+	}
+	return count == len(f.Args)
+}
+
+func (f *Function) ArgType(i int) ast.Type {
+	if i >= len(f.Args) {
+		return f.Args[len(f.Args)-1].Type
+	}
+	return f.Args[i].Type
 }
 
 type Variable struct {
@@ -54,6 +103,15 @@ func (s *Scope) GetFunction(name string) *Function {
 	}
 }
 
+func (s *Scope) GetLocalVariable(name string) *Variable {
+	for i, v := range s.variables {
+		if v.Name == name {
+			return &s.variables[i]
+		}
+	}
+	return nil
+}
+
 func (s *Scope) GetVariable(name string) *Variable {
 	for i, v := range s.variables {
 		if v.Name == name {
@@ -68,6 +126,39 @@ func (s *Scope) GetVariable(name string) *Variable {
 	}
 }
 
+// VariableScope returns the scope the named variable is declared in, or nil.
+func (s *Scope) VariableScope(name string) *Scope {
+	if s.GetLocalVariable(name) != nil {
+		return s
+	}
+	if s.parentScope != nil {
+		return s.parentScope.VariableScope(name)
+	}
+	return nil
+}
+
+// FunctionScope returns the scope the named function is declared in, or nil.
+func (s *Scope) FunctionScope(name string) *Scope {
+	for _, f := range s.functions {
+		if f.Name == name {
+			return s
+		}
+	}
+	if s.parentScope != nil {
+		return s.parentScope.FunctionScope(name)
+	}
+	return nil
+}
+
+// Functions returns the functions declared directly in this scope.
+func (s *Scope) Functions() []Function {
+	return s.functions
+}
+
+func (s *Scope) Parent() *Scope {
+	return s.parentScope
+}
+
 func (s *Scope) AddVariable(variable Variable) {
 	s.variables = append(s.variables, variable)
 }
@@ -83,4 +174,3 @@ func New() *Scope {
 func FromParent(scope *Scope) *Scope {
 	return &Scope{parentScope: scope}
 }
-
@@ -0,0 +1,935 @@
+"use strict";
+// The runtime of transpiled programs. Values carry their type like values.Value does:
+// {t: type, v: payload}, where ints, int64s and bigints are BigInts, floats are numbers
+// rounded with Math.fround, arrays and maps are shared JS objects, records are arrays
+// of fields or null, optionals the wrapped value or null, and functions {name, call} or null.
+const $ = (() => {
+    const fs = require("fs");
+
+    const types = new Map();
+    function intern(key, make) {
+        let type = types.get(key);
+        if (type === undefined) {
+            type = make();
+            types.set(key, type);
+        }
+        return type;
+    }
+
+    const t = {};
+    for (const name of ["void", "string", "bool", "int", "float", "int64", "float64", "bigint"]) {
+        t[name] = intern("primitive:" + name, () => ({kind: "primitive", name}));
+    }
+    const arrayOf = (elem) => intern("array:" + elem.name, () => ({kind: "array", name: "array<" + elem.name + ">", elem}));
+    const mapOf = (key, elem) => intern("map:" + key.name + "," + elem.name, () => ({kind: "map", name: "map<" + key.name + ", " + elem.name + ">", key, elem}));
+    const optionalOf = (elem) => intern("optional:" + elem.name, () => ({kind: "optional", name: elem === t.void ? "none" : "optional<" + elem.name + ">", elem}));
+    const recordType = (name) => intern("record:" + name, () => ({kind: "record", name, fields: []}));
+    const enumType = (name, variants) => intern("enum:" + name + ":" + variants.join(","), () => ({kind: "enum", name, variants}));
+    const funcType = (params, returns) => {
+        let name = "func(" + params.map((param) => param.name).join(", ") + ")";
+        if (returns !== t.void) {
+            name += ": " + returns.name;
+        }
+        return intern("func:" + name, () => ({kind: "func", name, params, elem: returns}));
+    };
+    const none = optionalOf(t.void);
+    const errorType = recordType("error");
+    errorType.fields = [{name: "message", type: t.string}, {name: "trace", type: t.string}];
+
+    const isInteger = (type) => type === t.int || type === t.int64 || type === t.bigint;
+    const isNumber = (type) => isInteger(type) || type === t.float || type === t.float64;
+
+    const value = (type, v) => ({t: type, v});
+    const str = (v) => value(t.string, v);
+    const bool = (v) => value(t.bool, v);
+    const int = (v) => value(t.int, v);
+    const float = (v) => value(t.float, Math.fround(v));
+    const voidValue = value(t.void, null);
+    const noneValue = value(none, null);
+
+    function zero(type) {
+        switch (type.kind) {
+            case "array":
+                return value(type, []);
+            case "map":
+                return value(type, new Map());
+            case "enum":
+                return value(type, 0);
+        }
+        switch (type) {
+            case t.string:
+                return str("");
+            case t.bool:
+                return bool(false);
+            case t.int:
+            case t.int64:
+            case t.bigint:
+                return value(type, 0n);
+            case t.float:
+            case t.float64:
+                return value(type, 0);
+        }
+        return value(type, null);
+    }
+
+    // runtime errors
+
+    const errors = {
+        conversion: "invalid conversion",
+        divisionByZero: "division by zero",
+        integerOverflow: "integer overflow",
+        libraryFunction: "library function failed",
+        indexOutOfBounds: "index out of bounds",
+        keyNotFound: "key not found",
+        invalidKey: "invalid map key",
+        uninitialised: "record is not initialised",
+        uninitialisedFunction: "function is not initialised",
+        zeroStep: "step of for loop is zero",
+    };
+
+    const callStack = [];
+
+    // Failure is a runtime error; thrown is set for values from <throw>
+    class Failure {
+        constructor(site, message, catchable, thrown) {
+            this.site = site;
+            this.message = message;
+            this.catchable = catchable;
+            this.thrown = thrown;
+            this.stack = callStack.slice();
+        }
+
+        stackTrace() {
+            const shownFrames = 10;
+            let trace = "";
+            for (let i = this.stack.length - 1; i >= 0; i--) {
+                if (this.stack.length > 3 * shownFrames && i === this.stack.length - 1 - shownFrames) {
+                    trace += "\t... " + (this.stack.length - 2 * shownFrames) + " more frames ...\n";
+                    i = shownFrames - 1;
+                }
+                const frame = this.stack[i];
+                trace += "\tat " + frame.name + " (called from " + frame.site + ")\n";
+            }
+            return trace;
+        }
+
+        // the value a <catch> gets
+        value() {
+            if (this.thrown !== undefined) {
+                return this.thrown;
+            }
+            const trace = (this.site + "\n" + this.stackTrace()).replace(/\n$/, "");
+            return value(errorType, [str(this.message), str(trace)]);
+        }
+    }
+
+    // ConversionError is what failed casts throw, so that natives can wrap it
+    class ConversionError {
+        constructor(message) {
+            this.message = errors.conversion + ": " + message;
+        }
+    }
+
+    function fail(site, message) {
+        throw new Failure(site, message, true);
+    }
+
+    function throwValue(site, thrown) {
+        let message;
+        if (thrown.t === errorType && thrown.v !== null) {
+            message = thrown.v[0].v;
+        } else {
+            message = format(thrown);
+        }
+        throw new Failure(site, "uncaught exception: " + message, true, thrown);
+    }
+
+    // caught returns the value for a <catch> of one of the types, or rethrows e
+    function caught(e, catchTypes) {
+        if (!(e instanceof Failure) || !e.catchable) {
+            throw e;
+        }
+        const thrown = e.value();
+        for (let i = 0; i < catchTypes.length; i++) {
+            if (thrown.t === catchTypes[i] || (thrown.t === none && catchTypes[i].kind === "optional")) {
+                return [i, thrown];
+            }
+        }
+        throw e;
+    }
+
+    // numbers
+
+    const minInt = -(2n ** 63n);
+    const maxInt = 2n ** 63n - 1n;
+
+    // formatFloat prints like Go's %v: the shortest digits that read back as the same value
+    function formatFloat(x, single) {
+        if (Number.isNaN(x)) {
+            return "NaN";
+        }
+        if (x === Infinity) {
+            return "+Inf";
+        }
+        if (x === -Infinity) {
+            return "-Inf";
+        }
+        if (x === 0) {
+            return Object.is(x, -0) ? "-0" : "0";
+        }
+
+        let exponential = x.toExponential();
+        if (single) {
+            for (let precision = 0; precision < 9; precision++) {
+                exponential = x.toExponential(precision);
+                if (Math.fround(Number(exponential)) === x) {
+                    break;
+                }
+            }
+        }
+        const [mantissa, exponentText] = exponential.split("e");
+        const negative = mantissa.startsWith("-");
+        const digits = mantissa.replace(/^-/, "").replace(".", "").replace(/0+$/, "") || "0";
+        const exponent = Number(exponentText);
+
+        let result;
+        if (exponent < -4 || exponent >= 6) {
+            result = digits[0];
+            if (digits.length > 1) {
+                result += "." + digits.slice(1);
+            }
+            const absolute = Math.abs(exponent);
+            result += "e" + (exponent < 0 ? "-" : "+") + (absolute < 10 ? "0" : "") + absolute;
+        } else if (exponent < 0) {
+            result = "0." + "0".repeat(-exponent - 1) + digits;
+        } else if (digits.length <= exponent + 1) {
+            result = digits + "0".repeat(exponent + 1 - digits.length);
+        } else {
+            result = digits.slice(0, exponent + 1) + "." + digits.slice(exponent + 1);
+        }
+        return (negative ? "-" : "") + result;
+    }
+
+    function promoteTypes(a, b) {
+        if (a === b) {
+            return a;
+        }
+        if (a === t.int && isNumber(b)) {
+            return b;
+        }
+        if (b === t.int && isNumber(a)) {
+            return a;
+        }
+        if (a === t.float && b === t.float64 || a === t.float64 && b === t.float) {
+            return t.float64;
+        }
+        return t.bigint;
+    }
+
+    function promote(args) {
+        let type = args[0].t;
+        let same = true;
+        for (const arg of args.slice(1)) {
+            if (arg.t !== type) {
+                type = promoteTypes(type, arg.t);
+                same = false;
+            }
+        }
+        if (same) {
+            return args;
+        }
+        return args.map((arg) => cast(arg, type));
+    }
+
+    function intArithmetic(operator, a, b, site) {
+        let result;
+        switch (operator) {
+            case "add":
+                result = a + b;
+                break;
+            case "sub":
+                result = a - b;
+                break;
+            case "mul":
+                result = a * b;
+                break;
+            default:
+                if (b === 0n) {
+                    fail(site, errors.divisionByZero);
+                }
+                result = operator === "div" ? a / b : a % b;
+        }
+        return result;
+    }
+
+    function arithmetic(site, operator, a, b) {
+        switch (a.t) {
+            case t.int:
+            case t.int64: {
+                const result = intArithmetic(operator, a.v, b.v, site);
+                if (result < minInt || result > maxInt) {
+                    fail(site, errors.integerOverflow);
+                }
+                return value(a.t, result);
+            }
+            case t.bigint:
+                return value(t.bigint, intArithmetic(operator, a.v, b.v, site));
+        }
+        let result;
+        switch (operator) {
+            case "add":
+                result = a.v + b.v;
+                break;
+            case "sub":
+                result = a.v - b.v;
+                break;
+            case "mul":
+                result = a.v * b.v;
+                break;
+            default:
+                result = a.v / b.v;
+        }
+        return value(a.t, a.t === t.float ? Math.fround(result) : result);
+    }
+
+    function less(a, b) {
+        if (a.t.kind === "enum") {
+            return a.v < b.v;
+        }
+        if (a.t === t.string) {
+            return compareStrings(a.v, b.v) < 0;
+        }
+        if (a.t === t.bool) {
+            return !a.v && b.v;
+        }
+        return a.v < b.v;
+    }
+
+    // compareStrings compares by UTF-8 bytes, which is the same as comparing code points
+    function compareStrings(a, b) {
+        const x = Array.from(a);
+        const y = Array.from(b);
+        for (let i = 0; i < x.length && i < y.length; i++) {
+            const difference = x[i].codePointAt(0) - y[i].codePointAt(0);
+            if (difference !== 0) {
+                return difference;
+            }
+        }
+        return x.length - y.length;
+    }
+
+    function operate(site, operator, args) {
+        switch (operator) {
+            case "add":
+            case "sub":
+            case "mul":
+            case "div":
+            case "mod": {
+                args = promote(args);
+                let result = args[0];
+                for (const arg of args.slice(1)) {
+                    result = arithmetic(site, operator, result, arg);
+                }
+                return result;
+            }
+            case "concat":
+                return str(args.map(toString).join(""));
+            case "equal": {
+                const [a, b] = promote(args);
+                return bool(a.v === b.v);
+            }
+            case "lt": {
+                const [a, b] = promote(args);
+                return bool(less(a, b));
+            }
+            case "gt": {
+                const [a, b] = promote(args);
+                return bool(less(b, a));
+            }
+        }
+        throw new Error("unknown operator " + operator);
+    }
+
+    // forValue returns the loop variable of a <for> in the nth iteration, or undefined once the loop ends
+    function forValue(site, from, to, step, n, inclusive) {
+        [from, to, step, n] = promote([from, to, step, int(BigInt(n))]);
+        const zeroStep = cast(int(0n), step.t);
+        if (step.v === zeroStep.v) {
+            fail(site, errors.zeroStep);
+        }
+        let current;
+        try {
+            current = arithmetic(site, "add", from, arithmetic(site, "mul", n, step));
+        } catch (e) {
+            if (e instanceof Failure && e.message === errors.integerOverflow) {
+                return undefined;
+            }
+            throw e;
+        }
+        let compare = less(current, to) ? -1 : less(to, current) ? 1 : 0;
+        if (!less(zeroStep, step)) {
+            compare = -compare;
+        }
+        return compare < 0 || (inclusive && compare === 0) ? current : undefined;
+    }
+
+    // conversions
+
+    function toString(x) {
+        switch (x.t) {
+            case t.string:
+                return x.v;
+            case t.bool:
+            case t.int:
+            case t.int64:
+            case t.bigint:
+                return String(x.v);
+            case t.float:
+                return formatFloat(x.v, true);
+            case t.float64:
+                return formatFloat(x.v, false);
+        }
+        if (x.t.kind === "enum") {
+            return x.t.variants[x.v];
+        }
+        return x.t.name;
+    }
+
+    function outOfRange(text, type) {
+        return new ConversionError(text + " is out of the range of " + type.name);
+    }
+
+    function toInteger(x, to) {
+        let result;
+        if (x.t === t.float || x.t === t.float64) {
+            if (!Number.isFinite(x.v)) {
+                throw outOfRange(formatFloat(x.v, false), to);
+            }
+            result = BigInt(Math.trunc(x.v));
+        } else {
+            result = x.v;
+        }
+        if (to !== t.bigint && (result < minInt || result > maxInt)) {
+            throw outOfRange(toString(x), to);
+        }
+        return value(to, result);
+    }
+
+    function toFloat(x, to) {
+        const result = Number(x.v);
+        return value(to, to === t.float ? Math.fround(result) : result);
+    }
+
+    const floatPattern = /^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$/;
+    const specialFloatPattern = /^[+-]?(inf|infinity|nan)$/i;
+
+    function parse(text, to) {
+        const invalid = () => new ConversionError(JSON.stringify(text) + " is not a valid " + to.name);
+        switch (to) {
+            case t.bool:
+                if (text === "true" || text === "false") {
+                    return bool(text === "true");
+                }
+                throw invalid();
+            case t.int:
+            case t.int64:
+            case t.bigint: {
+                if (!/^[+-]?\d+$/.test(text)) {
+                    throw invalid();
+                }
+                const result = BigInt(text);
+                if (to !== t.bigint && (result < minInt || result > maxInt)) {
+                    throw invalid();
+                }
+                return value(to, result);
+            }
+            case t.float:
+            case t.float64: {
+                if (specialFloatPattern.test(text)) {
+                    const lower = text.toLowerCase();
+                    return value(to, lower.endsWith("nan") ? NaN : lower.startsWith("-") ? -Infinity : Infinity);
+                }
+                if (!floatPattern.test(text)) {
+                    throw invalid();
+                }
+                const result = to === t.float ? Math.fround(Number(text)) : Number(text);
+                if (!Number.isFinite(result)) {
+                    throw invalid();
+                }
+                return value(to, result);
+            }
+        }
+        const index = to.variants.indexOf(text);
+        if (index < 0) {
+            throw invalid();
+        }
+        return value(to, index);
+    }
+
+    // cast converts like <cast>, and fails at site if the value can't be converted
+    function castAt(site, x, to) {
+        try {
+            return cast(x, to);
+        } catch (e) {
+            if (e instanceof ConversionError) {
+                fail(site, e.message);
+            }
+            throw e;
+        }
+    }
+
+    function cast(x, to) {
+        const from = x.t;
+        if (from === to) {
+            return x;
+        }
+        if (to === t.string) {
+            return str(toString(x));
+        }
+        if (from === t.string) {
+            return parse(x.v, to);
+        }
+        if (isNumber(from) && isInteger(to)) {
+            return toInteger(x, to);
+        }
+        if (isNumber(from) && (to === t.float || to === t.float64)) {
+            return toFloat(x, to);
+        }
+        if (from === t.bool) {
+            return int(x.v ? 1n : 0n);
+        }
+        if (to === t.bool) {
+            return bool(x.v !== 0n);
+        }
+        if (to === t.int) {
+            return int(BigInt(x.v));
+        }
+        if (x.v < 0n || x.v >= BigInt(to.variants.length)) {
+            throw new ConversionError(to.name + " has no variant " + x.v);
+        }
+        return value(to, Number(x.v));
+    }
+
+    // output and input
+
+    let output = "";
+
+    function writeAll(fd, text) {
+        const buffer = Buffer.from(text);
+        let written = 0;
+        while (written < buffer.length) {
+            try {
+                written += fs.writeSync(fd, buffer, written);
+            } catch (e) {
+                if (e.code !== "EAGAIN") {
+                    throw e;
+                }
+            }
+        }
+    }
+
+    function flush() {
+        writeAll(1, output);
+        output = "";
+    }
+
+    // printing holds the records being formatted, as they can contain themselves
+    function format(x, printing = new Set()) {
+        const nested = (y) => format(y, printing);
+        switch (x.t.kind) {
+            case "array":
+                return "[" + x.v.map(nested).join(", ") + "]";
+            case "optional":
+                return x.v === null ? "none" : nested(x.v);
+            case "record": {
+                if (x.v === null) {
+                    return "uninitialised " + x.t.name;
+                }
+                if (printing.has(x.v)) {
+                    return x.t.name + "{...}";
+                }
+                printing.add(x.v);
+                const fields = x.v.map((field, i) => x.t.fields[i].name + ": " + nested(field)).join(", ");
+                printing.delete(x.v);
+                return x.t.name + "{" + fields + "}";
+            }
+            case "func":
+                return x.v === null ? "uninitialised " + x.t.name : "func " + x.v.name;
+            case "map":
+                return "{" + sortedEntries(x).map(([key, entry]) => nested(key) + ": " + nested(entry)).join(", ") + "}";
+        }
+        return toString(x);
+    }
+
+    function print(args) {
+        output += args.map((x) => format(x)).join("") + "\n";
+        if (output.length > 1 << 16) {
+            flush();
+        }
+    }
+
+    let input = Buffer.alloc(0);
+    let inputEnded = false;
+
+    function readInput(site) {
+        flush();
+        for (;;) {
+            const newline = input.indexOf(10);
+            if (newline >= 0 || inputEnded) {
+                const end = newline >= 0 ? newline : input.length;
+                if (end === 0 && newline < 0) {
+                    throw new Failure(site, "EOF", false);
+                }
+                const line = input.subarray(0, end).toString().replace(/[\r\n]+$/, "");
+                input = input.subarray(newline >= 0 ? newline + 1 : end);
+                return str(line);
+            }
+            const chunk = Buffer.alloc(4096);
+            let read;
+            try {
+                read = fs.readSync(0, chunk, 0, chunk.length, null);
+            } catch (e) {
+                if (e.code === "EAGAIN") {
+                    continue;
+                }
+                if (e.code !== "EOF") {
+                    throw new Failure(site, e.message, false);
+                }
+                read = 0;
+            }
+            if (read === 0) {
+                inputEnded = true;
+            }
+            input = Buffer.concat([input, chunk.subarray(0, read)]);
+        }
+    }
+
+    // functions
+
+    function func(name, type, call) {
+        return value(type, {name, call});
+    }
+
+    // the interpreter's default limit, see vm.DefaultMaxCallDepth
+    const maxCallDepth = 10000;
+
+    function call(site, callee, args) {
+        if (callee.v === null) {
+            fail(site, errors.uninitialisedFunction);
+        }
+        if (callStack.length >= maxCallDepth) {
+            throw new Failure(site, "call depth limit exceeded: more than " + maxCallDepth + " nested calls", false);
+        }
+        callStack.push({name: callee.v.name, site});
+        try {
+            return callee.v.call(args, site);
+        } finally {
+            callStack.pop();
+        }
+    }
+
+    // native calls a builtin; errors it reports fail the call like in the VM
+    function native(name, params, returns, implementation) {
+        return func(name, funcType(params, returns), (args, site) => {
+            try {
+                return implementation(args);
+            } catch (e) {
+                if (e instanceof ConversionError || e instanceof NativeError) {
+                    fail(site, errors.libraryFunction + ": " + name + ": " + e.message);
+                }
+                throw e;
+            }
+        });
+    }
+
+    class NativeError {
+        constructor(message) {
+            this.message = message;
+        }
+    }
+
+    // arrays, maps and records
+
+    function array(elem, elements) {
+        return value(arrayOf(elem || elements[0].t), elements);
+    }
+
+    function checkIndex(site, x, index) {
+        if (index.v < 0n || index.v >= BigInt(x.v.length)) {
+            fail(site, errors.indexOutOfBounds + ": index " + index.v + " with length " + x.v.length);
+        }
+        return Number(index.v);
+    }
+
+    function index(site, x, i) {
+        return x.v[checkIndex(site, x, i)];
+    }
+
+    function setIndex(site, x, i, element) {
+        x.v[checkIndex(site, x, i)] = element;
+    }
+
+    function append(x, elements) {
+        x.v.push(...elements);
+    }
+
+    function length(x) {
+        if (x.t === t.string) {
+            return int(BigInt(runes(x.v).length));
+        }
+        return int(BigInt(x.t.kind === "map" ? x.v.size : x.v.length));
+    }
+
+    function key(x) {
+        if (x.t === t.float || x.t === t.float64) {
+            return Object.is(x.v, -0) ? "0" : String(x.v);
+        }
+        return String(x.v);
+    }
+
+    // NaN keys are rejected like in the VM, where they can't be found again
+    function checkKey(site, k) {
+        if (Number.isNaN(k.v)) {
+            fail(site, errors.invalidKey + ": " + format(k));
+        }
+    }
+
+    // entries alternates between keys and values
+    function map(site, keyType, valueType, entries) {
+        const result = value(mapOf(keyType || entries[0].t, valueType || entries[1].t), new Map());
+        for (let i = 0; i < entries.length; i += 2) {
+            checkKey(site, entries[i]);
+            result.v.set(key(entries[i]), [entries[i], entries[i + 1]]);
+        }
+        return result;
+    }
+
+    function sortedEntries(x) {
+        return Array.from(x.v.values()).sort(([a], [b]) => less(a, b) ? -1 : less(b, a) ? 1 : 0);
+    }
+
+    function get(site, x, k) {
+        const entry = x.v.get(key(k));
+        if (entry === undefined) {
+            fail(site, errors.keyNotFound + ": " + (k.t === t.string ? JSON.stringify(k.v) : format(k)));
+        }
+        return entry[1];
+    }
+
+    function has(x, k) {
+        return bool(x.v.has(key(k)));
+    }
+
+    function put(site, x, k, entry) {
+        checkKey(site, k);
+        x.v.set(key(k), [k, entry]);
+    }
+
+    function remove(x, k) {
+        x.v.delete(key(k));
+    }
+
+    function keys(x) {
+        return value(arrayOf(x.t.key), sortedEntries(x).map(([k]) => k));
+    }
+
+    // iterate returns what a foreach loop goes through: arrays as they are, maps as a snapshot of keys and values
+    function iterate(x) {
+        if (x.t.kind === "array") {
+            return [x.v, []];
+        }
+        const entries = sortedEntries(x);
+        return [entries.map(([k]) => k), entries.map(([, entry]) => entry)];
+    }
+
+    function declareFields(type, fields) {
+        type.fields = fields.map(([name, fieldType]) => ({name, type: fieldType}));
+    }
+
+    function record(type, fields) {
+        return value(type, fields);
+    }
+
+    function fieldIndex(site, x, name) {
+        if (x.v === null) {
+            fail(site, errors.uninitialised + ": " + x.t.name);
+        }
+        return x.t.fields.findIndex((field) => field.name === name);
+    }
+
+    function getField(site, x, name) {
+        return x.v[fieldIndex(site, x, name)];
+    }
+
+    function setField(site, x, name, field) {
+        x.v[fieldIndex(site, x, name)] = field;
+    }
+
+    function some(x) {
+        return value(optionalOf(x.t), x);
+    }
+
+    function unwrapOr(x, otherwise) {
+        return x.v !== null ? x.v : otherwise();
+    }
+
+    function variant(x) {
+        return x.t.variants[x.v];
+    }
+
+    // the standard library, see package builtins
+
+    const runes = (text) => Array.from(text);
+
+    // floatFunction applies f to the arguments promoted to float or float64, and returns a result of that type
+    function floatFunction(f) {
+        return (args) => {
+            args = promote(args);
+            const result = f(...args.map((x) => x.v));
+            return args[0].t === t.float64 ? value(t.float64, result) : float(result);
+        };
+    }
+
+    function extreme(sign) {
+        return (args) => {
+            args = promote(args);
+            return args.slice(1).reduce((result, arg) => sign < 0 && less(arg, result) || sign > 0 && less(result, arg) ? arg : result, args[0]);
+        };
+    }
+
+    // goUpper and goLower map each code point on its own like Go's simple case mapping, so ß stays ß and Σ becomes σ
+    // at the end of words too. JavaScript maps to several code points where Go maps to one, or to none, in a few cases.
+    function goUpper(text) {
+        return runes(text).map((character) => {
+            const code = character.codePointAt(0);
+            // Greek letters with ypogegrammeni map to their titlecase forms
+            if (code >= 0x1f80 && code <= 0x1faf && (code & 0xf) < 8) {
+                return String.fromCodePoint(code + 8);
+            }
+            if (code === 0x1fb3 || code === 0x1fc3 || code === 0x1ff3) {
+                return String.fromCodePoint(code + 9);
+            }
+            return simpleCase(character, character.toUpperCase());
+        }).join("");
+    }
+
+    function goLower(text) {
+        return runes(text).map((character) => character === "\u0130" ? "i" : simpleCase(character, character.toLowerCase())).join("");
+    }
+
+    function simpleCase(character, mapped) {
+        return runes(mapped).length === 1 ? mapped : character;
+    }
+
+    function goSplit(text, separator) {
+        if (separator === "") {
+            return runes(text);
+        }
+        return text.split(separator);
+    }
+
+    function goReplace(text, old, replacement) {
+        if (old === "") {
+            const characters = runes(text);
+            return replacement + characters.map((character) => character + replacement).join("");
+        }
+        return text.split(old).join(replacement);
+    }
+
+    const programArgs = process.argv.slice(2);
+
+    const builtins = {
+        sqrt: native("sqrt", [t.float], t.float, floatFunction(Math.sqrt)),
+        pow: native("pow", [t.float, t.float], t.float, floatFunction(Math.pow)),
+        floor: native("floor", [t.float], t.int, (args) => cast(floatFunction(Math.floor)(args), t.int)),
+        round: native("round", [t.float], t.int, (args) => cast(floatFunction((x) => Math.sign(x) * Math.round(Math.abs(x)))(args), t.int)),
+        abs: native("abs", [], t.void, ([x]) => {
+            switch (x.t) {
+                case t.float:
+                case t.float64:
+                    return value(x.t, Math.abs(x.v));
+                case t.bigint:
+                    return value(x.t, x.v < 0n ? -x.v : x.v);
+            }
+            if (x.v === minInt) {
+                throw new NativeError("the absolute value of the smallest " + x.t.name + " is out of range");
+            }
+            return value(x.t, x.v < 0n ? -x.v : x.v);
+        }),
+        min: native("min", [], t.void, extreme(-1)),
+        max: native("max", [], t.void, extreme(1)),
+
+        len: native("len", [t.string], t.int, ([x]) => int(BigInt(runes(x.v).length))),
+        substr: native("substr", [t.string, t.int, t.int], t.string, ([x, start, count]) => {
+            const characters = runes(x.v);
+            if (start.v < 0n || count.v < 0n || start.v > BigInt(characters.length) - count.v) {
+                throw new NativeError("substring from " + start.v + " with length " + count.v + " is out of range for length " + characters.length);
+            }
+            return str(characters.slice(Number(start.v), Number(start.v + count.v)).join(""));
+        }),
+        index: native("index", [t.string, t.string], t.int, ([x, part]) => {
+            const i = x.v.indexOf(part.v);
+            return int(i < 0 ? -1n : BigInt(runes(x.v.slice(0, i)).length));
+        }),
+        upper: native("upper", [t.string], t.string, ([x]) => str(goUpper(x.v))),
+        lower: native("lower", [t.string], t.string, ([x]) => str(goLower(x.v))),
+        trim: native("trim", [t.string], t.string, ([x]) => str(x.v.trim())),
+        split: native("split", [t.string, t.string], arrayOf(t.string), ([x, separator]) => value(arrayOf(t.string), goSplit(x.v, separator.v).map(str))),
+        join: native("join", [arrayOf(t.string), t.string], t.string, ([x, separator]) => str(x.v.map((element) => element.v).join(separator.v))),
+        replace: native("replace", [t.string, t.string, t.string], t.string, ([x, old, replacement]) => str(goReplace(x.v, old.v, replacement.v))),
+
+        toInt: native("toInt", [t.float], t.int, ([x]) => cast(x, t.int)),
+        toFloat: native("toFloat", [t.int], t.float, ([x]) => cast(x, t.float)),
+        toString: native("toString", [], t.string, ([x]) => cast(x, t.string)),
+        parseInt: native("parseInt", [t.string], t.int, ([x]) => cast(x, t.int)),
+
+        args: native("args", [], arrayOf(t.string), () => value(arrayOf(t.string), programArgs.map(str))),
+    };
+
+    // The program runs in a worker, since the stack of the main thread is too small for maxCallDepth calls.
+    // Piped into node, it isn't a file a worker could be started from, and runs in the main thread instead.
+    function run(program) {
+        const threads = require("worker_threads");
+        if (threads.isMainThread && require("path").isAbsolute(__filename)) {
+            const worker = new threads.Worker(__filename, {
+                argv: programArgs,
+                resourceLimits: {stackSizeMb: 256},
+            });
+            worker.on("exit", (code) => {
+                process.exitCode = code;
+            });
+            return;
+        }
+
+        let code = 0;
+        try {
+            program();
+        } catch (e) {
+            if (e instanceof RangeError && e.message.includes("call stack") && threads.isMainThread) {
+                flush();
+                writeAll(2, "runtime error: call depth limit exceeded: the stack ran out, it is bigger if the program is run from a file\n");
+                process.exit(1);
+            }
+            if (!(e instanceof Failure)) {
+                throw e;
+            }
+            flush();
+            writeAll(2, e.site + ": runtime error: " + e.message + "\n" + e.stackTrace());
+            code = 1;
+        }
+        flush();
+        process.exit(code);
+    }
+
+    return {
+        t, arrayOf, mapOf, optionalOf, recordType, enumType, funcType, none, errorType,
+        value, str, bool, int, float, voidValue, noneValue, zero,
+        fail, throwValue, caught,
+        operate, forValue, cast: castAt,
+        print, readInput,
+        func, call, builtins,
+        array, index, setIndex, append, length, map, get, has, put, remove, keys, iterate,
+        declareFields, record, getField, setField, some, unwrapOr, variant,
+        run,
+    };
+})();
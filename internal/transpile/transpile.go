@@ -0,0 +1,595 @@
+// Package transpile translates programs into JavaScript that runs on Node.js.
+package transpile
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"xml-programming/internal/ast"
+)
+
+// the runtime the generated code calls into as $, see the comment at its top
+//
+//go:embed prelude.js
+var prelude string
+
+var ErrUnsupported = errors.New("can't be transpiled")
+
+// the standard library and args, which the prelude implements; other host functions can't be transpiled
+var builtins = map[string]bool{
+	"sqrt": true, "pow": true, "floor": true, "round": true, "abs": true, "min": true, "max": true,
+	"len": true, "substr": true, "index": true, "upper": true, "lower": true, "trim": true,
+	"split": true, "join": true, "replace": true,
+	"toInt": true, "toFloat": true, "toString": true, "parseInt": true,
+	"args": true,
+}
+
+const indentation = "    "
+
+// JavaScript returns a program that behaves like the interpreter running program, which has to have passed the analysis.
+// Its arguments are what args returns.
+func JavaScript(program *ast.Program) ([]byte, error) {
+	g := &generator{
+		out:       &bytes.Buffer{},
+		typeNames: map[ast.Type]string{},
+		records:   map[ast.Type]*ast.RecordDeclaration{},
+		used:      map[string]int{},
+	}
+	for i, record := range program.Records {
+		g.records[record.Type] = &program.Records[i]
+	}
+
+	g.indent = 1
+	g.scoped(nil, program.Statements)
+	if g.err != nil {
+		return nil, g.err
+	}
+	body := g.out
+
+	fields := bytes.Buffer{}
+	for _, record := range program.Records {
+		var declarations []string
+		for _, field := range record.Fields {
+			declarations = append(declarations, "["+quote(field.Name)+", "+g.typeRef(field.Type)+"]")
+		}
+		fmt.Fprintf(&fields, "$.declareFields(%s, [%s]);\n", g.typeRef(record.Type), strings.Join(declarations, ", "))
+	}
+
+	result := bytes.Buffer{}
+	result.WriteString(prelude)
+	result.WriteString("\n")
+	result.Write(g.types.Bytes())
+	result.Write(fields.Bytes())
+	result.WriteString("\n$.run(() => {\n")
+	result.Write(body.Bytes())
+	result.WriteString("});\n")
+	return result.Bytes(), nil
+}
+
+type generator struct {
+	out    *bytes.Buffer
+	indent int
+	err    error
+
+	// type declarations, which are written before the program
+	types     bytes.Buffer
+	typeNames map[ast.Type]string
+	records   map[ast.Type]*ast.RecordDeclaration
+
+	// the JS names of the variables and functions visible to the code being generated, innermost scope last
+	scopes []map[string]string
+	// the variables of the current block with its own scope, which are declared at the start of the block
+	hoisted *[]string
+	// how often a name was used, so shadowing names get JS names of their own
+	used map[string]int
+	temp int
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	g.out.WriteString(strings.Repeat(indentation, g.indent))
+	fmt.Fprintf(g.out, format, args...)
+	g.out.WriteString("\n")
+}
+
+func (g *generator) fail(node ast.Spanned, format string, args ...interface{}) {
+	if g.err == nil {
+		g.err = ast.Errorf(node.GetSpan(), "%w: "+format, append([]interface{}{ErrUnsupported}, args...)...)
+	}
+}
+
+func quote(text string) string {
+	quoted, _ := json.Marshal(text)
+	return string(quoted)
+}
+
+func site(node ast.Spanned) string {
+	return quote(node.GetSpan().String())
+}
+
+// identifier turns a name into one that is valid in JS and can't clash with the prelude
+func identifier(prefix string, name string) string {
+	builder := strings.Builder{}
+	builder.WriteString(prefix)
+	for _, r := range name {
+		if r < 128 && (r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+		} else {
+			fmt.Fprintf(&builder, "$%x$", r)
+		}
+	}
+	return builder.String()
+}
+
+func (g *generator) tempName() string {
+	g.temp++
+	return "t_" + strconv.Itoa(g.temp)
+}
+
+// bind makes name refer to a new JS variable in the innermost scope and returns it
+func (g *generator) bind(name string) string {
+	base := identifier("v_", name)
+	g.used[base]++
+	result := base
+	if g.used[base] > 1 {
+		result += "$" + strconv.Itoa(g.used[base]-1)
+	}
+	g.scopes[len(g.scopes)-1][name] = result
+	return result
+}
+
+// declare is bind for variables that are declared by a statement; declaring a name again in the same scope starts it over
+func (g *generator) declare(name string) string {
+	if result, ok := g.scopes[len(g.scopes)-1][name]; ok {
+		return result
+	}
+	result := g.bind(name)
+	*g.hoisted = append(*g.hoisted, result)
+	return result
+}
+
+func (g *generator) lookup(node ast.Spanned, name string) string {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if result, ok := g.scopes[i][name]; ok {
+			return result
+		}
+	}
+	if !builtins[name] {
+		g.fail(node, "host function %s", name)
+	}
+	return "$.builtins." + name
+}
+
+// scoped generates the statements of a block with its own scope, like a function body. The block itself has to be opened by the caller.
+// bind declares the variables the block starts out with, like arguments.
+func (g *generator) scoped(bind func(), statements []ast.Statement) {
+	g.scopes = append(g.scopes, map[string]string{})
+	hoisted, out := g.hoisted, g.out
+	g.hoisted, g.out = &[]string{}, &bytes.Buffer{}
+
+	if bind != nil {
+		bind()
+	}
+	g.statements(statements)
+
+	body := g.out
+	g.out = out
+	if len(*g.hoisted) > 0 {
+		g.line("let %s;", strings.Join(*g.hoisted, ", "))
+	}
+	g.out.Write(body.Bytes())
+	g.hoisted = hoisted
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+// nested generates the statements of a block that shares the scope around it, like the body of an <if>
+func (g *generator) nested(statements []ast.Statement) {
+	g.indent++
+	g.statements(statements)
+	g.indent--
+}
+
+func (g *generator) typeRef(_type ast.Type) string {
+	if _type.IsPrimitive() {
+		return "$.t." + _type.String()
+	}
+	switch _type {
+	case ast.None:
+		return "$.none"
+	case ast.ErrorType:
+		return "$.errorType"
+	}
+	if name, ok := g.typeNames[_type]; ok {
+		return name
+	}
+
+	var definition string
+	switch _type.Kind() {
+	case ast.ArrayKind:
+		definition = "$.arrayOf(" + g.typeRef(_type.Elem()) + ")"
+	case ast.MapKind:
+		definition = "$.mapOf(" + g.typeRef(_type.Key()) + ", " + g.typeRef(_type.Elem()) + ")"
+	case ast.OptionalKind:
+		definition = "$.optionalOf(" + g.typeRef(_type.Elem()) + ")"
+	case ast.RecordKind:
+		definition = "$.recordType(" + quote(_type.String()) + ")"
+	case ast.EnumKind:
+		var variants []string
+		for _, variant := range _type.Variants() {
+			variants = append(variants, quote(variant))
+		}
+		definition = "$.enumType(" + quote(_type.String()) + ", [" + strings.Join(variants, ", ") + "])"
+	case ast.FunctionKind:
+		var params []string
+		for _, param := range _type.Params() {
+			params = append(params, g.typeRef(param))
+		}
+		definition = "$.funcType([" + strings.Join(params, ", ") + "], " + g.typeRef(_type.Elem()) + ")"
+	}
+
+	name := "T" + strconv.Itoa(len(g.typeNames))
+	g.typeNames[_type] = name
+	fmt.Fprintf(&g.types, "const %s = %s;\n", name, definition)
+	return name
+}
+
+func (g *generator) statements(statements []ast.Statement) {
+	for _, statement := range statements {
+		g.statement(statement)
+	}
+}
+
+func (g *generator) statement(statement ast.Statement) {
+	switch v := statement.(type) {
+	case ast.OutputStatement:
+		g.line("$.print([%s]);", g.expressions(v.Exprs))
+	case ast.VariableDeclarationStatement:
+		g.line("%s = $.zero(%s);", g.declare(v.Name), g.typeRef(v.Type))
+	case ast.VariableAssignmentStatement:
+		value := g.expression(v.Expr)
+		g.line("%s = %s;", g.lookup(v, v.Name), value)
+	case ast.FunctionStatement:
+		name := g.declare(v.Name)
+		g.line("%s = %s;", name, g.function(v.Name, v.Args, v.Returns, v.Body))
+	case ast.FunctionReturnStatement:
+		g.line("return %s;", g.expression(v.Expr))
+	case ast.FunctionCall:
+		g.line("%s;", g.expression(v))
+	case ast.ConditionalStatement:
+		for i, _if := range v.Ifs {
+			if i == 0 {
+				g.line("if (%s.v) {", g.expression(_if.Expr))
+			} else {
+				g.line("} else if (%s.v) {", g.expression(_if.Expr))
+			}
+			g.nested(_if.Then)
+		}
+		if len(v.Else) > 0 {
+			g.line("} else {")
+			g.nested(v.Else)
+		}
+		g.line("}")
+	case ast.LoopStatement:
+		g.line("%swhile (true) {", label(v.Label))
+		g.indent++
+		g.line("if (!%s.v) {", g.expression(v.LoopCondition))
+		g.line(indentation + "break;")
+		g.line("}")
+		g.statements(v.Body)
+		g.indent--
+		g.line("}")
+	case ast.ForStatement:
+		g.forStatement(v)
+	case ast.ForEachStatement:
+		g.forEachStatement(v)
+	case ast.ThrowStatement:
+		g.line("$.throwValue(%s, %s);", site(v), g.expression(v.Expr))
+	case ast.TryStatement:
+		g.tryStatement(v)
+	case ast.BreakStatement:
+		g.line("break%s;", target(v.Label))
+	case ast.ContinueStatement:
+		g.line("continue%s;", target(v.Label))
+	case ast.AppendStatement:
+		array := g.expression(v.Array)
+		g.line("$.append(%s, [%s]);", array, g.expressions(v.Values))
+	case ast.SetIndexStatement:
+		g.line("$.setIndex(%s, %s);", site(v), g.expressions([]ast.Expression{v.Array, v.Index, v.Value}))
+	case ast.MatchStatement:
+		variant := g.tempName()
+		g.line("const %s = $.variant(%s);", variant, g.expression(v.Expr))
+		for i, _case := range v.Cases {
+			if i == 0 {
+				g.line("if (%s === %s) {", variant, quote(_case.Variant))
+			} else {
+				g.line("} else if (%s === %s) {", variant, quote(_case.Variant))
+			}
+			g.nested(_case.Body)
+		}
+		if len(v.Cases) == 0 {
+			g.line("{")
+			g.nested(v.Default)
+		} else if len(v.Default) > 0 {
+			g.line("} else {")
+			g.nested(v.Default)
+		}
+		g.line("}")
+	case ast.SetFieldStatement:
+		record := g.expression(v.Record)
+		g.line("$.setField(%s, %s, %s, %s);", site(v), record, quote(v.Name), g.expression(v.Value))
+	case ast.PutStatement:
+		g.line("$.put(%s, %s);", site(v), g.expressions([]ast.Expression{v.Map, v.Key, v.Value}))
+	case ast.DeleteStatement:
+		g.line("$.remove(%s);", g.expressions([]ast.Expression{v.Map, v.Key}))
+	default:
+		g.fail(statement, "statement %T", statement)
+	}
+}
+
+func label(name string) string {
+	if name == "" {
+		return ""
+	}
+	return identifier("l_", name) + ": "
+}
+
+func target(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " " + identifier("l_", name)
+}
+
+func (g *generator) forStatement(statement ast.ForStatement) {
+	from, to, step := g.tempName(), g.tempName(), g.tempName()
+	stepValue := "$.int(1n)"
+	if statement.Step != nil {
+		stepValue = g.expression(statement.Step)
+	}
+	n, value := g.tempName(), g.tempName()
+
+	g.line("{")
+	g.indent++
+	g.line("const %s = %s, %s = %s, %s = %s;", from, g.expression(statement.From), to, g.expression(statement.To), step, stepValue)
+	g.line("%sfor (let %s = 0; ; %s++) {", label(statement.Label), n, n)
+	g.indent++
+	g.line("const %s = $.forValue(%s, %s, %s, %s, %s, %t);", value, site(statement), from, to, step, n, statement.Inclusive)
+	g.line("if (%s === undefined) {", value)
+	g.line(indentation + "break;")
+	g.line("}")
+	g.scoped(func() {
+		g.line("let %s = %s;", g.bind(statement.Name), value)
+	}, statement.Body)
+	g.indent--
+	g.line("}")
+	g.indent--
+	g.line("}")
+}
+
+func (g *generator) forEachStatement(statement ast.ForEachStatement) {
+	keys, entries, count, i := g.tempName(), g.tempName(), g.tempName(), g.tempName()
+
+	g.line("{")
+	g.indent++
+	// elements appended by the body are not iterated over
+	g.line("const [%s, %s] = $.iterate(%s);", keys, entries, g.expression(statement.In))
+	g.line("const %s = %s.length;", count, keys)
+	g.line("%sfor (let %s = 0; %s < %s; %s++) {", label(statement.Label), i, i, count, i)
+	g.indent++
+	g.scoped(func() {
+		g.line("let %s = %s[%s];", g.bind(statement.Name), keys, i)
+		if statement.ValueName != "" {
+			g.line("let %s = %s[%s];", g.bind(statement.ValueName), entries, i)
+		}
+	}, statement.Body)
+	g.indent--
+	g.line("}")
+	g.indent--
+	g.line("}")
+}
+
+func (g *generator) tryStatement(statement ast.TryStatement) {
+	if len(statement.Catches) == 0 && !statement.HasFinally {
+		g.line("{")
+		g.nested(statement.Body)
+		g.line("}")
+		return
+	}
+
+	g.line("try {")
+	g.nested(statement.Body)
+	if len(statement.Catches) > 0 {
+		e, index, value := g.tempName(), g.tempName(), g.tempName()
+		var types []string
+		for _, catch := range statement.Catches {
+			types = append(types, g.typeRef(catch.Type))
+		}
+		g.line("} catch (%s) {", e)
+		g.indent++
+		g.line("const [%s, %s] = $.caught(%s, [%s]);", index, value, e, strings.Join(types, ", "))
+		for i := range statement.Catches {
+			if i == 0 {
+				g.line("if (%s === %d) {", index, i)
+			} else {
+				g.line("} else if (%s === %d) {", index, i)
+			}
+			name := statement.Catches[i].Name
+			g.indent++
+			g.scoped(func() {
+				if name != "" {
+					g.line("let %s = %s;", g.bind(name), value)
+				}
+			}, statement.Catches[i].Body)
+			g.indent--
+		}
+		g.line("}")
+		g.indent--
+	}
+	if statement.HasFinally {
+		g.line("} finally {")
+		g.nested(statement.Finally)
+	}
+	g.line("}")
+}
+
+// function returns a function value; its body is a block of its own below the current indentation
+func (g *generator) function(name string, args []ast.FunctionArg, returns ast.Type, body []ast.Statement) string {
+	params := make([]ast.Type, len(args))
+	for i, arg := range args {
+		params[i] = arg.Type
+	}
+
+	out := g.out
+	g.out = &bytes.Buffer{}
+	g.indent++
+	g.scoped(func() {
+		for i, arg := range args {
+			g.line("let %s = args[%d];", g.bind(arg.Name), i)
+		}
+	}, body)
+	g.line("return $.voidValue;")
+	g.indent--
+	code := g.out.String()
+	g.out = out
+
+	return fmt.Sprintf("$.func(%s, %s, (args) => {\n%s%s})", quote(name), g.typeRef(ast.FunctionOf(params, returns)), code, strings.Repeat(indentation, g.indent))
+}
+
+func (g *generator) expressions(expressions []ast.Expression) string {
+	var results []string
+	for _, expression := range expressions {
+		results = append(results, g.expression(expression))
+	}
+	return strings.Join(results, ", ")
+}
+
+func (g *generator) expression(expression ast.Expression) string {
+	switch v := expression.(type) {
+	case ast.LiteralExpression:
+		return literal(v)
+	case ast.VariableExpression:
+		return g.lookup(v, v.Name)
+	case ast.OperatorExpression:
+		switch v.Operator {
+		case ast.And, ast.Or:
+			operator := " && "
+			if v.Operator == ast.Or {
+				operator = " || "
+			}
+			var operands []string
+			for _, expr := range v.Exprs {
+				operands = append(operands, g.expression(expr)+".v")
+			}
+			return "$.bool(" + strings.Join(operands, operator) + ")"
+		case ast.Not:
+			return "$.bool(!" + g.expression(v.Exprs[0]) + ".v)"
+		}
+		return fmt.Sprintf("$.operate(%s, %s, [%s])", site(v), quote(v.Operator.String()), g.expressions(v.Exprs))
+	case ast.FunctionCall:
+		callee := ""
+		if v.Callee != nil {
+			callee = g.expression(v.Callee)
+		} else {
+			callee = g.lookup(v, v.Name)
+		}
+		return fmt.Sprintf("$.call(%s, %s, [%s])", site(v), callee, g.expressions(v.Args))
+	case ast.LambdaExpression:
+		return g.function("<lambda>", v.Args, v.Returns, v.Body)
+	case ast.CastExpression:
+		return fmt.Sprintf("$.cast(%s, %s, %s)", site(v), g.expression(v.Expr), g.typeRef(v.Type))
+	case ast.InputExpression:
+		return fmt.Sprintf("$.readInput(%s)", site(v))
+	case ast.ArrayLiteral:
+		return fmt.Sprintf("$.array(%s, [%s])", g.inferred(v.ElemType), g.expressions(v.Elems))
+	case ast.IndexExpression:
+		return fmt.Sprintf("$.index(%s, %s)", site(v), g.expressions([]ast.Expression{v.Expr, v.Index}))
+	case ast.LengthExpression:
+		return "$.length(" + g.expression(v.Expr) + ")"
+	case ast.MapLiteral:
+		var entries []ast.Expression
+		for _, entry := range v.Entries {
+			entries = append(entries, entry.Key, entry.Value)
+		}
+		return fmt.Sprintf("$.map(%s, %s, %s, [%s])", site(v), g.inferred(v.KeyType), g.inferred(v.ValueType), g.expressions(entries))
+	case ast.GetExpression:
+		return fmt.Sprintf("$.get(%s, %s)", site(v), g.expressions([]ast.Expression{v.Map, v.Key}))
+	case ast.HasExpression:
+		return "$.has(" + g.expressions([]ast.Expression{v.Map, v.Key}) + ")"
+	case ast.KeysExpression:
+		return "$.keys(" + g.expression(v.Map) + ")"
+	case ast.NoneLiteral:
+		return "$.noneValue"
+	case ast.SomeExpression:
+		return "$.some(" + g.expression(v.Expr) + ")"
+	case ast.IsNoneExpression:
+		return "$.bool(" + g.expression(v.Expr) + ".v === null)"
+	case ast.UnwrapOrExpression:
+		return fmt.Sprintf("$.unwrapOr(%s, () => %s)", g.expression(v.Expr), g.expression(v.Default))
+	case ast.VariantLiteral:
+		return fmt.Sprintf("$.value(%s, %d)", g.typeRef(v.Type), v.Index)
+	case ast.NewExpression:
+		record := g.records[v.Type]
+		if record == nil {
+			g.fail(v, "record %v", v.Type)
+			return ""
+		}
+		// fields are evaluated in declaration order, like in the interpreter
+		var fields []string
+		for _, field := range record.Fields {
+			value := "$.zero(" + g.typeRef(field.Type) + ")"
+			for _, init := range v.Fields {
+				if init.Name == field.Name {
+					value = g.expression(init.Expr)
+				}
+			}
+			fields = append(fields, value)
+		}
+		return fmt.Sprintf("$.record(%s, [%s])", g.typeRef(v.Type), strings.Join(fields, ", "))
+	case ast.GetFieldExpression:
+		return fmt.Sprintf("$.getField(%s, %s, %s)", site(v), g.expression(v.Expr), quote(v.Name))
+	default:
+		g.fail(expression, "expression %T", expression)
+		return ""
+	}
+}
+
+// inferred refers to the type of a literal, or is null if it is inferred from the elements
+func (g *generator) inferred(_type ast.Type) string {
+	if _type == ast.Void {
+		return "null"
+	}
+	return g.typeRef(_type)
+}
+
+func literal(expression ast.LiteralExpression) string {
+	switch expression.Type {
+	case ast.String:
+		return "$.str(" + quote(expression.String) + ")"
+	case ast.Bool:
+		return "$.bool(" + strconv.FormatBool(expression.Bool) + ")"
+	case ast.Int:
+		return "$.int(" + strconv.Itoa(expression.Int) + "n)"
+	case ast.Float:
+		return "$.float(" + float(float64(expression.Float)) + ")"
+	case ast.Int64:
+		return "$.value($.t.int64, " + strconv.FormatInt(expression.Int64, 10) + "n)"
+	case ast.Float64:
+		return "$.value($.t.float64, " + float(expression.Float64) + ")"
+	default:
+		return "$.value($.t.bigint, " + expression.BigInt.String() + "n)"
+	}
+}
+
+func float(value float64) string {
+	switch text := strconv.FormatFloat(value, 'g', -1, 64); text {
+	case "+Inf":
+		return "Infinity"
+	case "-Inf":
+		return "-Infinity"
+	default:
+		return text
+	}
+}
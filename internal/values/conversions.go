@@ -0,0 +1,242 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"xml-programming/internal/ast"
+)
+
+// ErrConversion is returned by Cast for values that can't be represented in the target type.
+var ErrConversion = errors.New("invalid conversion")
+
+// ToString converts primitives and enums to text, the way <output> prints them.
+func ToString(value Value) string {
+	switch value.Type {
+	case ast.String:
+		return value.String
+	case ast.Bool:
+		return strconv.FormatBool(value.Bool)
+	case ast.Int:
+		return strconv.Itoa(value.Int)
+	case ast.Float:
+		return fmt.Sprint(value.Float)
+	case ast.Int64:
+		return strconv.FormatInt(value.Int64, 10)
+	case ast.Float64:
+		return fmt.Sprint(value.Float64)
+	case ast.BigInt:
+		return value.BigInt.String()
+	}
+	if value.Type.Kind() == ast.EnumKind {
+		return value.Type.Variants()[value.Int]
+	}
+	return value.Type.String()
+}
+
+// Cast converts value to the type, which ast.CanCast has to allow for the value's type:
+//
+//	number -> integer exactly, floats truncated towards zero; fails for NaN and values out of range
+//	number -> float   to the nearest float, which is infinite for bigints out of range
+//	bool   -> int     1 for true, 0 for false
+//	int    -> bool    whether it isn't 0
+//	enum   -> int     the index of the variant
+//	int    -> enum    the variant with that index, fails if there is none
+//	any    -> string  like ToString
+//	string -> any     parses what ToString produces, fails for anything else
+func Cast(value Value, to ast.Type) (Value, error) {
+	from := value.Type
+	if from == to {
+		return value, nil
+	}
+
+	if to == ast.String {
+		return Value{Type: ast.String, String: ToString(value)}, nil
+	}
+	if from == ast.String {
+		return parse(value.String, to)
+	}
+
+	switch {
+	case from.IsNumber() && to.IsInteger():
+		return toInteger(value, to)
+	case from.IsNumber() && (to == ast.Float || to == ast.Float64):
+		return toFloat(value, to), nil
+	case from == ast.Bool && to == ast.Int:
+		result := 0
+		if value.Bool {
+			result = 1
+		}
+		return Value{Type: ast.Int, Int: result}, nil
+	case from == ast.Int && to == ast.Bool:
+		return Value{Type: ast.Bool, Bool: value.Int != 0}, nil
+	case from.Kind() == ast.EnumKind && to == ast.Int:
+		return Value{Type: ast.Int, Int: value.Int}, nil
+	case from == ast.Int && to.Kind() == ast.EnumKind:
+		if value.Int < 0 || value.Int >= len(to.Variants()) {
+			return Value{}, fmt.Errorf("%w: %v has no variant %d", ErrConversion, to, value.Int)
+		}
+		return Value{Type: to, Int: value.Int}, nil
+	}
+	return Value{}, fmt.Errorf("%w: %v to %v", ErrConversion, from, to)
+}
+
+// Promote converts the operands of arithmetic, comparisons and numeric functions to the type ast.Promote returns for them.
+func Promote(args []Value) []Value {
+	_type := args[0].Type
+	same := true
+	for _, arg := range args[1:] {
+		if arg.Type != _type {
+			_type, _ = ast.Promote(_type, arg.Type)
+			same = false
+		}
+	}
+	if same {
+		return args
+	}
+
+	promoted := make([]Value, len(args))
+	for i, arg := range args {
+		// promotions can't fail
+		promoted[i], _ = Cast(arg, _type)
+	}
+	return promoted
+}
+
+func toInteger(value Value, to ast.Type) (Value, error) {
+	var result *big.Int
+	switch value.Type {
+	case ast.Int:
+		return fromInt64(int64(value.Int), to), nil
+	case ast.Int64:
+		if to == ast.Int && (value.Int64 < math.MinInt || value.Int64 > math.MaxInt) {
+			return Value{}, fmt.Errorf("%w: %v is out of the range of %v", ErrConversion, value.Int64, to)
+		}
+		return fromInt64(value.Int64, to), nil
+	case ast.BigInt:
+		result = value.BigInt
+	default:
+		float := value.Float64
+		if value.Type == ast.Float {
+			float = float64(value.Float)
+		}
+		if math.IsNaN(float) || math.IsInf(float, 0) {
+			return Value{}, fmt.Errorf("%w: %v is out of the range of %v", ErrConversion, float, to)
+		}
+		result, _ = big.NewFloat(math.Trunc(float)).Int(nil)
+	}
+
+	if to == ast.BigInt {
+		return Value{Type: ast.BigInt, BigInt: result}, nil
+	}
+	if !result.IsInt64() || (to == ast.Int && (result.Int64() < math.MinInt || result.Int64() > math.MaxInt)) {
+		return Value{}, fmt.Errorf("%w: %v is out of the range of %v", ErrConversion, ToString(value), to)
+	}
+	return fromInt64(result.Int64(), to), nil
+}
+
+// fromInt64 converts a value that is known to fit into the integer type
+func fromInt64(value int64, to ast.Type) Value {
+	switch to {
+	case ast.Int:
+		return Value{Type: ast.Int, Int: int(value)}
+	case ast.Int64:
+		return Value{Type: ast.Int64, Int64: value}
+	default:
+		return Value{Type: ast.BigInt, BigInt: big.NewInt(value)}
+	}
+}
+
+// toFloat rounds only once, so converting an int to float isn't less precise than in Go
+func toFloat(value Value, to ast.Type) Value {
+	var result float64
+	switch value.Type {
+	case ast.Int:
+		if to == ast.Float {
+			return Value{Type: ast.Float, Float: float32(value.Int)}
+		}
+		result = float64(value.Int)
+	case ast.Int64:
+		if to == ast.Float {
+			return Value{Type: ast.Float, Float: float32(value.Int64)}
+		}
+		result = float64(value.Int64)
+	case ast.BigInt:
+		exact := new(big.Float).SetInt(value.BigInt)
+		if to == ast.Float {
+			float, _ := exact.Float32()
+			return Value{Type: ast.Float, Float: float}
+		}
+		result, _ = exact.Float64()
+	case ast.Float:
+		result = float64(value.Float)
+	case ast.Float64:
+		result = value.Float64
+	}
+
+	if to == ast.Float {
+		return Value{Type: ast.Float, Float: float32(result)}
+	}
+	return Value{Type: ast.Float64, Float64: result}
+}
+
+// the text numbers are parsed from; strconv and math/big also accept Go syntax like 0x1p-2 or 1_000,
+// which JavaScript doesn't, so the text is checked like in prelude.js first
+var (
+	integerPattern      = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	floatPattern        = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	specialFloatPattern = regexp.MustCompile(`(?i)^[+-]?(inf|infinity|nan)$`)
+)
+
+func parse(text string, to ast.Type) (Value, error) {
+	switch {
+	case to.IsInteger() && !integerPattern.MatchString(text),
+		(to == ast.Float || to == ast.Float64) && !floatPattern.MatchString(text) && !specialFloatPattern.MatchString(text):
+		return Value{}, fmt.Errorf("%w: %q is not a valid %v", ErrConversion, text, to)
+	}
+
+	switch to {
+	case ast.Bool:
+		switch text {
+		case "true":
+			return Value{Type: ast.Bool, Bool: true}, nil
+		case "false":
+			return Value{Type: ast.Bool, Bool: false}, nil
+		}
+	case ast.Int:
+		result, err := strconv.Atoi(text)
+		if err == nil {
+			return Value{Type: ast.Int, Int: result}, nil
+		}
+	case ast.Float:
+		result, err := strconv.ParseFloat(text, 32)
+		if err == nil {
+			return Value{Type: ast.Float, Float: float32(result)}, nil
+		}
+	case ast.Int64:
+		result, err := strconv.ParseInt(text, 10, 64)
+		if err == nil {
+			return Value{Type: ast.Int64, Int64: result}, nil
+		}
+	case ast.Float64:
+		result, err := strconv.ParseFloat(text, 64)
+		if err == nil {
+			return Value{Type: ast.Float64, Float64: result}, nil
+		}
+	case ast.BigInt:
+		result, ok := new(big.Int).SetString(text, 10)
+		if ok {
+			return Value{Type: ast.BigInt, BigInt: result}, nil
+		}
+	default:
+		for i, variant := range to.Variants() {
+			if variant == text {
+				return Value{Type: to, Int: i}, nil
+			}
+		}
+	}
+	return Value{}, fmt.Errorf("%w: %q is not a valid %v", ErrConversion, text, to)
+}
@@ -2,16 +2,143 @@ package values
 
 import (
 	"fmt"
+	"math/big"
+	"sort"
 	"xml-programming/internal/ast"
 )
 
 type Value struct {
 	Type ast.Type
 
-	String string
-	Bool bool
-	Int int
-	Float float32
+	String  string
+	Bool    bool
+	Int     int
+	Float   float32
+	Int64   int64
+	Float64 float64
+	// never modified, operations create new ones
+	BigInt *big.Int
+	Array  *Array
+	Map    *Map
+	Record *Record
+	// nil for none
+	Optional *Value
+	// nil for a function variable that wasn't assigned yet
+	Function Function
+}
+
+// Function is a function value; each engine has its own representation of what it calls.
+type Function interface {
+	FunctionName() string
+}
+
+// arrays are shared on assignment, so they can be modified by functions they are passed to
+type Array struct {
+	Elements []Value
+}
+
+func NewArray(elemType ast.Type, elements []Value) Value {
+	return Value{
+		Type: ast.ArrayOf(elemType),
+		Array: &Array{
+			Elements: elements,
+		},
+	}
+}
+
+// maps are shared on assignment just like arrays; keys are always primitive values
+type Map struct {
+	Entries map[Value]Value
+}
+
+func NewMap(keyType ast.Type, valueType ast.Type, entries map[Value]Value) Value {
+	if entries == nil {
+		entries = map[Value]Value{}
+	}
+	return Value{
+		Type: ast.MapOf(keyType, valueType),
+		Map: &Map{
+			Entries: entries,
+		},
+	}
+}
+
+func Less(a Value, b Value) bool {
+	if a.Type.Kind() == ast.EnumKind {
+		return a.Int < b.Int
+	}
+	switch a.Type {
+	case ast.String:
+		return a.String < b.String
+	case ast.Bool:
+		return !a.Bool && b.Bool
+	case ast.Int:
+		return a.Int < b.Int
+	case ast.Float:
+		return a.Float < b.Float
+	case ast.Int64:
+		return a.Int64 < b.Int64
+	case ast.Float64:
+		return a.Float64 < b.Float64
+	case ast.BigInt:
+		return a.BigInt.Cmp(b.BigInt) < 0
+	default:
+		return false
+	}
+}
+
+// Keys returns the keys in ascending order, so iterating over maps is deterministic.
+func (m *Map) Keys() []Value {
+	keys := make([]Value, 0, len(m.Entries))
+	for key := range m.Entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return Less(keys[i], keys[j])
+	})
+	return keys
+}
+
+// Records are shared on assignment just like arrays and maps. A record variable that wasn't assigned yet is nil.
+type Record struct {
+	// shared with the declaration, in declaration order
+	Names  []string
+	Fields []Value
+}
+
+func (r *Record) FieldIndex(name string) int {
+	for i, fieldName := range r.Names {
+		if fieldName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func Some(value Value) Value {
+	return Value{
+		Type:     ast.OptionalOf(value.Type),
+		Optional: &value,
+	}
+}
+
+// Zero returns the value a variable of the given type starts out with.
+func Zero(_type ast.Type) Value {
+	if _type.IsArray() {
+		return NewArray(_type.Elem(), nil)
+	}
+	if _type.IsMap() {
+		return NewMap(_type.Key(), _type.Elem(), nil)
+	}
+	if _type == ast.BigInt {
+		return Value{
+			Type:   _type,
+			BigInt: new(big.Int),
+		}
+	}
+	return Value{
+		Type: _type,
+	}
 }
 
 func FromLiteralExpression(expression ast.LiteralExpression) Value {
@@ -28,9 +155,15 @@ func FromLiteralExpression(expression ast.LiteralExpression) Value {
 		value.Bool = expression.Bool
 	case ast.Float:
 		value.Float = expression.Float
+	case ast.Int64:
+		value.Int64 = expression.Int64
+	case ast.Float64:
+		value.Float64 = expression.Float64
+	case ast.BigInt:
+		value.BigInt = expression.BigInt
 	default:
 		fmt.Println("unknown type: ", expression.Type)
 	}
 
 	return value
-}
\ No newline at end of file
+}
@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"fmt"
+	"unicode/utf8"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+func (m *machine) makeArray(node ast.Spanned, elemType ast.Type, elements []values.Value) (values.Value, error) {
+	err := m.budget.allocate(len(elements) * valueSize)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
+	}
+
+	if elemType == ast.Void {
+		// analysis made sure there is at least one element
+		elemType = elements[0].Type
+	}
+	copied := make([]values.Value, len(elements))
+	copy(copied, elements)
+	return values.NewArray(elemType, copied), nil
+}
+
+func (m *machine) checkIndex(node ast.Spanned, array values.Value, index values.Value) error {
+	if index.Int < 0 || index.Int >= len(array.Array.Elements) {
+		return m.fail(node, fmt.Errorf("%w: index %d with length %d", ErrIndexOutOfBounds, index.Int, len(array.Array.Elements)))
+	}
+	return nil
+}
+
+func (m *machine) indexArray(node ast.Spanned, array values.Value, index values.Value) (values.Value, error) {
+	err := m.checkIndex(node, array, index)
+	if err != nil {
+		return values.Value{}, err
+	}
+	return array.Array.Elements[index.Int], nil
+}
+
+func (m *machine) setIndex(node ast.Spanned, array values.Value, index values.Value, value values.Value) error {
+	err := m.checkIndex(node, array, index)
+	if err != nil {
+		return err
+	}
+	array.Array.Elements[index.Int] = value
+	return nil
+}
+
+func (m *machine) appendArray(node ast.Spanned, array values.Value, elements []values.Value) error {
+	err := m.budget.allocate(len(elements) * valueSize)
+	if err != nil {
+		return m.fail(node, err)
+	}
+	array.Array.Elements = append(array.Array.Elements, elements...)
+	return nil
+}
+
+// length counts the characters of strings, like the functions of the standard library
+func length(value values.Value) values.Value {
+	result := values.Value{
+		Type: ast.Int,
+	}
+	switch {
+	case value.Type == ast.String:
+		result.Int = utf8.RuneCountInString(value.String)
+	case value.Type.IsMap():
+		result.Int = len(value.Map.Entries)
+	default:
+		result.Int = len(value.Array.Elements)
+	}
+	return result
+}
@@ -0,0 +1,480 @@
+package vm
+
+import (
+	"fmt"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/bytecode"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/values"
+)
+
+type frame struct {
+	locals []values.Value
+	// variables stored in cells by their slot, see bytecode.Function.Scopes
+	cells []*values.Value
+	// errors caught by a <try>, by the slot their value is stored in, so they can be rethrown unchanged
+	caught map[int]error
+	// the frame of the function the current function was declared in
+	parent *frame
+}
+
+// closure is a function value of the bytecode
+type closure struct {
+	function *bytecode.Function
+	env      *frame
+}
+
+func (c *closure) FunctionName() string {
+	return c.function.Name
+}
+
+// capture copies the frames a closure can see, so that it keeps the cells they have now;
+// all other variables are still shared with the original frames.
+func capture(env *frame) *frame {
+	if env == nil {
+		return nil
+	}
+	return &frame{
+		locals: env.locals,
+		cells:  append([]*values.Value(nil), env.cells...),
+		parent: capture(env.parent),
+	}
+}
+
+func outerFrame(current *frame, levels int) *frame {
+	for i := 0; i < levels; i++ {
+		current = current.parent
+	}
+	return current
+}
+
+// handler is an installed <try>
+type handler struct {
+	activations int
+	stack       int
+	function    *bytecode.Function
+	frame       *frame
+	pc          int
+	slot        int
+}
+
+type activation struct {
+	function *bytecode.Function
+	pc       int
+	frame    *frame
+}
+
+func (m *machine) execute(program *bytecode.Program) error {
+	stack := make([]values.Value, 0, 64)
+	var activations []activation
+	var handlers []handler
+
+	function := program.Main
+	code := function.Code
+	pc := 0
+	current := &frame{
+		locals: make([]values.Value, function.NumLocals),
+	}
+
+	pop := func() values.Value {
+		value := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return value
+	}
+
+	// call enters a function of the bytecode, the arguments are copied into its frame
+	call := func(callee *bytecode.Function, env *frame, args []values.Value) error {
+		err := m.enterCall(function.Nodes[pc-1], callee.Name, len(args))
+		if err != nil {
+			return err
+		}
+
+		calleeFrame := &frame{
+			locals: make([]values.Value, callee.NumLocals),
+			parent: env,
+		}
+		copy(calleeFrame.locals, args)
+
+		activations = append(activations, activation{
+			function: function,
+			pc:       pc,
+			frame:    current,
+		})
+		function = callee
+		code = callee.Code
+		pc = 0
+		current = calleeFrame
+		return nil
+	}
+
+	// run executes until the program ends or fails; a failure that a <try> catches continues at its handler
+	run := func() error {
+		for pc < len(code) {
+			instruction := code[pc]
+			pc++
+
+			switch instruction.Op {
+			case bytecode.OpConst:
+				stack = append(stack, program.Constants[instruction.A])
+			case bytecode.OpDeclare:
+				err := m.budget.allocate(valueSize)
+				if err != nil {
+					return m.fail(function.Nodes[pc-1], err)
+				}
+				current.locals[instruction.A] = values.Zero(ast.Type(instruction.B))
+			case bytecode.OpLoad:
+				stack = append(stack, current.locals[instruction.A])
+			case bytecode.OpStore:
+				// pop isn't inlined, which matters for the most common instructions
+				current.locals[instruction.A] = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			case bytecode.OpLoadOuter:
+				stack = append(stack, outerFrame(current, instruction.B).locals[instruction.A])
+			case bytecode.OpStoreOuter:
+				outerFrame(current, instruction.B).locals[instruction.A] = pop()
+			case bytecode.OpEnterScope:
+				if current.cells == nil {
+					current.cells = make([]*values.Value, function.NumLocals)
+				}
+				for _, slot := range function.Scopes[instruction.A] {
+					current.cells[slot] = &values.Value{}
+				}
+			case bytecode.OpDeclareCell:
+				err := m.budget.allocate(valueSize)
+				if err != nil {
+					return m.fail(function.Nodes[pc-1], err)
+				}
+				*current.cells[instruction.A] = values.Zero(ast.Type(instruction.B))
+			case bytecode.OpLoadCell:
+				stack = append(stack, *outerFrame(current, instruction.B).cells[instruction.A])
+			case bytecode.OpStoreCell:
+				*outerFrame(current, instruction.B).cells[instruction.A] = pop()
+			case bytecode.OpOperator:
+				if instruction.B == 2 {
+					a, b := &stack[len(stack)-2], &stack[len(stack)-1]
+					if a.Type == ast.Int && b.Type == ast.Int {
+						result, ok := intOperator(ast.Operator(instruction.A), a.Int, b.Int)
+						if ok {
+							stack = stack[:len(stack)-1]
+							stack[len(stack)-1] = result
+							break
+						}
+					}
+				}
+				args := stack[len(stack)-instruction.B:]
+				result, err := m.operate(function.Nodes[pc-1], ast.Operator(instruction.A), args)
+				if err != nil {
+					return err
+				}
+				stack = append(stack[:len(stack)-instruction.B], result)
+			case bytecode.OpJump:
+				pc = instruction.A
+			case bytecode.OpJumpIfFalse:
+				condition := stack[len(stack)-1].Bool
+				stack = stack[:len(stack)-1]
+				if !condition {
+					pc = instruction.A
+				}
+			case bytecode.OpCall:
+				callee := program.Functions[instruction.A]
+				err := call(callee, outerFrame(current, instruction.B), stack[len(stack)-callee.NumArgs:])
+				if err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-callee.NumArgs]
+			case bytecode.OpClosure:
+				callee := program.Functions[instruction.A]
+				stack = append(stack, values.Value{
+					Type: callee.Type,
+					Function: &closure{
+						function: callee,
+						env:      capture(outerFrame(current, instruction.B)),
+					},
+				})
+			case bytecode.OpCast:
+				result, err := m.cast(function.Nodes[pc-1], pop(), ast.Type(instruction.A))
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpNative:
+				native := program.Natives[instruction.A]
+				stack = append(stack, values.Value{
+					Type:     native.Type(),
+					Function: native,
+				})
+			case bytecode.OpCallValue:
+				node := function.Nodes[pc-1]
+				args := stack[len(stack)-instruction.A:]
+				callee := stack[len(stack)-instruction.A-1]
+				switch f := callee.Function.(type) {
+				case nil:
+					return m.fail(node, ErrUninitialisedFunction)
+				case *closure:
+					err := call(f.function, f.env, args)
+					if err != nil {
+						return err
+					}
+					stack = stack[:len(stack)-instruction.A-1]
+				case *scope.Function:
+					args = append([]values.Value(nil), args...)
+					stack = stack[:len(stack)-instruction.A-1]
+					result, err := m.callNative(node, f, args)
+					if err != nil {
+						return err
+					}
+					stack = append(stack, result)
+				default:
+					return m.fail(node, fmt.Errorf("%w: function value of another engine", ErrInternal))
+				}
+			case bytecode.OpCallNative:
+				native := program.Natives[instruction.A]
+				args := make([]values.Value, instruction.B)
+				copy(args, stack[len(stack)-instruction.B:])
+				stack = stack[:len(stack)-instruction.B]
+
+				result, err := m.callNative(function.Nodes[pc-1], native, args)
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpReturn, bytecode.OpReturnVoid:
+				result := values.Value{
+					Type: ast.Void,
+				}
+				if instruction.Op == bytecode.OpReturn {
+					result = pop()
+				}
+				m.leaveCall(function.Name)
+
+				caller := activations[len(activations)-1]
+				activations = activations[:len(activations)-1]
+				function = caller.function
+				code = function.Code
+				pc = caller.pc
+				current = caller.frame
+
+				stack = append(stack, result)
+			case bytecode.OpPop:
+				stack = stack[:len(stack)-1]
+			case bytecode.OpOutput:
+				err := m.writeOutput(function.Nodes[pc-1], stack[len(stack)-instruction.A:])
+				if err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-instruction.A]
+			case bytecode.OpInput:
+				result, err := m.readInput(function.Nodes[pc-1])
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpTick:
+				var err error
+				if instruction.A == 1 {
+					err = m.beforeStatement(function.Nodes[pc-1])
+				} else {
+					err = m.tick(function.Nodes[pc-1])
+				}
+				if err != nil {
+					return err
+				}
+			case bytecode.OpArray:
+				result, err := m.makeArray(function.Nodes[pc-1], ast.Type(instruction.A), stack[len(stack)-instruction.B:])
+				if err != nil {
+					return err
+				}
+				stack = append(stack[:len(stack)-instruction.B], result)
+			case bytecode.OpIndex:
+				index := pop()
+				array := pop()
+				result, err := m.indexArray(function.Nodes[pc-1], array, index)
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpLength:
+				stack = append(stack, length(pop()))
+			case bytecode.OpAppend:
+				elements := stack[len(stack)-instruction.A:]
+				array := stack[len(stack)-instruction.A-1]
+				err := m.appendArray(function.Nodes[pc-1], array, elements)
+				if err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-instruction.A-1]
+			case bytecode.OpSetIndex:
+				value := pop()
+				index := pop()
+				array := pop()
+				err := m.setIndex(function.Nodes[pc-1], array, index, value)
+				if err != nil {
+					return err
+				}
+			case bytecode.OpMap:
+				entries := stack[len(stack)-2*instruction.B:]
+				result, err := m.makeMap(function.Nodes[pc-1], ast.Type(instruction.A), entries)
+				if err != nil {
+					return err
+				}
+				stack = append(stack[:len(stack)-2*instruction.B], result)
+			case bytecode.OpGet:
+				key := pop()
+				mapValue := pop()
+				result, err := m.getKey(function.Nodes[pc-1], mapValue, key)
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpHas:
+				key := pop()
+				mapValue := pop()
+				stack = append(stack, hasKey(mapValue, key))
+			case bytecode.OpKeys:
+				result, err := m.keys(function.Nodes[pc-1], pop())
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpPut:
+				value := pop()
+				key := pop()
+				mapValue := pop()
+				err := m.putKey(function.Nodes[pc-1], mapValue, key, value)
+				if err != nil {
+					return err
+				}
+			case bytecode.OpDelete:
+				key := pop()
+				mapValue := pop()
+				deleteKey(mapValue, key)
+			case bytecode.OpIterate:
+				array, mapValues, err := m.iterate(function.Nodes[pc-1], pop(), instruction.A == 1)
+				if err != nil {
+					return err
+				}
+				stack = append(stack, array)
+				if instruction.A == 1 {
+					stack = append(stack, mapValues)
+				}
+			case bytecode.OpMatch:
+				value := pop()
+				pc = function.Tables[instruction.A].Targets[value.Int]
+			case bytecode.OpSome:
+				result, err := m.some(function.Nodes[pc-1], pop())
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpIsNone:
+				stack = append(stack, isNone(pop()))
+			case bytecode.OpUnwrapOr:
+				value := pop()
+				if value.Optional != nil {
+					stack = append(stack, *value.Optional)
+					pc = instruction.A
+				}
+			case bytecode.OpRange, bytecode.OpRangeInclusive:
+				inclusive := instruction.Op == bytecode.OpRangeInclusive
+				bounds := current.locals[instruction.B : instruction.B+4]
+				var value values.Value
+				var ok bool
+				var err error
+				if bounds[0].Type == ast.Int && bounds[1].Type == ast.Int && bounds[2].Type == ast.Int {
+					value.Type = ast.Int
+					value.Int, ok, err = intForValue(bounds[0].Int, bounds[1].Int, bounds[2].Int, bounds[3].Int, inclusive)
+					if err != nil {
+						err = m.fail(function.Nodes[pc-1], err)
+					}
+				} else {
+					value, ok, err = m.forValue(function.Nodes[pc-1], bounds[0], bounds[1], bounds[2], bounds[3].Int, inclusive)
+				}
+				if err != nil {
+					return err
+				}
+				if ok {
+					bounds[3].Int++
+					stack = append(stack, value)
+				} else {
+					pc = instruction.A
+				}
+			case bytecode.OpZero:
+				stack = append(stack, values.Zero(ast.Type(instruction.A)))
+			case bytecode.OpNew:
+				record := program.Records[instruction.A]
+				fields := stack[len(stack)-len(record.Fields):]
+				result, err := m.makeRecord(function.Nodes[pc-1], record, fields)
+				if err != nil {
+					return err
+				}
+				stack = append(stack[:len(stack)-len(record.Fields)], result)
+			case bytecode.OpGetField:
+				result, err := m.getField(function.Nodes[pc-1], pop(), program.Constants[instruction.A].String)
+				if err != nil {
+					return err
+				}
+				stack = append(stack, result)
+			case bytecode.OpSetField:
+				value := pop()
+				record := pop()
+				err := m.setField(function.Nodes[pc-1], record, program.Constants[instruction.A].String, value)
+				if err != nil {
+					return err
+				}
+			case bytecode.OpTry:
+				handlers = append(handlers, handler{
+					activations: len(activations),
+					stack:       len(stack),
+					function:    function,
+					frame:       current,
+					pc:          instruction.A,
+					slot:        instruction.B,
+				})
+			case bytecode.OpEndTry:
+				handlers = handlers[:len(handlers)-1]
+			case bytecode.OpThrow:
+				return m.throw(function.Nodes[pc-1], pop())
+			case bytecode.OpRethrow:
+				return current.caught[instruction.A]
+			case bytecode.OpCatches:
+				stack = append(stack, values.Value{
+					Type: ast.Bool,
+					Bool: catches(ast.Type(instruction.A), pop()),
+				})
+			default:
+				return m.fail(function.Nodes[pc-1], ErrNotImplemented)
+			}
+		}
+		return nil
+	}
+
+	for {
+		err := run()
+		if err == nil {
+			return nil
+		}
+		value, ok := exception(err)
+		if !ok || len(handlers) == 0 {
+			return err
+		}
+
+		h := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+		for len(activations) > h.activations {
+			m.leaveCall(function.Name)
+			function = activations[len(activations)-1].function
+			activations = activations[:len(activations)-1]
+		}
+		function = h.function
+		code = function.Code
+		pc = h.pc
+		current = h.frame
+		stack = stack[:h.stack]
+
+		current.locals[h.slot] = value
+		if current.caught == nil {
+			current.caught = map[int]error{}
+		}
+		current.caught[h.slot] = err
+	}
+}
@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+var (
+	ErrDivisionByZero        = errors.New("division by zero")
+	ErrIntegerOverflow       = errors.New("integer overflow")
+	ErrUnknownFunction       = errors.New("unknown function")
+	ErrUnknownVariable       = errors.New("unknown variable")
+	ErrArgumentCount         = errors.New("mismatched number of arguments")
+	ErrHostFunction          = errors.New("host function failed")
+	ErrLibraryFunction       = errors.New("library function failed")
+	ErrIndexOutOfBounds      = errors.New("index out of bounds")
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrInvalidKey            = errors.New("invalid map key")
+	ErrUninitialised         = errors.New("record is not initialised")
+	ErrUninitialisedFunction = errors.New("function is not initialised")
+	ErrConversion            = values.ErrConversion
+	ErrZeroStep              = errors.New("step of for loop is zero")
+	ErrNotImplemented        = errors.New("not yet implemented")
+	ErrInternal              = errors.New("internal error")
+)
+
+type Frame struct {
+	Function string
+	CallSite ast.Span
+}
+
+type RuntimeError struct {
+	Node  ast.Spanned
+	Stack []Frame
+	Cause error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Node == nil {
+		return fmt.Sprintf("runtime error: %v", e.Cause)
+	}
+	return fmt.Sprintf("%v: runtime error: %v", e.Node.GetSpan(), e.Cause)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *RuntimeError) StackTrace() string {
+	// deep recursion would otherwise print thousands of identical lines
+	const shownFrames = 10
+
+	builder := strings.Builder{}
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		if len(e.Stack) > 3*shownFrames && i == len(e.Stack)-1-shownFrames {
+			fmt.Fprintf(&builder, "\t... %d more frames ...\n", len(e.Stack)-2*shownFrames)
+			i = shownFrames - 1
+		}
+		frame := e.Stack[i]
+		fmt.Fprintf(&builder, "\tat %s (called from %v)\n", frame.Function, frame.CallSite)
+	}
+	return builder.String()
+}
+
+func (m *machine) fail(node ast.Spanned, cause error) error {
+	var runtimeError *RuntimeError
+	if errors.As(cause, &runtimeError) {
+		return cause
+	}
+
+	stack := make([]Frame, len(m.callStack))
+	copy(stack, m.callStack)
+
+	return &RuntimeError{
+		Node:  node,
+		Stack: stack,
+		Cause: cause,
+	}
+}
@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+// Thrown is the cause of the RuntimeError for a value from <throw> that wasn't caught.
+type Thrown struct {
+	Value values.Value
+}
+
+func (t *Thrown) Error() string {
+	if t.Value.Type == ast.ErrorType && t.Value.Record != nil {
+		return "uncaught exception: " + t.Value.Record.Fields[0].String
+	}
+	return "uncaught exception: " + formatValue(t.Value)
+}
+
+// runtime errors that can be caught as error values; exceeded limits and cancellation can't be caught
+var catchableErrors = []error{
+	ErrConversion,
+	ErrDivisionByZero,
+	ErrHostFunction,
+	ErrLibraryFunction,
+	ErrIndexOutOfBounds,
+	ErrIntegerOverflow,
+	ErrInvalidKey,
+	ErrKeyNotFound,
+	ErrUninitialised,
+	ErrUninitialisedFunction,
+	ErrZeroStep,
+}
+
+var errorFieldNames = []string{"message", "trace"}
+
+func (m *machine) throw(node ast.Spanned, value values.Value) error {
+	return m.fail(node, &Thrown{
+		Value: value,
+	})
+}
+
+// exception returns the value a <catch> gets for err, or false if err can't be caught
+func exception(err error) (values.Value, bool) {
+	var runtimeError *RuntimeError
+	if !errors.As(err, &runtimeError) {
+		return values.Value{}, false
+	}
+
+	var thrown *Thrown
+	if errors.As(runtimeError.Cause, &thrown) {
+		return thrown.Value, true
+	}
+
+	for _, catchable := range catchableErrors {
+		if errors.Is(runtimeError.Cause, catchable) {
+			return errorValue(runtimeError), true
+		}
+	}
+	return values.Value{}, false
+}
+
+func errorValue(runtimeError *RuntimeError) values.Value {
+	trace := strings.Builder{}
+	if runtimeError.Node != nil {
+		trace.WriteString(runtimeError.Node.GetSpan().String())
+		trace.WriteString("\n")
+	}
+	trace.WriteString(runtimeError.StackTrace())
+
+	return values.Value{
+		Type: ast.ErrorType,
+		Record: &values.Record{
+			Names: errorFieldNames,
+			Fields: []values.Value{
+				{Type: ast.String, String: runtimeError.Cause.Error()},
+				{Type: ast.String, String: strings.TrimSuffix(trace.String(), "\n")},
+			},
+		},
+	}
+}
+
+// catches returns whether a <catch> of the type handles the value
+func catches(_type ast.Type, value values.Value) bool {
+	return value.Type.AssignableTo(_type)
+}
@@ -2,392 +2,316 @@ package vm
 
 import (
 	"fmt"
-	"strconv"
+	"io"
 	"strings"
 	"xml-programming/internal/ast"
 	"xml-programming/internal/scope"
 	"xml-programming/internal/values"
 )
 
-func evaluateAddExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	isFloat := false
-
-	var args []values.Value
-
-	for _, _arg := range expression.Exprs {
-		arg := evaluateExpression(_arg, localScope)
-		if arg.Type == ast.Float {
-			isFloat = true
-		}
-		args = append(args, arg)
+func concatValues(args []values.Value) values.Value {
+	builder := strings.Builder{}
+	for _, arg := range args {
+		builder.WriteString(values.ToString(arg))
 	}
 
-	if isFloat {
-		var sum float32 = 0
-		for _, arg := range args {
-			if arg.Type == ast.Int {
-				sum += float32(arg.Int)
-			} else {
-				sum += arg.Float
-			}
-		}
-		return values.Value{
-			Type:  ast.Float,
-			Float: sum,
-		}
-	} else {
-		var sum int = 0
-		for _, arg := range args {
-			// all ints
-			sum += arg.Int
-		}
-		return values.Value{
-			Type: ast.Int,
-			Int:  sum,
-		}
+	return values.Value{
+		Type:   ast.String,
+		String: builder.String(),
 	}
 }
 
-func evaluateSubExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	isFloat := false
-
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	if arg1.Type == ast.Float {
-		isFloat = true
+// equalValues compares values of the same type, after numbers have been promoted
+func equalValues(arg1, arg2 values.Value) values.Value {
+	var equal bool
+	switch arg1.Type {
+	case ast.String:
+		equal = arg1.String == arg2.String
+	case ast.Bool:
+		equal = arg1.Bool == arg2.Bool
+	case ast.Float:
+		equal = arg1.Float == arg2.Float
+	case ast.Float64:
+		equal = arg1.Float64 == arg2.Float64
+	case ast.Int64:
+		equal = arg1.Int64 == arg2.Int64
+	case ast.BigInt:
+		equal = arg1.BigInt.Cmp(arg2.BigInt) == 0
+	default:
+		// ints and enums
+		equal = arg1.Int == arg2.Int
 	}
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-	if arg2.Type == ast.Float {
-		isFloat = true
+	return values.Value{
+		Type: ast.Bool,
+		Bool: equal,
 	}
+}
 
-	if isFloat {
-		var result float32
-
-		if arg1.Type == ast.Int {
-			result = float32(arg1.Int)
-		} else {
-			result = arg1.Float
-		}
-
-		if arg2.Type == ast.Int {
-			result -= float32(arg1.Int)
-		} else {
-			result -= arg2.Float
-		}
-
+func applyOperator(operator ast.Operator, args []values.Value) (values.Value, error) {
+	switch operator {
+	case ast.Add, ast.Sub, ast.Mul, ast.Div, ast.Mod:
+		return arithmeticValues(operator, values.Promote(args))
+	case ast.Concat:
+		return concatValues(args), nil
+	case ast.Equal:
+		args = values.Promote(args)
+		return equalValues(args[0], args[1]), nil
+	case ast.GreaterThan:
+		args = values.Promote(args)
 		return values.Value{
-			Type:  ast.Float,
-			Float: result,
-		}
-	} else {
-		var result int = arg1.Int
-		result -= arg2.Int
-
+			Type: ast.Bool,
+			Bool: values.Less(args[1], args[0]),
+		}, nil
+	case ast.LessThan:
+		args = values.Promote(args)
 		return values.Value{
-			Type: ast.Int,
-			Int:  result,
-		}
+			Type: ast.Bool,
+			Bool: values.Less(args[0], args[1]),
+		}, nil
+	case ast.Not:
+		return values.Value{
+			Type: ast.Bool,
+			Bool: !args[0].Bool,
+		}, nil
+	default:
+		return values.Value{}, fmt.Errorf("%w: operator %v", ErrNotImplemented, operator)
 	}
 }
 
-func evaluateMulExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	isFloat := false
-
-	var args []values.Value
-
-	for _, _arg := range expression.Exprs {
-		arg := evaluateExpression(_arg, localScope)
-		if arg.Type == ast.Float {
-			isFloat = true
-		}
-		args = append(args, arg)
+func (m *machine) operate(node ast.Spanned, operator ast.Operator, args []values.Value) (values.Value, error) {
+	result, err := applyOperator(operator, args)
+	if err == nil && result.Type == ast.String {
+		err = m.budget.allocateString(result.String)
+	} else if err == nil {
+		err = m.budget.allocate(numberSize(result))
 	}
-
-	if isFloat {
-		var product float32 = 1
-		for _, arg := range args {
-			if arg.Type == ast.Int {
-				product *= float32(arg.Int)
-			} else {
-				product *= arg.Float
-			}
-		}
-		return values.Value{
-			Type:  ast.Float,
-			Float: product,
-		}
-	} else {
-		var product int = 1
-		for _, arg := range args {
-			// all ints
-			product *= arg.Int
-		}
-		return values.Value{
-			Type: ast.Int,
-			Int:  product,
-		}
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
 	}
+	return result, nil
 }
 
-func evaluateDivExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	isFloat := false
-
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	if arg1.Type == ast.Float {
-		isFloat = true
-	}
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-	if arg2.Type == ast.Float {
-		isFloat = true
+func (m *machine) cast(node ast.Spanned, value values.Value, to ast.Type) (values.Value, error) {
+	result, err := values.Cast(value, to)
+	if err == nil && result.Type == ast.String && value.Type != ast.String {
+		err = m.budget.allocateString(result.String)
+	} else if err == nil && value.Type != ast.BigInt {
+		err = m.budget.allocate(numberSize(result))
 	}
-
-	if isFloat {
-		var result float32
-
-		if arg1.Type == ast.Int {
-			result = float32(arg1.Int)
-		} else {
-			result = arg1.Float
-		}
-
-		if arg2.Type == ast.Int {
-			result /= float32(arg1.Int)
-		} else {
-			result /= arg2.Float
-		}
-
-		return values.Value{
-			Type:  ast.Float,
-			Float: result,
-		}
-	} else {
-		var result int = arg1.Int
-		result /= arg2.Int
-
-		return values.Value{
-			Type: ast.Int,
-			Int:  result,
-		}
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
 	}
+	return result, nil
 }
 
-func evaluateModExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-
-	var result int = arg1.Int
-	result %= arg2.Int
-
-	return values.Value{
-		Type: ast.Int,
-		Int:  result,
+func (m *machine) some(node ast.Spanned, value values.Value) (values.Value, error) {
+	err := m.budget.allocate(valueSize)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
 	}
+	return values.Some(value), nil
 }
 
-func evaluateConcatExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	builder := strings.Builder{}
-
-	for _, _arg := range expression.Exprs {
-		arg := evaluateExpression(_arg, localScope)
-		switch arg.Type {
-		case ast.String:
-			builder.WriteString(arg.String)
-		case ast.Bool:
-			builder.WriteString(strconv.FormatBool(arg.Bool))
-		case ast.Int:
-			builder.WriteString(strconv.FormatInt(int64(arg.Int), 10))
-		case ast.Float:
-			builder.WriteString(strconv.FormatFloat(float64(arg.Float), 'e', 4, 32))
-		}
-	}
-
+func isNone(value values.Value) values.Value {
 	return values.Value{
-		Type: ast.String,
-		String: builder.String(),
-	}
-}
-
-func evaluateEqualExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-
-	if arg1.Type == ast.Int {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: float32(arg1.Int) == arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Int == arg2.Int,
-			}
-		}
-	} else {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float == arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float == float32(arg2.Int),
-			}
-		}
+		Type: ast.Bool,
+		Bool: value.Optional == nil,
 	}
 }
 
-func evaluateGreaterThanExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-
-	if arg1.Type == ast.Int {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: float32(arg1.Int) > arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Int > arg2.Int,
-			}
-		}
-	} else {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float > arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float > float32(arg2.Int),
-			}
-		}
+func (m *machine) readInput(node ast.Spanned) (values.Value, error) {
+	line, err := m.input.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return values.Value{}, m.fail(node, err)
 	}
-}
-
-func evaluateLessThanExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	arg1 := evaluateExpression(expression.Exprs[0], localScope)
-	arg2 := evaluateExpression(expression.Exprs[1], localScope)
-
-	if arg1.Type == ast.Int {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: float32(arg1.Int) < arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Int < arg2.Int,
-			}
-		}
-	} else {
-		if arg2.Type == ast.Float {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float < arg2.Float,
-			}
-		} else {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: arg1.Float < float32(arg2.Int),
-			}
-		}
+	line = strings.TrimRight(line, "\r\n")
+	err = m.budget.allocateString(line)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
 	}
-}
-
-func evaluateNotExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	arg := evaluateExpression(expression.Exprs[0], localScope)
-
 	return values.Value{
-		Type: ast.Bool,
-		Bool: !arg.Bool,
-	}
+		Type:   ast.String,
+		String: line,
+	}, nil
 }
 
-func evaluateAndExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
+func (m *machine) evaluateLogicExpression(expression ast.OperatorExpression, localScope *scope.Scope) (values.Value, error) {
+	// and stops at the first false, or at the first true
+	stopAt := expression.Operator == ast.Or
+
 	for _, expr := range expression.Exprs {
-		arg := evaluateExpression(expr, localScope)
-		if !arg.Bool {
+		arg, err := m.evaluateExpression(expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		if arg.Bool == stopAt {
 			return values.Value{
 				Type: ast.Bool,
-				Bool: false,
-			}
+				Bool: stopAt,
+			}, nil
 		}
 	}
 
 	return values.Value{
 		Type: ast.Bool,
-		Bool: true,
-	}
+		Bool: !stopAt,
+	}, nil
 }
 
-func evaluateOrExpression(expression ast.OperatorExpression, localScope *scope.Scope) values.Value {
-	for _, expr := range expression.Exprs {
-		arg := evaluateExpression(expr, localScope)
-		if arg.Bool {
-			return values.Value{
-				Type: ast.Bool,
-				Bool: true,
-			}
+func (m *machine) evaluateExpressions(expressions []ast.Expression, localScope *scope.Scope) ([]values.Value, error) {
+	var args []values.Value
+	for _, expr := range expressions {
+		arg, err := m.evaluateExpression(expr, localScope)
+		if err != nil {
+			return nil, err
 		}
+		args = append(args, arg)
 	}
-
-	return values.Value{
-		Type: ast.Bool,
-		Bool: false,
-	}
+	return args, nil
 }
 
-func evaluateExpression(expression ast.Expression, localScope *scope.Scope) values.Value {
+func (m *machine) evaluateExpression(expression ast.Expression, localScope *scope.Scope) (values.Value, error) {
 	switch v := expression.(type) {
 	case ast.LiteralExpression:
-		return values.FromLiteralExpression(v)
+		return values.FromLiteralExpression(v), nil
 	case ast.VariableExpression:
-		return localScope.GetVariable(v.Name).Value
+		variable := localScope.GetVariable(v.Name)
+		if variable == nil {
+			function := localScope.GetFunction(v.Name)
+			if function != nil {
+				return functionValue(function), nil
+			}
+			return values.Value{}, m.fail(v, fmt.Errorf("%w: %s", ErrUnknownVariable, v.Name))
+		}
+		return variable.Value, nil
 	case ast.OperatorExpression:
-		switch v.Operator {
-		case ast.Add:
-			return evaluateAddExpression(v, localScope)
-		case ast.Sub:
-			return evaluateSubExpression(v, localScope)
-		case ast.Mul:
-			return evaluateMulExpression(v, localScope)
-		case ast.Div:
-			return evaluateDivExpression(v, localScope)
-		case ast.Mod:
-			return evaluateModExpression(v, localScope)
-		case ast.Concat:
-			return evaluateConcatExpression(v, localScope)
-		case ast.Equal:
-			return evaluateEqualExpression(v, localScope)
-		case ast.GreaterThan:
-			return evaluateGreaterThanExpression(v, localScope)
-		case ast.LessThan:
-			return evaluateLessThanExpression(v, localScope)
-		case ast.Not:
-			return evaluateNotExpression(v, localScope)
-		case ast.And:
-			return evaluateAndExpression(v, localScope)
-		case ast.Or:
-			return evaluateOrExpression(v, localScope)
-		default:
-			fmt.Println(v)
-			panic("not yet implemented")
+		if v.Operator == ast.And || v.Operator == ast.Or {
+			return m.evaluateLogicExpression(v, localScope)
 		}
+
+		args, err := m.evaluateExpressions(v.Exprs, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.operate(v, v.Operator, args)
 	case ast.FunctionCall:
-		var args []values.Value
-		for _, arg := range v.Args {
-			args = append(args, evaluateExpression(arg, localScope))
+		return m.evaluateCall(v, localScope)
+	case ast.LambdaExpression:
+		return functionValue(&scope.Function{
+			Name:   "<lambda>",
+			Args:   v.Args,
+			Return: v.Returns,
+			Body:   v.Body,
+			Scope:  localScope,
+		}), nil
+	case ast.CastExpression:
+		value, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
 		}
-
-		return callFunction(v.Name, localScope, args)
+		return m.cast(v, value, v.Type)
+	case ast.InputExpression:
+		return m.readInput(v)
+	case ast.ArrayLiteral:
+		args, err := m.evaluateExpressions(v.Elems, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.makeArray(v, v.ElemType, args)
+	case ast.IndexExpression:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Expr, v.Index}, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.indexArray(v, args[0], args[1])
+	case ast.MapLiteral:
+		var exprs []ast.Expression
+		for _, entry := range v.Entries {
+			exprs = append(exprs, entry.Key, entry.Value)
+		}
+		args, err := m.evaluateExpressions(exprs, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.makeMap(v, ast.MapOf(v.KeyType, v.ValueType), args)
+	case ast.NoneLiteral:
+		return values.Value{
+			Type: ast.None,
+		}, nil
+	case ast.SomeExpression:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.some(v, arg)
+	case ast.IsNoneExpression:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return isNone(arg), nil
+	case ast.UnwrapOrExpression:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		if arg.Optional != nil {
+			return *arg.Optional, nil
+		}
+		return m.evaluateExpression(v.Default, localScope)
+	case ast.VariantLiteral:
+		return values.Value{
+			Type: v.Type,
+			Int:  v.Index,
+		}, nil
+	case ast.NewExpression:
+		record := m.records[v.Type]
+		fields := make([]values.Value, len(record.Fields))
+		// fields are evaluated in declaration order, just like in the bytecode
+		for i, field := range record.Fields {
+			fields[i] = values.Zero(field.Type)
+			for _, init := range v.Fields {
+				if init.Name == field.Name {
+					value, err := m.evaluateExpression(init.Expr, localScope)
+					if err != nil {
+						return values.Value{}, err
+					}
+					fields[i] = value
+				}
+			}
+		}
+		return m.makeRecord(v, record, fields)
+	case ast.GetFieldExpression:
+		record, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.getField(v, record, v.Name)
+	case ast.GetExpression:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Map, v.Key}, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.getKey(v, args[0], args[1])
+	case ast.HasExpression:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Map, v.Key}, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return hasKey(args[0], args[1]), nil
+	case ast.KeysExpression:
+		arg, err := m.evaluateExpression(v.Map, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.keys(v, arg)
+	case ast.LengthExpression:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return length(arg), nil
 	default:
-		fmt.Println(expression)
-		panic("not yet implemented")
+		return values.Value{}, m.fail(expression, ErrNotImplemented)
 	}
 }
@@ -1,28 +1,154 @@
 package vm
 
 import (
+	"fmt"
 	"xml-programming/internal/ast"
 	"xml-programming/internal/scope"
 	"xml-programming/internal/values"
 )
 
-func callFunction(name string, localScope *scope.Scope, args []values.Value) values.Value {
-	function := localScope.GetFunction(name)
-	functionScope := scope.FromParent(localScope)
+func (m *machine) enterCall(call ast.Spanned, name string, argc int) error {
+	err := m.budget.enter(len(m.callStack))
+	if err == nil {
+		err = m.budget.allocate(argc * valueSize)
+	}
+	if err != nil {
+		return m.fail(call, err)
+	}
+
+	if m.hooks.BeforeCall != nil {
+		m.hooks.BeforeCall(name, call.GetSpan())
+	}
+	m.callStack = append(m.callStack, Frame{
+		Function: name,
+		CallSite: call.GetSpan(),
+	})
+	return nil
+}
+
+func (m *machine) leaveCall(name string) {
+	m.callStack = m.callStack[:len(m.callStack)-1]
+	if m.hooks.AfterCall != nil {
+		m.hooks.AfterCall(name)
+	}
+}
+
+func (m *machine) callNative(call ast.Spanned, function *scope.Function, args []values.Value) (values.Value, error) {
+	err := m.enterCall(call, function.Name, len(args))
+	if err != nil {
+		return values.Value{}, err
+	}
+	defer m.leaveCall(function.Name)
+
+	result, err := function.Native(args)
+	if err != nil {
+		failed := ErrHostFunction
+		if function.Builtin {
+			failed = ErrLibraryFunction
+		}
+		return values.Value{}, m.fail(call, fmt.Errorf("%w: %s: %w", failed, function.Name, err))
+	}
+	if function.Signature == nil && result.Type != function.Return {
+		return values.Value{}, m.fail(call, fmt.Errorf("%w: %s returned %v, expected %v", ErrHostFunction, function.Name, result.Type, function.Return))
+	}
+	if (result.Type.IsArray() && result.Array == nil) || (result.Type.IsMap() && result.Map == nil) {
+		result = values.Zero(result.Type)
+	}
+	err = m.budget.allocateResult(result)
+	if err != nil {
+		return values.Value{}, m.fail(call, err)
+	}
+	return result, nil
+}
+
+func (m *machine) evaluateCall(call ast.FunctionCall, localScope *scope.Scope) (values.Value, error) {
+	callee := call.Callee
+	if callee == nil && localScope.GetFunction(call.Name) == nil && localScope.GetVariable(call.Name) != nil {
+		// variables holding functions are called like any other function value
+		callee = ast.VariableExpression{Node: call.Node, Name: call.Name}
+	}
+	if callee != nil {
+		callee, err := m.evaluateExpression(callee, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		args, err := m.evaluateExpressions(call.Args, localScope)
+		if err != nil {
+			return values.Value{}, err
+		}
+		return m.callValue(call, callee, args)
+	}
 
+	args, err := m.evaluateExpressions(call.Args, localScope)
+	if err != nil {
+		return values.Value{}, err
+	}
+	return m.callFunction(call, localScope, args)
+}
+
+func (m *machine) callFunction(call ast.FunctionCall, localScope *scope.Scope, args []values.Value) (values.Value, error) {
+	function := localScope.GetFunction(call.Name)
+	if function == nil {
+		return values.Value{}, m.fail(call, fmt.Errorf("%w: %s", ErrUnknownFunction, call.Name))
+	}
+	if !function.AcceptsArgumentCount(len(args)) {
+		return values.Value{}, m.fail(call, fmt.Errorf("%w for %s: expected %d, got %d", ErrArgumentCount, call.Name, len(function.Args), len(args)))
+	}
+	return m.invoke(call, function, args)
+}
+
+// callValue calls the function a function value refers to.
+func (m *machine) callValue(call ast.Spanned, callee values.Value, args []values.Value) (values.Value, error) {
+	if callee.Function == nil {
+		return values.Value{}, m.fail(call, ErrUninitialisedFunction)
+	}
+	function, ok := callee.Function.(*scope.Function)
+	if !ok {
+		return values.Value{}, m.fail(call, fmt.Errorf("%w: function value of another engine", ErrInternal))
+	}
+	if !function.AcceptsArgumentCount(len(args)) {
+		return values.Value{}, m.fail(call, fmt.Errorf("%w for %s: expected %d, got %d", ErrArgumentCount, function.Name, len(function.Args), len(args)))
+	}
+	return m.invoke(call, function, args)
+}
+
+func (m *machine) invoke(call ast.Spanned, function *scope.Function, args []values.Value) (values.Value, error) {
+	if function.Native != nil {
+		return m.callNative(call, function, args)
+	}
+
+	err := m.enterCall(call, function.Name, len(args))
+	if err != nil {
+		return values.Value{}, err
+	}
+
+	functionScope := scope.FromParent(function.Scope)
 	for i, arg := range args {
 		functionScope.AddVariable(scope.Variable{
 			Name:  function.Args[i].Name,
 			Value: arg,
 		})
 	}
+	result, err := m.executeStatements(function.Body, functionScope)
+
+	m.leaveCall(function.Name)
+	if err != nil {
+		return values.Value{}, err
+	}
 
-	result := executeStatements(function.Body, functionScope)
 	if result != nil {
-		return *result
+		return result.value, nil
 	} else {
 		return values.Value{
 			Type: ast.Void,
-		}
+		}, nil
+	}
+}
+
+// functionValue refers to a function declared in the scope, or to a lambda created in it.
+func functionValue(function *scope.Function) values.Value {
+	return values.Value{
+		Type:     function.Type(),
+		Function: function,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+var (
+	ErrStatementLimit  = errors.New("statement limit exceeded")
+	ErrCallDepthLimit  = errors.New("call depth limit exceeded")
+	ErrStringLimit     = errors.New("string length limit exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+)
+
+// recursing deeper than this would overflow the Go stack, which can't be recovered from
+const DefaultMaxCallDepth = 10000
+
+const ctxCheckInterval = 64
+
+const valueSize = int(unsafe.Sizeof(values.Value{}))
+
+// Zero means unlimited, except for MaxCallDepth which defaults to DefaultMaxCallDepth.
+type Limits struct {
+	MaxStatements     int
+	MaxCallDepth      int
+	MaxStringLength   int
+	MaxAllocatedBytes int
+}
+
+type budget struct {
+	limits Limits
+
+	statements int
+	allocated  int
+}
+
+func newBudget(limits Limits) budget {
+	if limits.MaxCallDepth <= 0 {
+		limits.MaxCallDepth = DefaultMaxCallDepth
+	}
+	return budget{
+		limits: limits,
+	}
+}
+
+func (b *budget) step() error {
+	b.statements++
+	if b.limits.MaxStatements > 0 && b.statements > b.limits.MaxStatements {
+		return fmt.Errorf("%w: executed more than %d statements", ErrStatementLimit, b.limits.MaxStatements)
+	}
+	return nil
+}
+
+// tick is called for every statement and loop iteration
+func (m *machine) tick(node ast.Spanned) error {
+	err := m.budget.step()
+	if err != nil {
+		return m.fail(node, err)
+	}
+
+	// checking the context is comparatively expensive
+	if m.budget.statements%ctxCheckInterval == 1 {
+		select {
+		case <-m.ctx.Done():
+			return m.fail(node, m.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+func (m *machine) beforeStatement(statement ast.Spanned) error {
+	m.current = statement
+	if m.hooks.BeforeStatement != nil {
+		m.hooks.BeforeStatement(statement.GetSpan())
+	}
+	return m.tick(statement)
+}
+
+func (b *budget) enter(depth int) error {
+	if depth >= b.limits.MaxCallDepth {
+		return fmt.Errorf("%w: more than %d nested calls", ErrCallDepthLimit, b.limits.MaxCallDepth)
+	}
+	return nil
+}
+
+func (b *budget) allocate(bytes int) error {
+	b.allocated += bytes
+	if b.limits.MaxAllocatedBytes > 0 && b.allocated > b.limits.MaxAllocatedBytes {
+		return fmt.Errorf("%w: allocated more than %d bytes", ErrAllocationLimit, b.limits.MaxAllocatedBytes)
+	}
+	return nil
+}
+
+func (b *budget) allocateString(str string) error {
+	if b.limits.MaxStringLength > 0 && len(str) > b.limits.MaxStringLength {
+		return fmt.Errorf("%w: string of length %d is longer than %d", ErrStringLimit, len(str), b.limits.MaxStringLength)
+	}
+	return b.allocate(len(str))
+}
+
+// allocateResult charges what native functions return, since they can build strings and collections of any size
+func (b *budget) allocateResult(result values.Value) error {
+	switch {
+	case result.Type == ast.String:
+		return b.allocateString(result.String)
+	case result.Type.IsArray():
+		return b.allocate(len(result.Array.Elements) * valueSize)
+	case result.Type.IsMap():
+		return b.allocate(2 * len(result.Map.Entries) * valueSize)
+	default:
+		return b.allocate(numberSize(result))
+	}
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"errors"
+	"math"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+// inRange reports whether a loop variable that compares to the limit as given is still in range
+func inRange(compare int, ascending bool, inclusive bool) bool {
+	if !ascending {
+		compare = -compare
+	}
+	return compare < 0 || (inclusive && compare == 0)
+}
+
+// forValue returns the loop variable of a <for> in the nth iteration, and whether the loop still runs.
+// The variable is computed from the start instead of being accumulated, so float steps don't drift.
+func (m *machine) forValue(node ast.Spanned, from, to, step values.Value, n int, inclusive bool) (values.Value, bool, error) {
+	if from.Type == ast.Int && to.Type == ast.Int && step.Type == ast.Int {
+		value, ok, err := intForValue(from.Int, to.Int, step.Int, n, inclusive)
+		if err != nil {
+			return values.Value{}, false, m.fail(node, err)
+		}
+		return values.Value{Type: ast.Int, Int: value}, ok, nil
+	}
+
+	bounds := values.Promote([]values.Value{from, to, step, {Type: ast.Int, Int: n}})
+	from, to, step = bounds[0], bounds[1], bounds[2]
+
+	zero, _ := values.Cast(values.Value{Type: ast.Int}, step.Type)
+	if equalValues(step, zero).Bool {
+		return values.Value{}, false, m.fail(node, ErrZeroStep)
+	}
+	value, err := arithmetic(ast.Mul, bounds[3], step)
+	if err == nil {
+		value, err = arithmetic(ast.Add, from, value)
+	}
+	if errors.Is(err, ErrIntegerOverflow) {
+		// the limit fits into the type, so a variable that doesn't is past it
+		return values.Value{}, false, nil
+	}
+	if err != nil {
+		return values.Value{}, false, m.fail(node, err)
+	}
+
+	compare := 0
+	if values.Less(value, to) {
+		compare = -1
+	} else if values.Less(to, value) {
+		compare = 1
+	}
+	return value, inRange(compare, values.Less(zero, step), inclusive), nil
+}
+
+// intForValue is forValue for loops with int bounds, which most have, without promoting them
+func intForValue(from, to, step, n int, inclusive bool) (int, bool, error) {
+	if step == 0 {
+		return 0, false, ErrZeroStep
+	}
+	value, err := intArithmetic(ast.Mul, int64(n), int64(step))
+	if err == nil {
+		value, err = intArithmetic(ast.Add, int64(from), value)
+	}
+	if err != nil || value < math.MinInt || value > math.MaxInt {
+		// the limit fits into the type, so a variable that doesn't is past it
+		return 0, false, nil
+	}
+
+	compare := 0
+	if int(value) < to {
+		compare = -1
+	} else if int(value) > to {
+		compare = 1
+	}
+	return int(value), inRange(compare, step > 0, inclusive), nil
+}
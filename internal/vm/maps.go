@@ -0,0 +1,119 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+// entries alternates between keys and values
+func (m *machine) makeMap(node ast.Spanned, mapType ast.Type, entries []values.Value) (values.Value, error) {
+	err := m.budget.allocate(len(entries) * valueSize)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
+	}
+
+	// analysis made sure there is at least one entry if the types have to be inferred
+	keyType := mapType.Key()
+	if keyType == ast.Void {
+		keyType = entries[0].Type
+	}
+	valueType := mapType.Elem()
+	if valueType == ast.Void {
+		valueType = entries[1].Type
+	}
+
+	result := values.NewMap(keyType, valueType, nil)
+	for i := 0; i < len(entries); i += 2 {
+		err = checkKey(entries[i])
+		if err != nil {
+			return values.Value{}, m.fail(node, err)
+		}
+		result.Map.Entries[entries[i]] = entries[i+1]
+	}
+	return result, nil
+}
+
+// checkKey rejects NaN, since it isn't equal to itself and couldn't be found again once it's put into a map
+func checkKey(key values.Value) error {
+	if (key.Type == ast.Float && math.IsNaN(float64(key.Float))) || (key.Type == ast.Float64 && math.IsNaN(key.Float64)) {
+		return fmt.Errorf("%w: %s", ErrInvalidKey, formatKey(key))
+	}
+	return nil
+}
+
+func formatKey(key values.Value) string {
+	if key.Type == ast.String {
+		return fmt.Sprintf("%q", key.String)
+	}
+	return formatValue(key)
+}
+
+func (m *machine) getKey(node ast.Spanned, mapValue values.Value, key values.Value) (values.Value, error) {
+	value, ok := mapValue.Map.Entries[key]
+	if !ok {
+		return values.Value{}, m.fail(node, fmt.Errorf("%w: %s", ErrKeyNotFound, formatKey(key)))
+	}
+	return value, nil
+}
+
+func hasKey(mapValue values.Value, key values.Value) values.Value {
+	_, ok := mapValue.Map.Entries[key]
+	return values.Value{
+		Type: ast.Bool,
+		Bool: ok,
+	}
+}
+
+func (m *machine) putKey(node ast.Spanned, mapValue values.Value, key values.Value, value values.Value) error {
+	err := checkKey(key)
+	if err != nil {
+		return m.fail(node, err)
+	}
+	_, ok := mapValue.Map.Entries[key]
+	if !ok {
+		err := m.budget.allocate(2 * valueSize)
+		if err != nil {
+			return m.fail(node, err)
+		}
+	}
+	mapValue.Map.Entries[key] = value
+	return nil
+}
+
+func deleteKey(mapValue values.Value, key values.Value) {
+	delete(mapValue.Map.Entries, key)
+}
+
+func (m *machine) keys(node ast.Spanned, mapValue values.Value) (values.Value, error) {
+	keys := mapValue.Map.Keys()
+	err := m.budget.allocate(len(keys) * valueSize)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
+	}
+	return values.NewArray(mapValue.Type.Key(), keys), nil
+}
+
+// iterate returns the array a foreach loop goes through, and, if requested, the values of a map in the same order.
+// Arrays are iterated as they are, so elements set by the loop body are seen, while maps are iterated over a snapshot.
+func (m *machine) iterate(node ast.Spanned, container values.Value, withValues bool) (values.Value, values.Value, error) {
+	if container.Type.IsArray() {
+		return container, values.Value{}, nil
+	}
+
+	keys, err := m.keys(node, container)
+	if err != nil || !withValues {
+		return keys, values.Value{}, err
+	}
+
+	entries := make([]values.Value, len(keys.Array.Elements))
+	for i, key := range keys.Array.Elements {
+		entries[i] = container.Map.Entries[key]
+	}
+	err = m.budget.allocate(len(entries) * valueSize)
+	if err != nil {
+		return values.Value{}, values.Value{}, m.fail(node, err)
+	}
+	return keys, values.NewArray(container.Type.Elem(), entries), nil
+}
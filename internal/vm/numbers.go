@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"math"
+	"math/big"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+// arithmeticValues applies the operator from left to right, to operands that have been promoted to the same type
+func arithmeticValues(operator ast.Operator, args []values.Value) (values.Value, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		var err error
+		result, err = arithmetic(operator, result, arg)
+		if err != nil {
+			return values.Value{}, err
+		}
+	}
+	return result, nil
+}
+
+func arithmetic(operator ast.Operator, a, b values.Value) (values.Value, error) {
+	switch a.Type {
+	case ast.Int:
+		result, err := intArithmetic(operator, int64(a.Int), int64(b.Int))
+		if err == nil && (result < math.MinInt || result > math.MaxInt) {
+			err = ErrIntegerOverflow
+		}
+		return values.Value{Type: ast.Int, Int: int(result)}, err
+	case ast.Int64:
+		result, err := intArithmetic(operator, a.Int64, b.Int64)
+		return values.Value{Type: ast.Int64, Int64: result}, err
+	case ast.BigInt:
+		result, err := bigArithmetic(operator, a.BigInt, b.BigInt)
+		return values.Value{Type: ast.BigInt, BigInt: result}, err
+	case ast.Float:
+		// rounding the exact float64 result once is the same as float32 arithmetic
+		result := floatArithmetic(operator, float64(a.Float), float64(b.Float))
+		return values.Value{Type: ast.Float, Float: float32(result)}, nil
+	default:
+		return values.Value{Type: ast.Float64, Float64: floatArithmetic(operator, a.Float64, b.Float64)}, nil
+	}
+}
+
+// intOperator applies a binary operator to two ints, which is most of what programs compute, without promoting them.
+// It returns false for what it doesn't handle, including errors, which applyOperator reports then.
+func intOperator(operator ast.Operator, a, b int) (values.Value, bool) {
+	switch operator {
+	case ast.Add, ast.Sub, ast.Mul, ast.Div, ast.Mod:
+		result, err := intArithmetic(operator, int64(a), int64(b))
+		if err != nil || result < math.MinInt || result > math.MaxInt {
+			return values.Value{}, false
+		}
+		return values.Value{Type: ast.Int, Int: int(result)}, true
+	case ast.Equal:
+		return values.Value{Type: ast.Bool, Bool: a == b}, true
+	case ast.GreaterThan:
+		return values.Value{Type: ast.Bool, Bool: a > b}, true
+	case ast.LessThan:
+		return values.Value{Type: ast.Bool, Bool: a < b}, true
+	default:
+		return values.Value{}, false
+	}
+}
+
+// intArithmetic fails instead of wrapping around
+func intArithmetic(operator ast.Operator, a, b int64) (int64, error) {
+	switch operator {
+	case ast.Add:
+		if b > 0 && a > math.MaxInt64-b || b < 0 && a < math.MinInt64-b {
+			return 0, ErrIntegerOverflow
+		}
+		return a + b, nil
+	case ast.Sub:
+		if b < 0 && a > math.MaxInt64+b || b > 0 && a < math.MinInt64+b {
+			return 0, ErrIntegerOverflow
+		}
+		return a - b, nil
+	case ast.Mul:
+		if a == 0 || b == 0 {
+			return 0, nil
+		}
+		result := a * b
+		if result/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, ErrIntegerOverflow
+		}
+		return result, nil
+	case ast.Div:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, ErrIntegerOverflow
+		}
+		return a / b, nil
+	default:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a % b, nil
+	}
+}
+
+// bigArithmetic truncates division towards zero, just like the fixed width ints
+func bigArithmetic(operator ast.Operator, a, b *big.Int) (*big.Int, error) {
+	result := new(big.Int)
+	switch operator {
+	case ast.Add:
+		return result.Add(a, b), nil
+	case ast.Sub:
+		return result.Sub(a, b), nil
+	case ast.Mul:
+		return result.Mul(a, b), nil
+	}
+	if b.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+	if operator == ast.Div {
+		return result.Quo(a, b), nil
+	}
+	return result.Rem(a, b), nil
+}
+
+func floatArithmetic(operator ast.Operator, a, b float64) float64 {
+	switch operator {
+	case ast.Add:
+		return a + b
+	case ast.Sub:
+		return a - b
+	case ast.Mul:
+		return a * b
+	default:
+		return a / b
+	}
+}
+
+// numberSize is how much memory the budget counts for a number, which only grows for bigints
+func numberSize(value values.Value) int {
+	if value.Type == ast.BigInt {
+		return (value.BigInt.BitLen() + 7) / 8
+	}
+	return 0
+}
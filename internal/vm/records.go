@@ -0,0 +1,61 @@
+package vm
+
+import (
+	"fmt"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/values"
+)
+
+// fields are in declaration order
+func (m *machine) makeRecord(node ast.Spanned, record *ast.RecordDeclaration, fields []values.Value) (values.Value, error) {
+	err := m.budget.allocate(len(fields) * valueSize)
+	if err != nil {
+		return values.Value{}, m.fail(node, err)
+	}
+
+	names, ok := m.recordNames[record.Type]
+	if !ok {
+		for _, field := range record.Fields {
+			names = append(names, field.Name)
+		}
+		m.recordNames[record.Type] = names
+	}
+
+	copied := make([]values.Value, len(fields))
+	copy(copied, fields)
+	return values.Value{
+		Type: record.Type,
+		Record: &values.Record{
+			Names:  names,
+			Fields: copied,
+		},
+	}, nil
+}
+
+func (m *machine) fieldIndex(node ast.Spanned, record values.Value, name string) (int, error) {
+	if record.Record == nil {
+		return 0, m.fail(node, fmt.Errorf("%w: %v", ErrUninitialised, record.Type))
+	}
+	index := record.Record.FieldIndex(name)
+	if index < 0 {
+		return 0, m.fail(node, fmt.Errorf("%w: %v has no field %s", ErrInternal, record.Type, name))
+	}
+	return index, nil
+}
+
+func (m *machine) getField(node ast.Spanned, record values.Value, name string) (values.Value, error) {
+	index, err := m.fieldIndex(node, record, name)
+	if err != nil {
+		return values.Value{}, err
+	}
+	return record.Record.Fields[index], nil
+}
+
+func (m *machine) setField(node ast.Spanned, record values.Value, name string, value values.Value) error {
+	index, err := m.fieldIndex(node, record, name)
+	if err != nil {
+		return err
+	}
+	record.Record.Fields[index] = value
+	return nil
+}
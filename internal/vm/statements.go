@@ -2,42 +2,205 @@ package vm
 
 import (
 	"fmt"
+	"io"
+	"strings"
 	"xml-programming/internal/ast"
 	"xml-programming/internal/scope"
 	"xml-programming/internal/values"
 )
 
-func printValue(value values.Value) {
+func printValue(output io.Writer, value values.Value) error {
+	return printNested(output, value, nil)
+}
+
+// printNested prints value inside the records in printing. Records are shared, so they can contain themselves;
+// one that comes back while it is printed is written as Name{...}.
+func printNested(output io.Writer, value values.Value, printing map[*values.Record]bool) error {
+	var err error
 	switch value.Type {
 	case ast.String:
-		fmt.Print(value.String)
+		_, err = fmt.Fprint(output, value.String)
 	case ast.Int:
-		fmt.Print(value.Int)
+		_, err = fmt.Fprint(output, value.Int)
 	case ast.Float:
-		fmt.Print(value.Float)
+		_, err = fmt.Fprint(output, value.Float)
 	case ast.Bool:
-		fmt.Print(value.Bool)
+		_, err = fmt.Fprint(output, value.Bool)
+	case ast.Int64:
+		_, err = fmt.Fprint(output, value.Int64)
+	case ast.Float64:
+		_, err = fmt.Fprint(output, value.Float64)
+	case ast.BigInt:
+		_, err = fmt.Fprint(output, value.BigInt)
+	default:
+		if value.Type.IsArray() {
+			_, err = fmt.Fprint(output, "[")
+			for i, element := range value.Array.Elements {
+				if err == nil && i > 0 {
+					_, err = fmt.Fprint(output, ", ")
+				}
+				if err == nil {
+					err = printNested(output, element, printing)
+				}
+			}
+			if err == nil {
+				_, err = fmt.Fprint(output, "]")
+			}
+		} else if value.Type.IsOptional() {
+			if value.Optional == nil {
+				_, err = fmt.Fprint(output, "none")
+			} else {
+				err = printNested(output, *value.Optional, printing)
+			}
+		} else if value.Type.Kind() == ast.EnumKind {
+			_, err = fmt.Fprint(output, value.Type.Variants()[value.Int])
+		} else if value.Type.Kind() == ast.RecordKind {
+			if value.Record == nil {
+				_, err = fmt.Fprintf(output, "uninitialised %v", value.Type)
+				break
+			}
+			if printing[value.Record] {
+				_, err = fmt.Fprintf(output, "%v{...}", value.Type)
+				break
+			}
+			if printing == nil {
+				printing = map[*values.Record]bool{}
+			}
+			printing[value.Record] = true
+			defer delete(printing, value.Record)
+			_, err = fmt.Fprintf(output, "%v{", value.Type)
+			for i, field := range value.Record.Fields {
+				if err == nil && i > 0 {
+					_, err = fmt.Fprint(output, ", ")
+				}
+				if err == nil {
+					_, err = fmt.Fprintf(output, "%s: ", value.Record.Names[i])
+				}
+				if err == nil {
+					err = printNested(output, field, printing)
+				}
+			}
+			if err == nil {
+				_, err = fmt.Fprint(output, "}")
+			}
+		} else if value.Type.IsFunction() {
+			if value.Function == nil {
+				_, err = fmt.Fprintf(output, "uninitialised %v", value.Type)
+				break
+			}
+			_, err = fmt.Fprintf(output, "func %s", value.Function.FunctionName())
+		} else if value.Type.IsMap() {
+			_, err = fmt.Fprint(output, "{")
+			for i, key := range value.Map.Keys() {
+				if err == nil && i > 0 {
+					_, err = fmt.Fprint(output, ", ")
+				}
+				if err == nil {
+					err = printNested(output, key, printing)
+				}
+				if err == nil {
+					_, err = fmt.Fprint(output, ": ")
+				}
+				if err == nil {
+					err = printNested(output, value.Map.Entries[key], printing)
+				}
+			}
+			if err == nil {
+				_, err = fmt.Fprint(output, "}")
+			}
+		}
+	}
+	return err
+}
+
+func formatValue(value values.Value) string {
+	builder := strings.Builder{}
+	_ = printValue(&builder, value)
+	return builder.String()
+}
+
+func (m *machine) writeOutput(node ast.Spanned, args []values.Value) error {
+	for _, arg := range args {
+		err := printValue(m.output, arg)
+		if err != nil {
+			return m.fail(node, err)
+		}
+	}
+	_, err := fmt.Fprintln(m.output)
+	if err != nil {
+		return m.fail(node, err)
+	}
+	return nil
+}
+
+type signal int
+
+const (
+	returnSignal signal = iota
+	breakSignal
+	continueSignal
+)
+
+// control is how a statement leaves the normal flow of execution: a <return>, or a <break/> or <continue/> of a loop.
+type control struct {
+	signal signal
+	label  string
+	value  values.Value
+}
+
+// loopControl decides whether a loop stops after its body ended with result.
+// If result isn't meant for the loop, it is passed on.
+func loopControl(result *control, label string) (bool, *control) {
+	if result == nil {
+		return false, nil
+	}
+	if result.signal == returnSignal || (result.label != "" && result.label != label) {
+		return true, result
 	}
+	return result.signal == breakSignal, nil
 }
 
-func executeStatement(statement ast.Statement, localScope *scope.Scope) *values.Value {
+func (m *machine) executeStatement(statement ast.Statement, localScope *scope.Scope) (*control, error) {
+	err := m.beforeStatement(statement)
+	if err != nil {
+		return nil, err
+	}
+
 	switch v := statement.(type) {
 	case ast.OutputStatement:
-		for _, _arg := range v.Exprs {
-			arg := evaluateExpression(_arg, localScope)
-			printValue(arg)
+		args, err := m.evaluateExpressions(v.Exprs, localScope)
+		if err != nil {
+			return nil, err
+		}
+		err = m.writeOutput(v, args)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Println()
 	case ast.VariableDeclarationStatement:
+		// declarations inside loops are executed repeatedly and start over each time
+		variable := localScope.GetLocalVariable(v.Name)
+		if variable != nil {
+			variable.Value = values.Zero(v.Type)
+			break
+		}
+
+		err := m.budget.allocate(valueSize)
+		if err != nil {
+			return nil, m.fail(v, err)
+		}
 		localScope.AddVariable(scope.Variable{
 			Name:  v.Name,
-			Value: values.Value{
-				Type: v.Type,
-			},
+			Value: values.Zero(v.Type),
 		})
 	case ast.VariableAssignmentStatement:
-		arg := evaluateExpression(v.Expr, localScope)
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return nil, err
+		}
 		variable := localScope.GetVariable(v.Name)
+		if variable == nil {
+			return nil, m.fail(v, fmt.Errorf("%w: %s", ErrUnknownVariable, v.Name))
+		}
 		variable.Value = arg
 	case ast.FunctionStatement:
 		localScope.AddFunction(scope.Function{
@@ -45,67 +208,264 @@ func executeStatement(statement ast.Statement, localScope *scope.Scope) *values.
 			Args:   v.Args,
 			Return: v.Returns,
 			Body:   v.Body,
+			Scope:  localScope,
 		})
 	case ast.FunctionReturnStatement:
-		arg := evaluateExpression(v.Expr, localScope)
-		return &arg
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return nil, err
+		}
+		return &control{
+			signal: returnSignal,
+			value:  arg,
+		}, nil
 	case ast.FunctionCall:
-		var args []values.Value
-		for _, arg := range v.Args {
-			args = append(args, evaluateExpression(arg, localScope))
+		_, err := m.evaluateCall(v, localScope)
+		if err != nil {
+			return nil, err
 		}
-
-		_ = callFunction(v.Name, localScope, args)
 	case ast.ConditionalStatement:
 		for _, _if := range v.Ifs {
-			arg := evaluateExpression(_if.Expr, localScope)
+			arg, err := m.evaluateExpression(_if.Expr, localScope)
+			if err != nil {
+				return nil, err
+			}
 			if arg.Bool {
-				return executeStatements(_if.Then, localScope)
+				return m.executeStatements(_if.Then, localScope)
 			}
 		}
-		return executeStatements(v.Else, localScope)
+		return m.executeStatements(v.Else, localScope)
 	case ast.LoopStatement:
 		for {
-			arg := evaluateExpression(v.LoopCondition, localScope)
+			err := m.tick(v)
+			if err != nil {
+				return nil, err
+			}
+
+			arg, err := m.evaluateExpression(v.LoopCondition, localScope)
+			if err != nil {
+				return nil, err
+			}
 			if !arg.Bool {
 				break
 			}
 
-			result := executeStatements(v.Body, localScope)
-			if result != nil {
-				return result
+			result, err := m.executeStatements(v.Body, localScope)
+			if err != nil {
+				return nil, err
+			}
+			if stop, result := loopControl(result, v.Label); stop {
+				return result, nil
 			}
 		}
 	case ast.ForStatement:
-		for i := v.From; i < v.To; i++ {
+		bounds, err := m.evaluateExpressions([]ast.Expression{v.From, v.To}, localScope)
+		if err != nil {
+			return nil, err
+		}
+		step := values.Value{
+			Type: ast.Int,
+			Int:  1,
+		}
+		if v.Step != nil {
+			step, err = m.evaluateExpression(v.Step, localScope)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for n := 0; ; n++ {
+			value, ok, err := m.forValue(v, bounds[0], bounds[1], step, n, v.Inclusive)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			err = m.tick(v)
+			if err != nil {
+				return nil, err
+			}
+
+			forScope := scope.FromParent(localScope)
+			forScope.AddVariable(scope.Variable{
+				Name:  v.Name,
+				Value: value,
+			})
+			result, err := m.executeStatements(v.Body, forScope)
+			if err != nil {
+				return nil, err
+			}
+			if stop, result := loopControl(result, v.Label); stop {
+				return result, nil
+			}
+		}
+	case ast.ForEachStatement:
+		container, err := m.evaluateExpression(v.In, localScope)
+		if err != nil {
+			return nil, err
+		}
+		array, mapValues, err := m.iterate(v, container, v.ValueName != "")
+		if err != nil {
+			return nil, err
+		}
+
+		// elements appended by the body are not iterated over
+		count := len(array.Array.Elements)
+		for i := 0; i < count; i++ {
+			err := m.tick(v)
+			if err != nil {
+				return nil, err
+			}
+
 			forScope := scope.FromParent(localScope)
 			forScope.AddVariable(scope.Variable{
 				Name:  v.Name,
-				Value: values.Value{
-					Type: ast.Int,
-					Int: i,
-				},
+				Value: array.Array.Elements[i],
 			})
-			result := executeStatements(v.Body, forScope)
-			if result != nil {
-				return result
+			if v.ValueName != "" {
+				forScope.AddVariable(scope.Variable{
+					Name:  v.ValueName,
+					Value: mapValues.Array.Elements[i],
+				})
 			}
+			result, err := m.executeStatements(v.Body, forScope)
+			if err != nil {
+				return nil, err
+			}
+			if stop, result := loopControl(result, v.Label); stop {
+				return result, nil
+			}
+		}
+	case ast.ThrowStatement:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return nil, err
+		}
+		return nil, m.throw(v, arg)
+	case ast.TryStatement:
+		return m.executeTry(v, localScope)
+	case ast.BreakStatement:
+		return &control{
+			signal: breakSignal,
+			label:  v.Label,
+		}, nil
+	case ast.ContinueStatement:
+		return &control{
+			signal: continueSignal,
+			label:  v.Label,
+		}, nil
+	case ast.AppendStatement:
+		array, err := m.evaluateExpression(v.Array, localScope)
+		if err != nil {
+			return nil, err
+		}
+		args, err := m.evaluateExpressions(v.Values, localScope)
+		if err != nil {
+			return nil, err
+		}
+		err = m.appendArray(v, array, args)
+		if err != nil {
+			return nil, err
 		}
+	case ast.SetIndexStatement:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Array, v.Index, v.Value}, localScope)
+		if err != nil {
+			return nil, err
+		}
+		err = m.setIndex(v, args[0], args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+	case ast.MatchStatement:
+		arg, err := m.evaluateExpression(v.Expr, localScope)
+		if err != nil {
+			return nil, err
+		}
+		variant := arg.Type.Variants()[arg.Int]
+		for _, _case := range v.Cases {
+			if _case.Variant == variant {
+				return m.executeStatements(_case.Body, localScope)
+			}
+		}
+		return m.executeStatements(v.Default, localScope)
+	case ast.SetFieldStatement:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Record, v.Value}, localScope)
+		if err != nil {
+			return nil, err
+		}
+		err = m.setField(v, args[0], v.Name, args[1])
+		if err != nil {
+			return nil, err
+		}
+	case ast.PutStatement:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Map, v.Key, v.Value}, localScope)
+		if err != nil {
+			return nil, err
+		}
+		err = m.putKey(v, args[0], args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+	case ast.DeleteStatement:
+		args, err := m.evaluateExpressions([]ast.Expression{v.Map, v.Key}, localScope)
+		if err != nil {
+			return nil, err
+		}
+		deleteKey(args[0], args[1])
 	default:
-		fmt.Println(v)
-		panic("not yet implemented")
+		return nil, m.fail(statement, ErrNotImplemented)
 	}
 
-	return nil
+	return nil, nil
 }
 
-func executeStatements(statements []ast.Statement, localScope *scope.Scope) *values.Value {
+func (m *machine) executeTry(statement ast.TryStatement, localScope *scope.Scope) (*control, error) {
+	result, err := m.executeStatements(statement.Body, localScope)
+	if err != nil {
+		value, ok := exception(err)
+		if !ok {
+			// limits and cancellation end the program without running finally
+			return nil, err
+		}
+		for _, catch := range statement.Catches {
+			if !catches(catch.Type, value) {
+				continue
+			}
+			catchScope := scope.FromParent(localScope)
+			if catch.Name != "" {
+				catchScope.AddVariable(scope.Variable{
+					Name:  catch.Name,
+					Value: value,
+				})
+			}
+			result, err = m.executeStatements(catch.Body, catchScope)
+			break
+		}
+		if err != nil {
+			if _, ok := exception(err); !ok {
+				return nil, err
+			}
+		}
+	}
+
+	if statement.HasFinally {
+		// a return, break or exception in the finally replaces the one it interrupted
+		finallyResult, finallyErr := m.executeStatements(statement.Finally, localScope)
+		if finallyResult != nil || finallyErr != nil {
+			return finallyResult, finallyErr
+		}
+	}
+	return result, err
+}
+
+func (m *machine) executeStatements(statements []ast.Statement, localScope *scope.Scope) (*control, error) {
 	for _, statement := range statements {
-		returnValue := executeStatement(statement, localScope)
-		if returnValue != nil {
-			return returnValue
+		result, err := m.executeStatement(statement, localScope)
+		if result != nil || err != nil {
+			return result, err
 		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	return nil, nil
+}
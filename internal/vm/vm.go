@@ -1,11 +1,99 @@
 package vm
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"xml-programming/internal/ast"
+	"xml-programming/internal/bytecode"
 	"xml-programming/internal/scope"
 )
 
-func Run(program *ast.Program) {
-	localScope := scope.New()
-	_ = executeStatements(program.Statements, localScope)
+type Hooks struct {
+	BeforeStatement func(span ast.Span)
+	BeforeCall      func(name string, callSite ast.Span)
+	AfterCall       func(name string)
+}
+
+type Options struct {
+	Output io.Writer
+	Input  io.Reader
+	Hooks  Hooks
+	Limits Limits
+
+	Globals *scope.Scope
+}
+
+type machine struct {
+	ctx    context.Context
+	budget budget
+
+	output io.Writer
+	input  *bufio.Reader
+	hooks  Hooks
+
+	callStack []Frame
+	current   ast.Spanned
+
+	// the bytecode has its own list of records
+	records     map[ast.Type]*ast.RecordDeclaration
+	recordNames map[ast.Type][]string
+}
+
+func newMachine(ctx context.Context, options Options) *machine {
+	m := &machine{
+		ctx:    ctx,
+		budget: newBudget(options.Limits),
+		output: options.Output,
+		hooks:  options.Hooks,
+
+		records: map[ast.Type]*ast.RecordDeclaration{
+			ast.ErrorType: &ast.ErrorRecord,
+		},
+		recordNames: map[ast.Type][]string{},
+	}
+	if m.output == nil {
+		m.output = os.Stdout
+	}
+	if options.Input != nil {
+		m.input = bufio.NewReader(options.Input)
+	} else {
+		m.input = bufio.NewReader(os.Stdin)
+	}
+	return m
+}
+
+func (m *machine) recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = m.fail(m.current, fmt.Errorf("%w: %v", ErrInternal, r))
+	}
+}
+
+// Run interprets the program by walking its AST.
+func Run(ctx context.Context, program *ast.Program, options Options) error {
+	return RunInScope(ctx, program, scope.FromParent(options.Globals), options)
+}
+
+// RunInScope is like Run, but declares the variables and functions of the program directly in localScope
+// instead of below options.Globals, so that another program can run after it, like the inputs of a REPL.
+func RunInScope(ctx context.Context, program *ast.Program, localScope *scope.Scope, options Options) (err error) {
+	m := newMachine(ctx, options)
+	defer m.recoverPanic(&err)
+
+	for i, record := range program.Records {
+		m.records[record.Type] = &program.Records[i]
+	}
+
+	_, err = m.executeStatements(program.Statements, localScope)
+	return err
+}
+
+// Execute runs a program compiled by bytecode.Compile.
+func Execute(ctx context.Context, program *bytecode.Program, options Options) (err error) {
+	m := newMachine(ctx, options)
+	defer m.recoverPanic(&err)
+
+	return m.execute(program)
 }
@@ -0,0 +1,585 @@
+package xmlp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xml-programming/xmlp"
+)
+
+// programs run on both the bytecode VM and the tree walker, which have to print the same and fail the same way
+var programs = []struct {
+	name   string
+	src    string
+	input  string
+	output string
+	// part of the error the program fails with
+	err string
+}{
+	{
+		name: "recursion",
+		src: `<program>
+			<func name="facc">
+				<args><arg name="i" type="int"/><returns type="int"/></args>
+				<body>
+					<switch>
+						<if><cond><lt><var name="i"/><int>1</int></lt></cond><then><return><int>1</int></return></then></if>
+						<else><then><return><mul><var name="i"/><call name="facc"><sub><var name="i"/><int>1</int></sub></call></mul></return></then></else>
+					</switch>
+				</body>
+			</func>
+			<for name="i" from="1" to="6"><body><output><call name="facc"><var name="i"/></call></output></body></for>
+		</program>`,
+		output: "1\n2\n6\n24\n120\n",
+	},
+	{
+		name: "loops and assignments",
+		src: `<program>
+			<declare name="i" type="int"/>
+			<declare name="s" type="string"/>
+			<assign name="i"><int>0</int></assign>
+			<assign name="s"><string></string></assign>
+			<loop><cond><lt><var name="i"/><int>5</int></lt></cond><body>
+				<assign name="s"><concat><var name="s"/><var name="i"/></concat></assign>
+				<assign name="i"><add><var name="i"/><int>1</int></add></assign>
+			</body></loop>
+			<output><var name="s"/><string> </string><var name="i"/></output>
+			<output><div><float>1</float><float>4</float></div><string> </string><mod><int>-7</int><int>3</int></mod><string> </string><not><equal><var name="i"/><int>5</int></equal></not></output>
+		</program>`,
+		output: "01234 5\n0.25 -1 false\n",
+	},
+	{
+		name: "nested functions",
+		src: `<program>
+			<declare name="total" type="int"/>
+			<assign name="total"><int>0</int></assign>
+			<func name="count">
+				<args><arg name="n" type="int"/></args>
+				<body>
+					<func name="step"><body><assign name="total"><add><var name="total"/><var name="n"/></add></assign></body></func>
+					<call name="step"/>
+					<call name="step"/>
+				</body>
+			</func>
+			<call name="count"><int>2</int></call>
+			<call name="count"><int>3</int></call>
+			<output><var name="total"/></output>
+		</program>`,
+		output: "10\n",
+	},
+	{
+		name: "input",
+		src: `<program>
+			<output><concat><string>hello </string><input/></concat></output>
+			<output><input/></output>
+		</program>`,
+		input:  "world\nagain\n",
+		output: "hello world\nagain\n",
+	},
+	{
+		name: "runtime error",
+		src: `<program>
+			<func name="inner">
+				<args><arg name="a" type="int"/><returns type="int"/></args>
+				<body><return><div><int>1</int><var name="a"/></div></return></body>
+			</func>
+			<func name="outer">
+				<args><arg name="a" type="int"/><returns type="int"/></args>
+				<body><return><call name="inner"><var name="a"/></call></return></body>
+			</func>
+			<output><string>before</string></output>
+			<output><call name="outer"><int>0</int></call></output>
+		</program>`,
+		output: "before\n",
+		err:    "<input>:4:19: runtime error: division by zero\n\tat inner (called from <input>:8:",
+	},
+	{
+		name: "arrays",
+		src: `<program>
+			<func name="fill">
+				<args><arg name="xs" type="array&lt;int>"/></args>
+				<body><for name="i" from="0" to="3"><body><append><var name="xs"/><var name="i"/></append></body></for></body>
+			</func>
+			<declare name="xs" type="array&lt;int>"/>
+			<call name="fill"><var name="xs"/></call>
+			<set-index><var name="xs"/><int>0</int><int>10</int></set-index>
+			<output><var name="xs"/><string> </string><len><var name="xs"/></len><string> </string><index><var name="xs"/><int>2</int></index></output>
+			<declare name="grid" type="array&lt;array&lt;string>>"/>
+			<append><var name="grid"/><array><string>a</string></array><array type="string"/></append>
+			<append><index><var name="grid"/><int>1</int></index><string>b</string></append>
+			<foreach name="row" in="grid"><body><output><var name="row"/></output></body></foreach>
+			<output><len><string>héllo</string></len><string> </string><call name="substr"><string>héllo</string><int>1</int><sub><len><string>héllo</string></len><int>1</int></sub></call></output>
+			<output><index><var name="xs"/><int>3</int></index></output>
+		</program>`,
+		output: "[10, 1, 2] 3 2\n[a]\n[b]\n5 éllo\n",
+		err:    "index out of bounds: index 3 with length 3",
+	},
+	{
+		name: "maps",
+		src: `<program>
+			<declare name="ages" type="map&lt;string, int>"/>
+			<put><var name="ages"/><string>bob</string><int>31</int></put>
+			<put><var name="ages"/><string>alice</string><int>29</int></put>
+			<put><var name="ages"/><string>bob</string><int>32</int></put>
+			<output><var name="ages"/><string> </string><len><var name="ages"/></len></output>
+			<output><has><var name="ages"/><string>x</string></has><string> </string><get><var name="ages"/><string>alice</string></get></output>
+			<foreach name="k" value="v" in="ages"><body>
+				<delete><var name="ages"/><string>bob</string></delete>
+				<output><var name="k"/><string>=</string><var name="v"/></output>
+			</body></foreach>
+			<output><keys><var name="ages"/></keys><string> </string><map key="float" value="int"/></output>
+			<output><get><var name="ages"/><string>bob</string></get></output>
+		</program>`,
+		output: "{alice: 29, bob: 32} 2\nfalse 29\nalice=29\nbob=32\n[alice] {}\n",
+		err:    `key not found: "bob"`,
+	},
+	{
+		name: "NaN map keys",
+		src: `<program>
+			<declare name="m" type="map&lt;float64, int>"/>
+			<declare name="nan" type="float64"/>
+			<assign name="nan"><cast type="float64"><string>NaN</string></cast></assign>
+			<try>
+				<body><put><var name="m"/><var name="nan"/><int>1</int></put></body>
+				<catch name="e" type="error"><body><output><get-field name="message"><var name="e"/></get-field></output></body></catch>
+			</try>
+			<output><var name="m"/><string> </string><has><var name="m"/><var name="nan"/></has></output>
+			<output><map><entry><var name="nan"/><int>1</int></entry></map></output>
+		</program>`,
+		output: "invalid map key: NaN\n{} false\n",
+		err:    "invalid map key: NaN",
+	},
+	{
+		name: "try and finally",
+		src: `<program>
+			<func name="safe">
+				<args><arg name="a" type="int"/><arg name="b" type="int"/><returns type="int"/></args>
+				<body>
+					<try>
+						<body><return><div><var name="a"/><var name="b"/></div></return></body>
+						<catch name="e" type="error"><body>
+							<output><string>caught: </string><get-field name="message"><var name="e"/></get-field></output>
+							<return><int>-1</int></return>
+						</body></catch>
+						<finally><body><output><string>finally </string><var name="b"/></output></body></finally>
+					</try>
+				</body>
+			</func>
+			<output><call name="safe"><int>6</int><int>3</int></call></output>
+			<output><call name="safe"><int>6</int><int>0</int></call></output>
+			<for name="i" from="0" to="4"><body>
+				<try>
+					<body>
+						<switch><if><cond><equal><var name="i"/><int>1</int></equal></cond><then><continue/></then></if></switch>
+						<switch><if><cond><equal><var name="i"/><int>3</int></equal></cond><then><break/></then></if></switch>
+						<output><string>body </string><var name="i"/></output>
+					</body>
+					<finally><body><output><string>fin </string><var name="i"/></output></body></finally>
+				</try>
+			</body></for>
+			<try>
+				<body>
+					<try>
+						<body><throw><int>7</int></throw></body>
+						<catch name="s" type="string"><body><output><string>wrong</string></output></body></catch>
+						<finally><body><output><string>inner finally</string></output></body></finally>
+					</try>
+				</body>
+				<catch name="n" type="int"><body><output><string>outer got </string><var name="n"/></output></body></catch>
+			</try>
+			<try>
+				<body><throw><string>first</string></throw></body>
+				<catch name="s" type="string"><body><throw><var name="s"/></throw></body></catch>
+				<finally><body><output><string>finally after catch threw</string></output></body></finally>
+			</try>
+		</program>`,
+		output: "finally 3\n2\ncaught: division by zero\nfinally 0\n-1\nbody 0\nfin 0\nfin 1\nbody 2\nfin 2\nfin 3\ninner finally\nouter got 7\nfinally after catch threw\n",
+		err:    "uncaught exception: first",
+	},
+	{
+		name: "closures in loops",
+		src: `<program>
+			<func name="counter">
+				<args><returns type="func():int"/></args>
+				<body>
+					<declare name="count" type="int"/>
+					<assign name="count"><int>0</int></assign>
+					<return><lambda><args><returns type="int"/></args><body>
+						<assign name="count"><add><var name="count"/><int>1</int></add></assign>
+						<return><var name="count"/></return>
+					</body></lambda></return>
+				</body>
+			</func>
+			<declare name="c" type="func():int"/>
+			<assign name="c"><call name="counter"/></assign>
+			<call name="c"/>
+			<output><call name="c"/><string> </string><call><call name="counter"/></call></output>
+			<declare name="fs" type="array&lt;func():int>"/>
+			<for name="i" from="0" to="3"><body>
+				<declare name="sq" type="int"/>
+				<assign name="sq"><mul><var name="i"/><var name="i"/></mul></assign>
+				<append><var name="fs"/><lambda><args><returns type="int"/></args><body>
+					<return><add><var name="i"/><var name="sq"/></add></return>
+				</body></lambda></append>
+			</body></for>
+			<foreach name="f" in="fs"><body><output><call name="f"/></output></body></foreach>
+			<for name="i" from="0" to="2"><body>
+				<func name="show"><args><returns type="string"/></args><body>
+					<return><concat><string>show </string><var name="i"/></concat></return>
+				</body></func>
+				<append><var name="fs"/><lambda><args><returns type="int"/></args><body><return><len><call name="show"/></len></return></body></lambda></append>
+				<output><call name="show"/></output>
+			</body></for>
+			<declare name="rec" type="func(int):int"/>
+			<assign name="rec"><lambda>
+				<args><arg name="n" type="int"/><returns type="int"/></args>
+				<body>
+					<switch><if><cond><lt><var name="n"/><int>2</int></lt></cond><then><return><int>1</int></return></then></if></switch>
+					<return><mul><var name="n"/><call name="rec"><sub><var name="n"/><int>1</int></sub></call></mul></return>
+				</body>
+			</lambda></assign>
+			<output><call name="rec"><int>5</int></call><string> </string><call><index><var name="fs"/><int>4</int></index></call></output>
+		</program>`,
+		output: "2 1\n0\n2\n6\nshow 0\nshow 1\n120 6\n",
+	},
+	{
+		name: "promotion",
+		src: `<program>
+			<output><call name="min"><int>3</int><float>1.5</float><int>2</int></call><string> </string><call name="max"><int>3</int><int64>10</int64></call></output>
+			<output><add><int>1</int><float64>0.5</float64></add><string> </string><lt><int>1</int><bigint>100000000000000000000</bigint></lt></output>
+			<output><cast type="int"><float>-2.7</float></cast><string> </string><cast type="bool"><int>2</int></cast><string> </string><cast type="float64"><string>0.1</string></cast></output>
+			<output><cast type="int"><string>x</string></cast></output>
+		</program>`,
+		output: "1.5 10\n1.5 true\n-2 true 0.1\n",
+		err:    `invalid conversion`,
+	},
+	{
+		name: "parsing numbers",
+		src: `<program>
+			<declare name="texts" type="array&lt;string>"/>
+			<append><var name="texts"/><string>-1.5e3</string><string>.5</string><string>2.</string><string>+Inf</string><string>0x1p-2</string><string>1_0.5</string><string>1e</string></append>
+			<foreach name="text" in="texts"><body>
+				<try>
+					<body><output><cast type="float64"><var name="text"/></cast><string> </string><cast type="float"><var name="text"/></cast></output></body>
+					<catch name="e" type="error"><body><output><get-field name="message"><var name="e"/></get-field></output></body></catch>
+				</try>
+			</body></foreach>
+			<assign name="texts"><array><string>+42</string><string>-007</string><string>0x10</string><string>1_000</string><string>4.0</string></array></assign>
+			<foreach name="text" in="texts"><body>
+				<try>
+					<body><output><cast type="int"><var name="text"/></cast><string> </string><cast type="int64"><var name="text"/></cast><string> </string><cast type="bigint"><var name="text"/></cast></output></body>
+					<catch name="e" type="error"><body><output><get-field name="message"><var name="e"/></get-field></output></body></catch>
+				</try>
+			</body></foreach>
+		</program>`,
+		output: "-1500 -1500\n0.5 0.5\n2 2\n+Inf +Inf\n" +
+			"invalid conversion: \"0x1p-2\" is not a valid float64\ninvalid conversion: \"1_0.5\" is not a valid float64\ninvalid conversion: \"1e\" is not a valid float64\n" +
+			"42 42 42\n-7 -7 -7\n" +
+			"invalid conversion: \"0x10\" is not a valid int\ninvalid conversion: \"1_000\" is not a valid int\ninvalid conversion: \"4.0\" is not a valid int\n",
+	},
+	{
+		name: "library function errors",
+		src: `<program>
+			<try>
+				<body><output><call name="substr"><string>héllo</string><int>3</int><int>3</int></call></output></body>
+				<catch name="e" type="error"><body><output><get-field name="message"><var name="e"/></get-field></output></body></catch>
+			</try>
+			<output><call name="abs"><int64>-9223372036854775808</int64></call></output>
+		</program>`,
+		output: "library function failed: substr: substring from 3 with length 3 is out of range for length 5\n",
+		err:    "library function failed: abs: the absolute value of the smallest int64 is out of range",
+	},
+	{
+		name: "overflow and 64 bit numbers",
+		src: `<program>
+			<output><call name="sqrt"><float64>2</float64></call><string> </string><call name="pow"><float64>2</float64><int>10</int></call><string> </string><call name="round"><float64>-2.5</float64></call></output>
+			<declare name="big" type="bigint"/>
+			<assign name="big"><bigint>1</bigint></assign>
+			<for name="i" from="0" to="3"><body><assign name="big"><mul><var name="big"/><int64>9223372036854775807</int64></mul></assign></body></for>
+			<output><var name="big"/></output>
+			<try>
+				<body><output><mul><int64>9223372036854775807</int64><int>2</int></mul></output></body>
+				<catch name="e" type="error"><body><output><get-field name="message"><var name="e"/></get-field></output></body></catch>
+			</try>
+			<output><add><int>9223372036854775807</int><int>1</int></add></output>
+		</program>`,
+		output: "1.4142135623730951 1024 -3\n784637716923335095224261902710254454442933591094742482943\ninteger overflow\n",
+		err:    "integer overflow",
+	},
+	{
+		name: "for bounds and steps",
+		src: `<program>
+			<declare name="n" type="int"/>
+			<assign name="n"><int>2</int></assign>
+			<for name="i" inclusive="true"><from><var name="n"/></from><to><mul><var name="n"/><int>2</int></mul></to><step><sub><var name="n"/><int>1</int></sub></step><body><output><string>a</string><var name="i"/></output></body></for>
+			<for name="i" from="0"><to><var name="n"/></to><body><assign name="n"><int>10</int></assign><output><string>b</string><var name="i"/></output></body></for>
+			<for name="i" from="5" to="0" step="-2"><body><output><string>c</string><var name="i"/></output></body></for>
+			<for name="i" from="5" to="1" step="-2" inclusive="true"><body><output><string>d</string><var name="i"/></output></body></for>
+			<for name="x" from="0" to="1" step="0.25" inclusive="true"><body><output><string>e</string><var name="x"/></output></body></for>
+			<for name="x" from="1" to="0"><step><float64>-0.5</float64></step><body><output><string>f</string><var name="x"/></output></body></for>
+			<for name="i" from="3" to="0"><body><output><string>never</string></output></body></for>
+			<declare name="s" type="int"/>
+			<assign name="s"><int>0</int></assign>
+			<for name="i" from="0" to="1"><step><var name="s"/></step><body/></for>
+		</program>`,
+		output: "a2\na3\na4\nb0\nb1\nc5\nc3\nc1\nd5\nd3\nd1\ne0\ne0.25\ne0.5\ne0.75\ne1\nf1\nf0.5\n",
+		err:    "step of for loop is zero",
+	},
+	{
+		name: "records",
+		src: `<program>
+			<record name="Point"><field name="x" type="int"/><field name="y" type="int"/></record>
+			<record name="Line"><field name="from" type="Point"/><field name="to" type="Point"/></record>
+			<func name="move">
+				<args><arg name="p" type="Point"/><arg name="dx" type="int"/></args>
+				<body><set-field name="x"><var name="p"/><add><get-field name="x"><var name="p"/></get-field><var name="dx"/></add></set-field></body>
+			</func>
+			<declare name="p" type="Point"/>
+			<assign name="p"><new type="Point"><field name="x"><int>1</int></field><field name="y"><int>2</int></field></new></assign>
+			<declare name="x" type="int"/>
+			<assign name="x"><get-field name="x"><var name="p"/></get-field></assign>
+			<declare name="q" type="Point"/>
+			<assign name="q"><var name="p"/></assign>
+			<call name="move"><var name="q"/><int>10</int></call>
+			<output><var name="p"/><string> </string><var name="x"/></output>
+			<declare name="l" type="Line"/>
+			<assign name="l"><new type="Line"><field name="from"><var name="p"/></field><field name="to"><var name="p"/></field></new></assign>
+			<set-field name="y"><get-field name="from"><var name="l"/></get-field><int>7</int></set-field>
+			<output><get-field name="to"><var name="l"/></get-field><string> </string><var name="q"/></output>
+			<declare name="ps" type="array&lt;Point>"/>
+			<append><var name="ps"/><var name="p"/><new type="Point"><field name="x"><int>1</int></field><field name="y"><int>7</int></field></new></append>
+			<call name="move"><index><var name="ps"/><int>0</int></index><int>1</int></call>
+			<output><var name="p"/><string> </string><var name="ps"/></output>
+		</program>`,
+		output: "Point{x: 11, y: 2} 1\nPoint{x: 11, y: 7} Point{x: 11, y: 7}\nPoint{x: 12, y: 7} [Point{x: 12, y: 7}, Point{x: 1, y: 7}]\n",
+	},
+	{
+		name: "match",
+		src: `<program>
+    <enum name="Suit"><variant name="Clubs"/><variant name="Diamonds"/><variant name="Hearts"/><variant name="Spades"/></enum>
+    <func name="colour">
+        <args><arg name="s" type="Suit"/><returns type="string"/></args>
+        <body>
+            <match><value><var name="s"/></value>
+                <case variant="Hearts"><then><return><string>red</string></return></then></case>
+                <case variant="Diamonds"><then><return><string>red</string></return></then></case>
+                <default><then><return><string>black</string></return></then></default>
+            </match>
+        </body>
+    </func>
+    <declare name="suits" type="array&lt;Suit>"/>
+    <append><var name="suits"/><variant type="Suit" name="Spades"/><variant type="Suit" name="Hearts"/><variant type="Suit" name="Clubs"/><variant type="Suit" name="Diamonds"/></append>
+    <foreach name="s" in="suits"><body>
+        <output><var name="s"/><string> </string><call name="colour"><var name="s"/></call></output>
+        <match><value><var name="s"/></value>
+            <case variant="Spades"><then><output><string>last</string></output></then></case>
+            <case variant="Clubs"><then><output><string>first</string></output><continue/></then></case>
+            <case variant="Hearts"><then></then></case>
+            <case variant="Diamonds"><then><output><string>red</string></output></then></case>
+        </match>
+        <output><string>after</string></output>
+    </body></foreach>
+</program>`,
+		output: "Spades black\nlast\nafter\nHearts red\nafter\nClubs black\nfirst\nDiamonds red\nred\nafter\n",
+	},
+	{
+		name: "optionals",
+		src: `<program>
+    <func name="find">
+        <args><arg name="xs" type="array&lt;string>"/><arg name="x" type="string"/><returns type="optional&lt;int>"/></args>
+        <body>
+            <declare name="i" type="int"/>
+            <assign name="i"><int>0</int></assign>
+            <foreach name="y" in="xs"><body>
+                <switch><if><cond><equal><var name="y"/><var name="x"/></equal></cond><then><return><some><var name="i"/></some></return></then></if></switch>
+                <assign name="i"><add><var name="i"/><int>1</int></add></assign>
+            </body></foreach>
+            <return><none/></return>
+        </body>
+    </func>
+    <declare name="xs" type="array&lt;string>"/>
+    <append><var name="xs"/><string>a</string><string>b</string></append>
+    <declare name="o" type="optional&lt;int>"/>
+    <output><var name="o"/><string> </string><is-none><var name="o"/></is-none></output>
+    <assign name="o"><call name="find"><var name="xs"/><string>b</string></call></assign>
+    <output><var name="o"/><string> </string><unwrap-or><var name="o"/><int>-1</int></unwrap-or></output>
+    <output><unwrap-or><call name="find"><var name="xs"/><string>z</string></call><int>-1</int></unwrap-or></output>
+    <output><unwrap-or><none/><string>d</string></unwrap-or></output>
+</program>`,
+		output: "none true\n1 1\n-1\nd\n",
+	},
+	{
+		name: "records that contain themselves",
+		src: `<program>
+    <record name="Node">
+        <field name="n" type="int"/>
+        <field name="next" type="optional&lt;Node>"/>
+        <field name="children" type="array&lt;Node>"/>
+    </record>
+    <declare name="p" type="Node"/>
+    <assign name="p"><new type="Node"><field name="n"><int>1</int></field><field name="next"><none/></field><field name="children"><array type="Node"/></field></new></assign>
+    <output><var name="p"/></output>
+    <set-field name="next"><var name="p"/><some><var name="p"/></some></set-field>
+    <output><var name="p"/></output>
+    <declare name="q" type="Node"/>
+    <assign name="q"><new type="Node"><field name="n"><int>2</int></field><field name="next"><some><var name="p"/></some></field><field name="children"><array type="Node"/></field></new></assign>
+    <append><get-field name="children"><var name="p"/></get-field><var name="q"/><var name="q"/></append>
+    <output><var name="q"/></output>
+</program>`,
+		output: "Node{n: 1, next: none, children: []}\nNode{n: 1, next: Node{...}, children: []}\nNode{n: 2, next: Node{n: 1, next: Node{...}, children: [Node{...}, Node{...}]}, children: []}\n",
+	},
+	{
+		name: "case mapping",
+		src: `<program>
+    <output><call name="upper"><string>straße</string></call></output>
+    <output><call name="lower"><string>ΟΔΥΣΣΕΥΣ</string></call></output>
+    <output><call name="lower"><string>İstanbul</string></call><string> </string><call name="upper"><string>ᾳ ﬁ ŉ</string></call></output>
+    <output><call name="len"><call name="upper"><string>ǆ ß</string></call></call></output>
+</program>`,
+		output: "STRAßE\nοδυσσευσ\nistanbul ᾼ ﬁ ŉ\n3\n",
+	},
+}
+
+func run(t *testing.T, src string, input string, treeWalker bool) (string, string) {
+	var output strings.Builder
+	interpreter := xmlp.New(
+		xmlp.WithOutput(&output),
+		xmlp.WithInput(strings.NewReader(input)),
+		xmlp.WithTreeWalker(treeWalker),
+	)
+	program, diagnostics := interpreter.Compile([]byte(src))
+	if program == nil {
+		t.Fatalf("unable to compile: %v", diagnostics)
+	}
+	err := program.Run(context.Background())
+	var runtimeError *xmlp.RuntimeError
+	if errors.As(err, &runtimeError) {
+		return output.String(), runtimeError.Error() + "\n" + runtimeError.StackTrace()
+	}
+	if err != nil {
+		return output.String(), err.Error()
+	}
+	return output.String(), ""
+}
+
+func TestEngines(t *testing.T) {
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := run(t, test.src, test.input, false)
+			treeOutput, treeErr := run(t, test.src, test.input, true)
+			if output != test.output {
+				t.Errorf("expected output %q, got %q", test.output, output)
+			}
+			if treeOutput != output {
+				t.Errorf("the tree walker printed %q, the VM %q", treeOutput, output)
+			}
+			if !strings.Contains(err, test.err) || (test.err == "" && err != "") {
+				t.Errorf("expected error %q, got %q", test.err, err)
+			}
+			if treeErr != err {
+				t.Errorf("the tree walker failed with %q, the VM with %q", treeErr, err)
+			}
+		})
+	}
+}
+
+// TestTranspiled runs the programs as JavaScript, which has to print the same as the VM
+func TestTranspiled(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			program, diagnostics := xmlp.Compile([]byte(test.src))
+			if program == nil {
+				t.Fatalf("unable to compile: %v", diagnostics)
+			}
+			javaScript, err := program.Transpile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a file rather than stdin, where the program would get a smaller stack, see Program.Transpile
+			file := filepath.Join(t.TempDir(), "program.js")
+			err = os.WriteFile(file, javaScript, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var output, errorOutput bytes.Buffer
+			command := exec.Command(node, file)
+			command.Stdin = strings.NewReader(test.input)
+			command.Stdout = &output
+			command.Stderr = &errorOutput
+			err = command.Run()
+
+			vmOutput, _ := run(t, test.src, test.input, false)
+			if output.String() != vmOutput {
+				t.Errorf("node printed %q, the VM %q", output.String(), vmOutput)
+			}
+			if (err != nil) != (test.err != "") || !strings.Contains(errorOutput.String(), test.err) {
+				t.Errorf("expected error %q, node failed with %v: %q", test.err, err, errorOutput.String())
+			}
+		})
+	}
+}
+
+// benchmarks are programs that mostly call functions and loop, where the VM should be much faster than the tree walker
+var benchmarks = []struct {
+	name string
+	src  string
+}{
+	{
+		name: "fib",
+		src: `<program>
+	<func name="fib"><args><arg name="n" type="int"/><returns type="int"/></args><body>
+		<switch><if><cond><lt><var name="n"/><int>2</int></lt></cond><then><return><var name="n"/></return></then></if></switch>
+		<return><add><call name="fib"><sub><var name="n"/><int>1</int></sub></call><call name="fib"><sub><var name="n"/><int>2</int></sub></call></add></return>
+	</body></func>
+	<output><call name="fib"><int>25</int></call></output>
+</program>`,
+	},
+	{
+		name: "for",
+		src: `<program>
+	<declare name="sum" type="int"/>
+	<assign name="sum"><int>0</int></assign>
+	<for name="i" from="0" to="1000000"><body>
+		<assign name="sum"><add><var name="sum"/><mod><var name="i"/><int>7</int></mod></add></assign>
+	</body></for>
+	<output><var name="sum"/></output>
+</program>`,
+	},
+}
+
+// BenchmarkEngines compares the engines, e.g. with go test -run NONE -bench Engines ./xmlp
+func BenchmarkEngines(b *testing.B) {
+	for _, benchmark := range benchmarks {
+		for _, engine := range []struct {
+			name       string
+			treeWalker bool
+		}{{"vm", false}, {"tree-walker", true}} {
+			b.Run(benchmark.name+"/"+engine.name, func(b *testing.B) {
+				interpreter := xmlp.New(xmlp.WithOutput(io.Discard), xmlp.WithTreeWalker(engine.treeWalker))
+				program, diagnostics := interpreter.Compile([]byte(benchmark.src))
+				if program == nil {
+					b.Fatalf("unable to compile: %v", diagnostics)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := program.Run(context.Background())
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package xmlp
+
+import (
+	"fmt"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/vm"
+)
+
+// HostFunction is a Go callback callable from programs via <call name="...">.
+// A returned error is wrapped together with ErrHostFunction into a *RuntimeError. Programs can catch it with
+// <catch type="error">, whose value then has the message "host function failed: <name>: " followed by the error's;
+// otherwise it aborts the program.
+type HostFunction func(args []Value) (Value, error)
+
+// ErrHostFunction is wrapped by the errors of host functions.
+var ErrHostFunction = vm.ErrHostFunction
+
+// ErrLibraryFunction is wrapped by the errors of the functions of the standard library, like substr out of range.
+var ErrLibraryFunction = vm.ErrLibraryFunction
+
+// Signature declares the types a host function is checked against.
+// If Variadic is set, the last argument type may be repeated any number of times, including zero.
+type Signature struct {
+	Args     []Type
+	Returns  Type
+	Variadic bool
+}
+
+// Register makes function callable from programs compiled by this interpreter afterwards.
+func (i *Interpreter) Register(name string, signature Signature, function HostFunction) error {
+	if i.globals.CurrentScopeHas(name) {
+		return fmt.Errorf("function %s is already registered", name)
+	}
+	if signature.Variadic && len(signature.Args) == 0 {
+		return fmt.Errorf("variadic function %s needs at least one argument type", name)
+	}
+
+	var args []ast.FunctionArg
+	for index, _type := range signature.Args {
+		args = append(args, ast.FunctionArg{
+			Name: fmt.Sprintf("arg%d", index+1),
+			Type: _type,
+		})
+	}
+
+	i.globals.AddFunction(scope.Function{
+		Name:     name,
+		Args:     args,
+		Return:   signature.Returns,
+		Variadic: signature.Variadic,
+		Native:   scope.NativeFunction(function),
+	})
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package xmlp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"xml-programming/xmlp"
+)
+
+func TestFunctionErrors(t *testing.T) {
+	failing := func([]xmlp.Value) (xmlp.Value, error) {
+		return xmlp.Value{}, errors.New("unavailable")
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		err  error
+		not  error
+	}{
+		{"host", `<call name="fetch"/>`, xmlp.ErrHostFunction, xmlp.ErrLibraryFunction},
+		{"library", `<call name="substr"><string>abc</string><int>2</int><int>5</int></call>`, xmlp.ErrLibraryFunction, xmlp.ErrHostFunction},
+	}
+	for _, test := range tests {
+		for _, treeWalker := range []bool{false, true} {
+			interpreter := xmlp.New(
+				xmlp.WithOutput(io.Discard),
+				xmlp.WithTreeWalker(treeWalker),
+				xmlp.WithFunction("fetch", xmlp.Signature{Returns: xmlp.String}, failing),
+			)
+			program, diagnostics := interpreter.Compile([]byte("<program><output>" + test.src + "</output></program>"))
+			if program == nil {
+				t.Fatalf("%s: %v", test.name, diagnostics)
+			}
+			err := program.Run(context.Background())
+			if !errors.Is(err, test.err) || errors.Is(err, test.not) {
+				t.Errorf("%s (tree walker: %v): expected %v, got %v", test.name, treeWalker, test.err, err)
+			}
+		}
+	}
+}
+
+func TestVariadicFunctions(t *testing.T) {
+	// sum prints its label and the sum of any number of ints after it
+	sum := func(args []xmlp.Value) (xmlp.Value, error) {
+		total := 0
+		for _, arg := range args[1:] {
+			total += arg.Int
+		}
+		return xmlp.Value{Type: xmlp.String, String: fmt.Sprintf("%s %d %d", args[0].String, len(args)-1, total)}, nil
+	}
+	signature := xmlp.Signature{Args: []xmlp.Type{xmlp.String, xmlp.Int}, Returns: xmlp.String, Variadic: true}
+
+	tests := []struct {
+		name   string
+		src    string
+		output string
+		code   string
+	}{
+		{"no extra arguments", `<call name="sum"><string>none</string></call>`, "none 0 0\n", ""},
+		{"one", `<call name="sum"><string>one</string><int>4</int></call>`, "one 1 4\n", ""},
+		{"several", `<call name="sum"><string>several</string><int>1</int><int>2</int><int>3</int><add><int>2</int><int>2</int></add></call>`, "several 4 10\n", ""},
+		{"mismatch", `<call name="sum"><string>mismatch</string><int>1</int><string>2</string></call>`, "", "type-mismatch"},
+		{"missing the fixed argument", `<call name="sum"/>`, "", "argument-count"},
+	}
+	for _, test := range tests {
+		for _, treeWalker := range []bool{false, true} {
+			var output strings.Builder
+			interpreter := xmlp.New(
+				xmlp.WithOutput(&output),
+				xmlp.WithTreeWalker(treeWalker),
+				xmlp.WithFunction("sum", signature, sum),
+			)
+			program, diagnostics := interpreter.Compile([]byte("<program><output>" + test.src + "</output></program>"))
+			if test.code != "" {
+				if program != nil || len(diagnostics) != 1 || string(diagnostics[0].Code) != test.code {
+					t.Errorf("%s: expected a %s diagnostic, got %v", test.name, test.code, diagnostics)
+				}
+				continue
+			}
+			if program == nil {
+				t.Fatalf("%s: %v", test.name, diagnostics)
+			}
+			err := program.Run(context.Background())
+			if err != nil || output.String() != test.output {
+				t.Errorf("%s (tree walker: %v): expected %q, got %q and %v", test.name, treeWalker, test.output, output.String(), err)
+			}
+		}
+	}
+}
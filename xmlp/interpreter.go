@@ -0,0 +1,258 @@
+// Package xmlp embeds the XML programming language interpreter.
+package xmlp
+
+import (
+	"context"
+	"io"
+	"xml-programming/internal/analysis"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/builtins"
+	"xml-programming/internal/bytecode"
+	"xml-programming/internal/parser"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/transpile"
+	"xml-programming/internal/values"
+	"xml-programming/internal/vm"
+)
+
+type Span = ast.Span
+type Diagnostic = analysis.Diagnostic
+type Index = analysis.Index
+type Symbol = analysis.Symbol
+type Reference = analysis.Reference
+type Severity = analysis.Severity
+type RuntimeError = vm.RuntimeError
+
+// Thrown is the Cause of a RuntimeError for a value that was thrown by <throw> and never caught.
+type Thrown = vm.Thrown
+type Frame = vm.Frame
+type Hooks = vm.Hooks
+type Limits = vm.Limits
+type Type = ast.Type
+type Value = values.Value
+
+const (
+	Void    = ast.Void
+	String  = ast.String
+	Bool    = ast.Bool
+	Int     = ast.Int
+	Float   = ast.Float
+	Int64   = ast.Int64
+	Float64 = ast.Float64
+	BigInt  = ast.BigInt
+
+	// Invalid is the type of expressions with errors.
+	Invalid = ast.Invalid
+)
+
+// The errors wrapped by a *RuntimeError when a program exceeds one of its Limits, which can't be caught by the program.
+var (
+	ErrStatementLimit  = vm.ErrStatementLimit
+	ErrCallDepthLimit  = vm.ErrCallDepthLimit
+	ErrStringLimit     = vm.ErrStringLimit
+	ErrAllocationLimit = vm.ErrAllocationLimit
+)
+
+// ArrayOf returns the type array<elem>.
+func ArrayOf(elem Type) Type {
+	return ast.ArrayOf(elem)
+}
+
+// NewArray creates an array value; arrays are shared, not copied, on assignment.
+func NewArray(elem Type, elements ...Value) Value {
+	return values.NewArray(elem, elements)
+}
+
+// MapOf returns the type map<key, value>; keys have to be String, Bool, Int, Float, Int64, Float64 or an enum.
+func MapOf(key Type, value Type) Type {
+	return ast.MapOf(key, value)
+}
+
+// NewMap creates an empty map value that is shared on assignment just like arrays.
+func NewMap(key Type, value Type) Value {
+	return values.NewMap(key, value, nil)
+}
+
+// OptionalOf returns the type optional<elem>.
+func OptionalOf(elem Type) Type {
+	return ast.OptionalOf(elem)
+}
+
+// Some wraps a value into an optional; the zero value of an optional type is none.
+func Some(value Value) Value {
+	return values.Some(value)
+}
+
+const (
+	Error   = analysis.Error
+	Warning = analysis.Warning
+
+	// ParseError is the Code of diagnostics for sources that aren't well-formed programs.
+	ParseError = analysis.ParseError
+	// ReadError is the Code of diagnostics for sources that can't be read, which tools like xmlp check report.
+	ReadError = analysis.ReadError
+)
+
+type Option func(*Interpreter)
+
+// WithOutput sets the writer <output> statements print to. Defaults to os.Stdout.
+func WithOutput(output io.Writer) Option {
+	return func(i *Interpreter) {
+		i.options.Output = output
+	}
+}
+
+// WithInput sets the reader <input/> expressions read lines from. Defaults to os.Stdin.
+func WithInput(input io.Reader) Option {
+	return func(i *Interpreter) {
+		i.options.Input = input
+	}
+}
+
+// WithHooks installs callbacks that are invoked while a program runs.
+func WithHooks(hooks Hooks) Option {
+	return func(i *Interpreter) {
+		i.options.Hooks = hooks
+	}
+}
+
+// WithLimits sets the default execution budget for Program.Run.
+func WithLimits(limits Limits) Option {
+	return func(i *Interpreter) {
+		i.options.Limits = limits
+	}
+}
+
+// WithTreeWalker makes programs run on the AST interpreter instead of the bytecode VM.
+// Both are meant to behave identically, which makes the tree walker useful for differential testing.
+func WithTreeWalker(enabled bool) Option {
+	return func(i *Interpreter) {
+		i.treeWalker = enabled
+	}
+}
+
+// WithFunction registers a host function; see Interpreter.Register. It panics if the name is taken.
+func WithFunction(name string, signature Signature, function HostFunction) Option {
+	return func(i *Interpreter) {
+		err := i.Register(name, signature, function)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// WithArgs makes args available to programs through <call name="args"/>, which returns them as array<string>.
+func WithArgs(args []string) Option {
+	return WithFunction("args", Signature{Returns: ArrayOf(String)}, func([]Value) (Value, error) {
+		// every call gets its own array, since programs can modify it
+		elements := make([]Value, len(args))
+		for i, arg := range args {
+			elements[i] = Value{Type: String, String: arg}
+		}
+		return NewArray(String, elements...), nil
+	})
+}
+
+type Interpreter struct {
+	options    vm.Options
+	globals    *scope.Scope
+	treeWalker bool
+}
+
+func New(options ...Option) *Interpreter {
+	// host functions can replace functions of the standard library, since they are declared below it
+	globals := scope.FromParent(builtins.Scope())
+	interpreter := &Interpreter{
+		options: vm.Options{
+			Globals: globals,
+		},
+		globals: globals,
+	}
+	for _, option := range options {
+		option(interpreter)
+	}
+	return interpreter
+}
+
+type Program struct {
+	interpreter *Interpreter
+	program     *ast.Program
+	compiled    *bytecode.Program
+}
+
+// Compile parses and analyses src. The program is nil if any diagnostic is an error.
+func (i *Interpreter) Compile(src []byte) (*Program, []Diagnostic) {
+	return i.CompileFile("", src)
+}
+
+// CompileFile is like Compile, but uses filename in source positions.
+func (i *Interpreter) CompileFile(filename string, src []byte) (*Program, []Diagnostic) {
+	program, err := parser.Parse(filename, src)
+	if err != nil {
+		return nil, []Diagnostic{analysis.FromError(analysis.ParseError, err)}
+	}
+
+	diagnostics, matched := analysis.StaticAnalysis(program, i.globals)
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+
+	compiled, err := bytecode.Compile(program, i.globals, matched)
+	if err != nil {
+		return nil, append(diagnostics, analysis.FromError(analysis.CompileError, err))
+	}
+
+	return &Program{
+		interpreter: i,
+		program:     program,
+		compiled:    compiled,
+	}, diagnostics
+}
+
+// Analyse parses and analyses src like CompileFile, without compiling it. Instead of a program it returns
+// what the analysis found out about the names and expressions of src, for editors; the index is nil if src doesn't parse.
+func (i *Interpreter) Analyse(filename string, src []byte) (*Index, []Diagnostic) {
+	program, err := parser.Parse(filename, src)
+	if err != nil {
+		return nil, []Diagnostic{analysis.FromError(analysis.ParseError, err)}
+	}
+	diagnostics, index := analysis.StaticAnalysisWithIndex(program, i.globals)
+	return index, diagnostics
+}
+
+// Compile compiles src with a default interpreter.
+func Compile(src []byte) (*Program, []Diagnostic) {
+	return New().Compile(src)
+}
+
+// Run executes the program. Failures during execution, including cancellation of ctx
+// and exceeded limits, are returned as *RuntimeError.
+func (p *Program) Run(ctx context.Context) error {
+	return p.run(ctx, p.interpreter.options)
+}
+
+// RunWithLimits is like Run, but overrides the interpreter's limits.
+func (p *Program) RunWithLimits(ctx context.Context, limits Limits) error {
+	options := p.interpreter.options
+	options.Limits = limits
+	return p.run(ctx, options)
+}
+
+func (p *Program) run(ctx context.Context, options vm.Options) error {
+	if p.interpreter.treeWalker {
+		return vm.Run(ctx, p.program, options)
+	}
+	return vm.Execute(ctx, p.compiled, options)
+}
+
+// Transpile returns the program as JavaScript for Node.js, which prints and fails like Run does.
+// Programs that call host functions other than args can't be transpiled. Piped into node, rather than run from
+// a file, the program has a smaller stack and fails after fewer nested calls.
+func (p *Program) Transpile() ([]byte, error) {
+	return transpile.JavaScript(p.program)
+}
+
+// Disassemble returns a human readable listing of the compiled bytecode.
+func (p *Program) Disassemble() string {
+	return p.compiled.Disassemble()
+}
@@ -0,0 +1,132 @@
+package xmlp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"xml-programming/xmlp"
+)
+
+// doubling calls f twenty times on a string that it doubles, ending up with a million characters
+const doubling = `<program>
+	<declare name="s" type="string"/>
+	<assign name="s"><string>x</string></assign>
+	<for name="i" from="0" to="20"><body>
+		<assign name="s">%s</assign>
+	</body></for>
+	<output><call name="len"><var name="s"/></call></output>
+</program>`
+
+func TestNativeResultsAreLimited(t *testing.T) {
+	long := func(args []xmlp.Value) (xmlp.Value, error) {
+		return xmlp.Value{Type: xmlp.String, String: args[0].String + args[0].String}, nil
+	}
+	limits := xmlp.Limits{MaxStringLength: 1000}
+
+	tests := []struct {
+		name   string
+		call   string
+		limits xmlp.Limits
+		err    error
+	}{
+		{"replace", `<call name="replace"><var name="s"/><string>x</string><string>xx</string></call>`, limits, xmlp.ErrStringLimit},
+		{"join", `<call name="join"><array type="string"><var name="s"/><var name="s"/></array><string></string></call>`, limits, xmlp.ErrStringLimit},
+		{"host", `<call name="double"><var name="s"/></call>`, limits, xmlp.ErrStringLimit},
+		{"replace allocation", `<call name="replace"><var name="s"/><string>x</string><string>xx</string></call>`, xmlp.Limits{MaxAllocatedBytes: 1 << 16}, xmlp.ErrAllocationLimit},
+		{"split allocation", `<call name="join"><call name="split"><call name="replace"><var name="s"/><string>x</string><string>x,x</string></call><string>,</string></call><string></string></call>`, xmlp.Limits{MaxAllocatedBytes: 1 << 16}, xmlp.ErrAllocationLimit},
+	}
+	for _, test := range tests {
+		for _, treeWalker := range []bool{false, true} {
+			interpreter := xmlp.New(
+				xmlp.WithOutput(io.Discard),
+				xmlp.WithTreeWalker(treeWalker),
+				xmlp.WithFunction("double", xmlp.Signature{Args: []xmlp.Type{xmlp.String}, Returns: xmlp.String}, long),
+			)
+			program, diagnostics := interpreter.Compile([]byte(strings.Replace(doubling, "%s", test.call, 1)))
+			if program == nil {
+				t.Fatalf("%s: %v", test.name, diagnostics)
+			}
+			err := program.RunWithLimits(context.Background(), test.limits)
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s (tree walker: %v): expected %v, got %v", test.name, treeWalker, test.err, err)
+			}
+		}
+	}
+}
+
+// uncatchable wraps src in a <try> that would print caught for any error it can catch
+const uncatchable = `<program>
+	<func name="deeper">
+		<args><arg name="n" type="int"/><returns type="int"/></args>
+		<body><return><add><call name="deeper"><add><var name="n"/><int>1</int></add></call><int>1</int></add></return></body>
+	</func>
+	<declare name="i" type="int"/>
+	<assign name="i"><int>0</int></assign>
+	<try>
+		<body>%s</body>
+		<catch name="e" type="error"><body><output><string>caught</string></output></body></catch>
+	</try>
+</program>`
+
+// counting runs a billion iterations, which no test waits for
+const counting = `<loop><cond><lt><var name="i"/><int>1000000000</int></lt></cond><body><assign name="i"><add><var name="i"/><int>1</int></add></assign></body></loop>`
+
+func TestLimitsCantBeCaught(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		src    string
+		limits xmlp.Limits
+		ctx    func() (context.Context, context.CancelFunc)
+		err    error
+	}{
+		{"statements", counting, xmlp.Limits{MaxStatements: 1000}, nil, xmlp.ErrStatementLimit},
+		{"call depth", `<output><call name="deeper"><int>0</int></call></output>`, xmlp.Limits{MaxCallDepth: 100}, nil, xmlp.ErrCallDepthLimit},
+		{"cancellation", counting, xmlp.Limits{}, func() (context.Context, context.CancelFunc) {
+			return cancelled, func() {}
+		}, context.Canceled},
+		{"deadline", counting, xmlp.Limits{}, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, context.DeadlineExceeded},
+	}
+	limitErrors := []error{xmlp.ErrStatementLimit, xmlp.ErrCallDepthLimit, context.Canceled, context.DeadlineExceeded}
+
+	for _, test := range tests {
+		for _, treeWalker := range []bool{false, true} {
+			var output strings.Builder
+			interpreter := xmlp.New(xmlp.WithOutput(&output), xmlp.WithTreeWalker(treeWalker))
+			src := strings.Replace(uncatchable, "%s", test.src, 1)
+			program, diagnostics := interpreter.Compile([]byte(src))
+			if program == nil {
+				t.Fatalf("%s: %v", test.name, diagnostics)
+			}
+
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if test.ctx != nil {
+				ctx, cancel = test.ctx()
+			}
+			err := program.RunWithLimits(ctx, test.limits)
+			cancel()
+
+			var runtimeError *xmlp.RuntimeError
+			if !errors.As(err, &runtimeError) || !errors.Is(err, test.err) {
+				t.Errorf("%s (tree walker: %v): expected a runtime error for %v, got %v", test.name, treeWalker, test.err, err)
+			}
+			for _, other := range limitErrors {
+				if other != test.err && errors.Is(err, other) {
+					t.Errorf("%s (tree walker: %v): expected only %v, got %v", test.name, treeWalker, test.err, err)
+				}
+			}
+			// with the statement limit, the catch would fail again instead of printing
+			catchLine := strings.Count(src[:strings.Index(src, "<catch")], "\n") + 1
+			if output.String() != "" || runtimeError != nil && runtimeError.Node.GetSpan().Start.Line == catchLine {
+				t.Errorf("%s (tree walker: %v): the error was caught: %q, %v", test.name, treeWalker, output.String(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package xmlp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"xml-programming/internal/analysis"
+	"xml-programming/internal/ast"
+	"xml-programming/internal/parser"
+	"xml-programming/internal/scope"
+	"xml-programming/internal/vm"
+)
+
+// Session evaluates inputs one after the other, like a REPL: whatever an input declares is visible to the inputs after it.
+// Sessions always run on the tree walker, since the bytecode VM keeps variables in the frames of a single program.
+type Session struct {
+	interpreter *Interpreter
+	parser      *parser.Parser
+	records     []ast.RecordDeclaration
+	enums       []ast.EnumDeclaration
+
+	// every input gets its own scope below the ones before, so that declaring a name again shadows the old one;
+	// types is what the analysis knows about the declarations, values what they are at runtime
+	types  *scope.Scope
+	values *scope.Scope
+	// what the analysis knows about whether the variables are assigned
+	state *analysis.State
+
+	last *ast.Program
+}
+
+// NewSession starts a session with nothing declared but the interpreter's functions.
+func (i *Interpreter) NewSession() *Session {
+	s := &Session{
+		interpreter: i,
+	}
+	s.Reset()
+	return s
+}
+
+// Reset forgets everything the inputs so far declared.
+func (s *Session) Reset() {
+	s.parser = parser.NewParser()
+	s.records = nil
+	s.enums = nil
+	s.types = scope.FromParent(s.interpreter.globals)
+	s.values = scope.FromParent(s.interpreter.globals)
+	s.state = analysis.NewState()
+	s.last = nil
+}
+
+// Eval runs src, which is what would be inside of <program>. If src is a single expression,
+// or a call of a function that returns something, its value is printed like <output> would.
+// The diagnostics are empty and the error nil if the input ran successfully.
+func (s *Session) Eval(ctx context.Context, filename string, src []byte) ([]Diagnostic, error) {
+	declared := s.declaredTypes()
+
+	program, err := s.parser.ParseFragment(filename, src)
+	if errors.Is(err, parser.ErrUnknownStatement) {
+		if expression, expressionErr := s.parseExpression(filename, src); expressionErr == nil {
+			program, err = &ast.Program{Statements: []ast.Statement{output(expression)}}, nil
+		}
+	}
+	if err != nil {
+		s.parser.Types = declared
+		return []Diagnostic{analysis.FromError(analysis.ParseError, err)}, nil
+	}
+
+	if len(program.Statements) == 1 {
+		if call, ok := program.Statements[0].(ast.FunctionCall); ok {
+			_type, diagnostics := analysis.ExpressionType(call, s.declarations(program), s.types)
+			if !diagnostics.HasErrors() && _type != ast.Void {
+				program.Statements[0] = output(call)
+			}
+		}
+	}
+
+	diagnostics, err := s.run(ctx, program)
+	if diagnostics.HasErrors() {
+		s.parser.Types = declared
+	}
+	return diagnostics, err
+}
+
+// Load runs a whole program, including its <program> element, as an input of the session.
+func (s *Session) Load(ctx context.Context, filename string, src []byte) ([]Diagnostic, error) {
+	declared := s.declaredTypes()
+
+	program, err := s.parser.Parse(filename, src)
+	if err != nil {
+		s.parser.Types = declared
+		return []Diagnostic{analysis.FromError(analysis.ParseError, err)}, nil
+	}
+
+	diagnostics, err := s.run(ctx, program)
+	if diagnostics.HasErrors() {
+		s.parser.Types = declared
+	}
+	return diagnostics, err
+}
+
+// TypeOf returns the type of the expression in src without evaluating it.
+func (s *Session) TypeOf(filename string, src []byte) (Type, []Diagnostic) {
+	expression, err := s.parseExpression(filename, src)
+	if err != nil {
+		return ast.Invalid, []Diagnostic{analysis.FromError(analysis.ParseError, err)}
+	}
+	_type, diagnostics := analysis.ExpressionType(expression, s.declarations(&ast.Program{}), s.types)
+	return _type, diagnostics
+}
+
+// LastAST returns the syntax tree of the last input that could be parsed, or an empty string if there is none.
+func (s *Session) LastAST() string {
+	if s.last == nil {
+		return ""
+	}
+	builder := strings.Builder{}
+	_ = ast.Fprint(&builder, s.last)
+	return builder.String()
+}
+
+func (s *Session) run(ctx context.Context, program *ast.Program) (analysis.Diagnostics, error) {
+	s.last = program
+	program = s.declarations(program)
+
+	types := scope.FromParent(s.types)
+	diagnostics, state := analysis.StaticAnalysisInScope(program, types, s.state)
+	if diagnostics.HasErrors() {
+		return diagnostics, nil
+	}
+	s.records = program.Records
+	s.enums = program.Enums
+	s.types = types
+
+	// declarations that ran before a runtime error are kept, like in any other REPL
+	s.values = scope.FromParent(s.values)
+	err := vm.RunInScope(ctx, program, s.values, s.interpreter.options)
+	if err != nil {
+		state = state.Failed(s.state)
+	}
+	s.state = state
+	return diagnostics, err
+}
+
+// declarations returns program with the types of the earlier inputs declared before its own
+func (s *Session) declarations(program *ast.Program) *ast.Program {
+	return &ast.Program{
+		Records:    append(append([]ast.RecordDeclaration{}, s.records...), program.Records...),
+		Enums:      append(append([]ast.EnumDeclaration{}, s.enums...), program.Enums...),
+		Statements: program.Statements,
+	}
+}
+
+// declaredTypes copies the types the parser knows, so they can be restored if an input declaring more fails
+func (s *Session) declaredTypes() map[string]ast.Type {
+	types := make(map[string]ast.Type, len(s.parser.Types))
+	for name, _type := range s.parser.Types {
+		types[name] = _type
+	}
+	return types
+}
+
+func (s *Session) parseExpression(filename string, src []byte) (ast.Expression, error) {
+	elements, err := parser.ReadFragment(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) != 1 {
+		return nil, ast.Errorf(ast.Span{File: filename}, "expected a single expression, got %d elements", len(elements))
+	}
+	return s.parser.ParseExpression(elements[0])
+}
+
+func output(expression ast.Expression) ast.Statement {
+	return ast.OutputStatement{
+		Node:  ast.Node{Span: expression.GetSpan()},
+		Exprs: []ast.Expression{expression},
+	}
+}
+
+// Incomplete reports whether src stops in the middle of an element, so that a REPL has to read more lines.
+func Incomplete(src []byte) bool {
+	return parser.Incomplete(src)
+}
@@ -0,0 +1,46 @@
+package xmlp_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"xml-programming/xmlp"
+)
+
+func TestSessionTracksAssignments(t *testing.T) {
+	inputs := []struct {
+		src    string
+		output string
+		// the code of the diagnostic the input fails with
+		code string
+	}{
+		{src: `<declare name="x" type="int"/>`},
+		{src: `<output><var name="x"/></output>`, code: "unassigned-read"},
+		{src: `<assign name="x"><int>4</int></assign>`},
+		{src: `<var name="x"/>`, output: "4\n"},
+		{src: `<declare name="w" type="int"/><func name="g"><args><returns type="int"/></args><body><return><var name="w"/></return></body></func>`},
+		{src: `<call name="g"/>`, code: "unassigned-read"},
+		{src: `<assign name="w"><int>7</int></assign>`},
+		{src: `<call name="g"/>`, output: "7\n"},
+		// it isn't known how far an input that fails at runtime ran
+		{src: `<declare name="y" type="int"/><output><div><int>1</int><int>0</int></div></output><assign name="y"><int>1</int></assign>`},
+		{src: `<var name="y"/>`, code: "use-before-declare"},
+	}
+
+	var output strings.Builder
+	session := xmlp.New(xmlp.WithOutput(&output)).NewSession()
+	for _, input := range inputs {
+		output.Reset()
+		diagnostics, _ := session.Eval(context.Background(), "", []byte(input.src))
+		code := ""
+		if len(diagnostics) > 0 {
+			code = string(diagnostics[0].Code)
+		}
+		if code != input.code {
+			t.Errorf("%s: expected diagnostic %q, got %v", input.src, input.code, diagnostics)
+		}
+		if output.String() != input.output {
+			t.Errorf("%s: expected output %q, got %q", input.src, input.output, output.String())
+		}
+	}
+}